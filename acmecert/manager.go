@@ -0,0 +1,135 @@
+// Package acmecert obtains and renews TLS certificates from an ACME CA such as Let's Encrypt
+package acmecert
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// DefaultDirectoryURL is the directory URL of the Let's Encrypt production CA
+	DefaultDirectoryURL = acme.LetsEncryptURL
+	// DefaultRenewBefore is the duration before expiry at which a certificate is renewed
+	DefaultRenewBefore = 30 * 24 * time.Hour
+	// ChallengePath is the path prefix of HTTP-01 challenge requests
+	ChallengePath = "/.well-known/acme-challenge/"
+)
+
+// Options represents the settings of a Manager
+type Options struct {
+	// Domains the certificates are issued for, wildcards are not supported
+	Domains []string
+	// CacheDir is the directory the account key and certificates are stored in, per CA
+	CacheDir string
+	// DirectoryURL of the ACME CA, DefaultDirectoryURL is used when empty
+	DirectoryURL string
+	// Email is the contact address of the ACME account, optional
+	Email string
+	// RenewBefore is the duration before expiry certificates are renewed, DefaultRenewBefore is used when 0
+	RenewBefore time.Duration
+	// HTTPClient is used to reach the CA, http.DefaultClient is used when nil
+	HTTPClient *http.Client
+}
+
+// Manager serves certificates for the configured domains, which autocert obtains on the first handshake
+// and renews in the background
+// It answers HTTP-01 challenges with HTTPHandler and TLS-ALPN-01 challenges with GetCertificate
+// It is safe for concurrent use
+type Manager struct {
+	*autocert.Manager
+	// defaultDomain is served to clients without SNI
+	defaultDomain string
+}
+
+// New creates a manager for the domains of the options
+func New(opts Options) (*Manager, error) {
+	if opts.DirectoryURL == "" {
+		opts.DirectoryURL = DefaultDirectoryURL
+	}
+	if opts.RenewBefore <= 0 {
+		opts.RenewBefore = DefaultRenewBefore
+	}
+	dirURL, err := url.Parse(opts.DirectoryURL)
+	if err != nil || dirURL.Host == "" {
+		return nil, fmt.Errorf("invalid ACME directory URL %q", opts.DirectoryURL)
+	}
+	if opts.CacheDir == "" {
+		return nil, fmt.Errorf("an ACME cache directory is required")
+	}
+
+	var domains []string
+	seen := map[string]bool{}
+	for _, domain := range opts.Domains {
+		domain = normalizeDomain(domain)
+		switch {
+		case domain == "":
+			return nil, fmt.Errorf("invalid ACME domain: empty domain")
+		case strings.Contains(domain, "*"):
+			return nil, fmt.Errorf("invalid ACME domain %s: wildcards are not supported", domain)
+		case seen[domain]:
+			continue
+		}
+		seen[domain] = true
+		domains = append(domains, domain)
+	}
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("at least one ACME domain is required")
+	}
+
+	// Accounts and certificates are kept per CA, so switching CAs does not serve a certificate of the other CA
+	cacheDir := filepath.Join(opts.CacheDir, strings.Replace(dirURL.Host, ":", "_", -1))
+	err = os.MkdirAll(cacheDir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ACME cache directory: %s", err)
+	}
+
+	return &Manager{
+		Manager: &autocert.Manager{
+			Prompt:      autocert.AcceptTOS,
+			Cache:       autocert.DirCache(cacheDir),
+			HostPolicy:  autocert.HostWhitelist(domains...),
+			RenewBefore: opts.RenewBefore,
+			Email:       opts.Email,
+			Client: &acme.Client{
+				DirectoryURL: opts.DirectoryURL,
+				HTTPClient:   opts.HTTPClient,
+				UserAgent:    "gotiny",
+			},
+		},
+		defaultDomain: domains[0],
+	}, nil
+}
+
+// TLSConfig returns a TLS config that serves the certificates and answers TLS-ALPN-01 challenges
+func (m *Manager) TLSConfig() *tls.Config {
+	config := m.Manager.TLSConfig()
+	config.GetCertificate = m.GetCertificate
+
+	return config
+}
+
+// GetCertificate returns the certificate of the requested domain, see autocert.Manager.GetCertificate
+// Clients without SNI, e.g. connecting to an IP address, get the certificate of the first domain
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello.ServerName == "" {
+		withName := *hello
+		withName.ServerName = m.defaultDomain
+		hello = &withName
+	}
+
+	return m.Manager.GetCertificate(hello)
+}
+
+// normalizeDomain returns the domain in lower case without trailing dot
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
@@ -0,0 +1,357 @@
+package acmecert_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chrisvdg/gotiny/acmecert"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCA is a minimal ACME CA that validates challenges through validate
+type fakeCA struct {
+	*httptest.Server
+	validate func(typ string, domain string, token string) error
+
+	mu      sync.Mutex
+	key     *ecdsa.PrivateKey
+	cert    *x509.Certificate
+	orders  int
+	domains []string
+	authz   map[string]string // Status of the authorizations by domain
+	issued  []byte
+}
+
+func newFakeCA(t *testing.T) *fakeCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	ca := &fakeCA{key: key, cert: cert, authz: map[string]string{}}
+	ca.Server = httptest.NewServer(http.HandlerFunc(ca.serve))
+
+	return ca
+}
+
+// serve handles the ACME requests, the signatures of requests are not verified
+func (ca *fakeCA) serve(res http.ResponseWriter, req *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	res.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	var payload []byte
+	if req.Method == http.MethodPost {
+		var jws struct {
+			Payload string `json:"payload"`
+		}
+		json.NewDecoder(req.Body).Decode(&jws)
+		payload, _ = base64.RawURLEncoding.DecodeString(jws.Payload)
+	}
+
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch parts[0] {
+	case "directory":
+		ca.writeJSON(res, http.StatusOK, map[string]string{
+			"newNonce":   ca.URL + "/nonce",
+			"newAccount": ca.URL + "/account",
+			"newOrder":   ca.URL + "/order",
+		})
+	case "nonce":
+		res.WriteHeader(http.StatusOK)
+	case "account":
+		res.Header().Set("Location", ca.URL+"/account/1")
+		ca.writeJSON(res, http.StatusCreated, map[string]string{"status": "valid"})
+	case "order":
+		if len(parts) == 1 {
+			var order struct {
+				Identifiers []struct{ Value string }
+			}
+			json.Unmarshal(payload, &order)
+			ca.orders++
+			ca.domains = nil
+			for _, id := range order.Identifiers {
+				ca.domains = append(ca.domains, id.Value)
+				ca.authz[id.Value] = "pending"
+			}
+			res.Header().Set("Location", ca.URL+"/order/1")
+			ca.writeJSON(res, http.StatusCreated, ca.order())
+			return
+		}
+		res.Header().Set("Location", ca.URL+"/order/1")
+		ca.writeJSON(res, http.StatusOK, ca.order())
+	case "authz":
+		ca.writeJSON(res, http.StatusOK, ca.authorization(parts[1]))
+	case "challenge":
+		domain, typ := parts[1], parts[2]
+		ca.mu.Unlock()
+		err := ca.validate(typ, domain, "token-"+domain)
+		ca.mu.Lock()
+		ca.authz[domain] = "valid"
+		if err != nil {
+			ca.authz[domain] = "invalid"
+		}
+		ca.writeJSON(res, http.StatusOK, map[string]string{"type": typ, "url": req.URL.String(), "status": ca.authz[domain]})
+	case "finalize":
+		var finalize struct{ CSR string }
+		json.Unmarshal(payload, &finalize)
+		der, _ := base64.RawURLEncoding.DecodeString(finalize.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			ca.writeJSON(res, http.StatusBadRequest, map[string]string{"detail": err.Error()})
+			return
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		}
+		leaf, _ := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+		ca.issued = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}),
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...)
+		res.Header().Set("Location", ca.URL+"/order/1")
+		ca.writeJSON(res, http.StatusOK, ca.order())
+	case "cert":
+		res.Header().Set("Content-Type", "application/pem-certificate-chain")
+		res.Write(ca.issued)
+	default:
+		res.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// order returns the current order
+func (ca *fakeCA) order() map[string]interface{} {
+	status := "ready"
+	var authz []string
+	for _, domain := range ca.domains {
+		authz = append(authz, ca.URL+"/authz/"+domain)
+		switch ca.authz[domain] {
+		case "invalid":
+			status = "invalid"
+		case "pending":
+			if status != "invalid" {
+				status = "pending"
+			}
+		}
+	}
+	order := map[string]interface{}{
+		"status":         status,
+		"authorizations": authz,
+		"finalize":       ca.URL + "/finalize/1",
+	}
+	if ca.issued != nil {
+		order["status"] = "valid"
+		order["certificate"] = ca.URL + "/cert/1"
+	}
+
+	return order
+}
+
+// authorization returns the authorization of the domain
+func (ca *fakeCA) authorization(domain string) map[string]interface{} {
+	var challenges []map[string]string
+	for _, typ := range []string{"http-01", "tls-alpn-01"} {
+		challenges = append(challenges, map[string]string{
+			"type":  typ,
+			"url":   ca.URL + "/challenge/" + domain + "/" + typ,
+			"token": "token-" + domain,
+		})
+	}
+
+	return map[string]interface{}{
+		"status":     ca.authz[domain],
+		"identifier": map[string]string{"type": "dns", "value": domain},
+		"challenges": challenges,
+	}
+}
+
+func (ca *fakeCA) writeJSON(res http.ResponseWriter, status int, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(v)
+}
+
+// validateHTTP requests the HTTP-01 challenge response from the handler
+func validateHTTP(handler http.Handler, token string) error {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", acmecert.ChallengePath+token, nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), token+".") {
+		return fmt.Errorf("invalid challenge response %d %q", rec.Code, rec.Body.String())
+	}
+
+	return nil
+}
+
+// validateTLSALPN performs a TLS-ALPN-01 handshake with the TLS config
+func validateTLSALPN(config *tls.Config, domain string) error {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		tls.Server(serverConn, config).Handshake()
+		serverConn.Close()
+	}()
+
+	conn := tls.Client(clientConn, &tls.Config{
+		ServerName:         domain,
+		NextProtos:         []string{"acme-tls/1"},
+		InsecureSkipVerify: true,
+	})
+	err := conn.Handshake()
+	if err != nil {
+		return err
+	}
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != "acme-tls/1" {
+		return fmt.Errorf("negotiated %q", state.NegotiatedProtocol)
+	}
+	cert := state.PeerCertificates[0]
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != domain {
+		return fmt.Errorf("invalid challenge certificate names %v", cert.DNSNames)
+	}
+	for _, ext := range cert.Extensions {
+		// id-pe-acmeIdentifier
+		if ext.Id.String() == "1.3.6.1.5.5.7.1.31" {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("challenge certificate lacks the acme identifier")
+}
+
+func Test_Manager(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "acmecert_test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	ca := newFakeCA(t)
+	defer ca.Close()
+
+	opts := acmecert.Options{
+		Domains:      []string{"Example.com", "www.example.com."},
+		CacheDir:     dir,
+		DirectoryURL: ca.URL + "/directory",
+	}
+	m, err := acmecert.New(opts)
+	assert.NoError(err)
+	fallback := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusTeapot)
+	})
+	// HTTP-01 challenges are only tried once the HTTP handler is in use
+	handler := m.HTTPHandler(fallback)
+	// Only answer HTTP-01 challenges, so the manager has to fall back from TLS-ALPN-01
+	var validated []string
+	ca.validate = func(typ string, domain string, token string) error {
+		validated = append(validated, typ+" "+domain)
+		if typ != "http-01" {
+			return fmt.Errorf("unreachable")
+		}
+		return validateHTTP(handler, token)
+	}
+
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "www.example.com"})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal([]string{"www.example.com"}, cert.Leaf.DNSNames)
+	assert.Equal([]string{"tls-alpn-01 www.example.com", "http-01 www.example.com"}, validated)
+	assert.Equal(2, ca.orders)
+
+	_, err = m.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.com"})
+	assert.Error(err, "Certificates should only be served for the domains")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/tiny", nil))
+	assert.Equal(http.StatusTeapot, rec.Code, "Other requests should be passed to the fallback")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", acmecert.ChallengePath+"token-www.example.com", nil))
+	assert.Equal(http.StatusNotFound, rec.Code, "Challenge responses should be removed once validated")
+
+	// The certificate is cached
+	m, err = acmecert.New(opts)
+	assert.NoError(err)
+	cached, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "www.example.com"})
+	assert.NoError(err)
+	assert.Equal(cert.Certificate, cached.Certificate)
+	assert.Equal(2, ca.orders, "Cached certificate should be used")
+}
+
+func Test_ManagerTLSALPN(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "acmecert_test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	ca := newFakeCA(t)
+	defer ca.Close()
+
+	m, err := acmecert.New(acmecert.Options{
+		Domains:      []string{"Example.com."},
+		CacheDir:     dir,
+		DirectoryURL: ca.URL + "/directory",
+	})
+	assert.NoError(err)
+	ca.validate = func(typ string, domain string, token string) error {
+		if typ != "tls-alpn-01" {
+			return fmt.Errorf("unexpected challenge %s", typ)
+		}
+		return validateTLSALPN(m.TLSConfig(), domain)
+	}
+
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal([]string{"example.com"}, cert.Leaf.DNSNames)
+	assert.Equal(1, ca.orders)
+
+	// Clients without SNI get the certificate of the first domain
+	cached, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	assert.NoError(err)
+	assert.Equal(cert.Certificate, cached.Certificate)
+	assert.Equal(1, ca.orders)
+}
+
+func Test_NewInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acmecert_test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, opts := range []acmecert.Options{
+		{CacheDir: dir},
+		{Domains: []string{"*.example.com"}, CacheDir: dir},
+		{Domains: []string{" "}, CacheDir: dir},
+		{Domains: []string{"example.com"}},
+		{Domains: []string{"example.com"}, CacheDir: dir, DirectoryURL: "localhost"},
+	} {
+		_, err := acmecert.New(opts)
+		assert.Error(t, err, "%+v", opts)
+	}
+}
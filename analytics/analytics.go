@@ -0,0 +1,116 @@
+// Package analytics records the hits of followed tiny URLs and aggregates them into statistics
+package analytics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// User agent classes
+const (
+	UAClassDesktop = "desktop"
+	UAClassMobile  = "mobile"
+	UAClassTool    = "tool"
+	UAClassOther   = "other"
+)
+
+// mobileUAMarkers are user agent substrings of mobile browsers
+var mobileUAMarkers = []string{"mobi", "android", "iphone", "ipad", "ipod", "windows phone"}
+
+// toolUAPrefixes are user agent prefixes of command line tools and HTTP libraries
+var toolUAPrefixes = []string{
+	"curl/", "wget/", "httpie/", "python-requests/", "python-urllib/", "go-http-client/",
+	"okhttp/", "java/", "libwww-perl/", "axios/", "node-fetch/", "powershell/",
+}
+
+// Hit represents a single follow of a tiny URL
+type Hit struct {
+	ID   string
+	Time time.Time
+	// ReferrerHost is the host of the referring page, empty when there was none
+	ReferrerHost string
+	UAClass      string
+	// ClientIP is the IP of the client, the Recorder replaces it by IPHash so it is never stored
+	ClientIP string
+	// IPHash is a salted hash of the client IP, used to count unique visitors
+	IPHash string
+
+	// Request details used to classify the hit
+	Method    string
+	UserAgent string
+	Prefetch  bool
+	// Bot is set by the Recorder when the Classifier flagged the hit,
+	// such hits are counted separately from visits
+	Bot bool
+}
+
+// NewHit returns the hit of a request following the tiny URL with the provided ID
+func NewHit(id string, req *http.Request) Hit {
+	return Hit{
+		ID:           id,
+		Time:         time.Now(),
+		ReferrerHost: referrerHost(req.Referer()),
+		UAClass:      ClassifyUserAgent(req.UserAgent()),
+		ClientIP:     clientIP(req),
+		Method:       req.Method,
+		UserAgent:    req.UserAgent(),
+		Prefetch:     isPrefetch(req),
+	}
+}
+
+// ClassifyUserAgent returns the class of a user agent
+func ClassifyUserAgent(ua string) string {
+	ua = strings.ToLower(ua)
+	for _, prefix := range toolUAPrefixes {
+		if strings.HasPrefix(ua, prefix) {
+			return UAClassTool
+		}
+	}
+	for _, marker := range mobileUAMarkers {
+		if strings.Contains(ua, marker) {
+			return UAClassMobile
+		}
+	}
+	if strings.HasPrefix(ua, "mozilla/") {
+		return UAClassDesktop
+	}
+
+	return UAClassOther
+}
+
+// HashIP returns the salted hash of an IP
+func HashIP(ip string, salt []byte) string {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(ip))
+
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// referrerHost returns the lower case host of a referrer URL
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return ""
+	}
+	u, err := url.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Hostname())
+}
+
+// clientIP returns the IP of the client that sent the request
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,56 @@
+package analytics_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chrisvdg/gotiny/analytics"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ClassifyUserAgent(t *testing.T) {
+	assert := assert.New(t)
+
+	tt := []struct {
+		ua       string
+		expected string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/83.0.4103.61 Safari/537.36", analytics.UAClassDesktop},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 13_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/13.1.1 Mobile/15E148 Safari/604.1", analytics.UAClassMobile},
+		{"Mozilla/5.0 (Linux; Android 10; SM-G973F) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/83.0.4103.106 Mobile Safari/537.36", analytics.UAClassMobile},
+		{"curl/7.68.0", analytics.UAClassTool},
+		{"Go-http-client/1.1", analytics.UAClassTool},
+		{"", analytics.UAClassOther},
+		{"SomethingElse/1.0", analytics.UAClassOther},
+	}
+
+	for _, tc := range tt {
+		assert.Equal(tc.expected, analytics.ClassifyUserAgent(tc.ua), tc.ua)
+	}
+}
+
+func Test_NewHit(t *testing.T) {
+	assert := assert.New(t)
+
+	req := httptest.NewRequest("GET", "/api/tiny/foo", nil)
+	req.RemoteAddr = "192.0.2.1:51234"
+	req.Header.Set("Referer", "https://News.Example.com/article?id=1")
+	req.Header.Set("User-Agent", "curl/7.68.0")
+
+	hit := analytics.NewHit("foo", req)
+	assert.Equal("foo", hit.ID)
+	assert.Equal("news.example.com", hit.ReferrerHost)
+	assert.Equal(analytics.UAClassTool, hit.UAClass)
+	assert.Equal("192.0.2.1", hit.ClientIP)
+	assert.False(hit.Time.IsZero())
+}
+
+func Test_HashIP(t *testing.T) {
+	assert := assert.New(t)
+
+	hash := analytics.HashIP("192.0.2.1", []byte("salt"))
+	assert.Equal(hash, analytics.HashIP("192.0.2.1", []byte("salt")))
+	assert.NotEqual(hash, analytics.HashIP("192.0.2.2", []byte("salt")))
+	assert.NotEqual(hash, analytics.HashIP("192.0.2.1", []byte("pepper")))
+	assert.NotContains(hash, "192.0.2.1")
+}
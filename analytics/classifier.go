@@ -0,0 +1,80 @@
+package analytics
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Reasons a hit is flagged as not being a visit of a person
+const (
+	FlagHead      = "head"
+	FlagPrefetch  = "prefetch"
+	FlagUserAgent = "user-agent"
+)
+
+// DefaultBotUserAgents contains user agent substrings of common crawlers, link unfurlers and scanners
+var DefaultBotUserAgents = []string{
+	"bot", "crawl", "spider", "slurp", "archiver", "scanner",
+	"facebookexternalhit", "whatsapp", "skypeuripreview", "bingpreview", "microsoftpreview",
+	"quora link preview", "embedly", "iframely", "mastodon", "pinterest", "vkshare",
+	"google-safety", "headlesschrome", "phantomjs", "preview",
+}
+
+// NewClassifier returns a new classifier that flags user agents containing one of the provided substrings,
+// matched case insensitively
+// DefaultBotUserAgents is used when botUserAgents is empty
+func NewClassifier(botUserAgents []string) *Classifier {
+	if len(botUserAgents) == 0 {
+		botUserAgents = DefaultBotUserAgents
+	}
+	c := &Classifier{}
+	for _, ua := range botUserAgents {
+		ua = strings.ToLower(strings.TrimSpace(ua))
+		if ua != "" {
+			c.botUserAgents = append(c.botUserAgents, ua)
+		}
+	}
+
+	return c
+}
+
+// Classifier flags hits of bots and prefetches, which are not visits of a person
+// It is safe for concurrent use
+type Classifier struct {
+	botUserAgents []string
+}
+
+// Classify returns the reason the hit is flagged, empty when it is not flagged
+// HEAD requests, prefetches and requests without or with a known bot user agent are flagged
+func (c *Classifier) Classify(hit Hit) string {
+	if hit.Method == http.MethodHead {
+		return FlagHead
+	}
+	if hit.Prefetch {
+		return FlagPrefetch
+	}
+
+	ua := strings.ToLower(hit.UserAgent)
+	if ua == "" {
+		return FlagUserAgent
+	}
+	for _, bot := range c.botUserAgents {
+		if strings.Contains(ua, bot) {
+			return FlagUserAgent
+		}
+	}
+
+	return ""
+}
+
+// isPrefetch returns true if the request headers mark it as a prefetch or prerender
+func isPrefetch(req *http.Request) bool {
+	for _, header := range []string{"Purpose", "Sec-Purpose", "X-Purpose", "X-Moz"} {
+		v := strings.ToLower(req.Header.Get(header))
+		if strings.Contains(v, "prefetch") || strings.Contains(v, "prerender") || strings.Contains(v, "preview") {
+			return true
+		}
+	}
+
+	return false
+}
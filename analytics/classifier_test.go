@@ -0,0 +1,50 @@
+package analytics_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chrisvdg/gotiny/analytics"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Classify(t *testing.T) {
+	assert := assert.New(t)
+	c := analytics.NewClassifier(nil)
+
+	tt := []struct {
+		method   string
+		ua       string
+		headers  map[string]string
+		expected string
+	}{
+		{"GET", browserUA, nil, ""},
+		{"GET", "curl/7.68.0", nil, ""},
+		{"HEAD", browserUA, nil, analytics.FlagHead},
+		{"GET", browserUA, map[string]string{"Purpose": "prefetch"}, analytics.FlagPrefetch},
+		{"GET", browserUA, map[string]string{"Sec-Purpose": "prefetch;prerender"}, analytics.FlagPrefetch},
+		{"GET", browserUA, map[string]string{"X-Moz": "prefetch"}, analytics.FlagPrefetch},
+		{"GET", "", nil, analytics.FlagUserAgent},
+		{"GET", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", nil, analytics.FlagUserAgent},
+		{"GET", "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", nil, analytics.FlagUserAgent},
+		{"GET", "WhatsApp/2.19.81 A", nil, analytics.FlagUserAgent},
+		{"GET", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", nil, analytics.FlagUserAgent},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(tc.method, "/api/tiny/foo", nil)
+		req.Header.Set("User-Agent", tc.ua)
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		assert.Equal(tc.expected, c.Classify(analytics.NewHit("foo", req)), "%s %s %v", tc.method, tc.ua, tc.headers)
+	}
+}
+
+func Test_ClassifyCustomRules(t *testing.T) {
+	assert := assert.New(t)
+	c := analytics.NewClassifier([]string{" InternalMonitor ", ""})
+
+	assert.Equal(analytics.FlagUserAgent, c.Classify(analytics.Hit{Method: "GET", UserAgent: "internalmonitor/1.0"}))
+	assert.Equal("", c.Classify(analytics.Hit{Method: "GET", UserAgent: "Googlebot/2.1"}), "Custom rules should replace the default rules")
+}
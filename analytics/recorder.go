@@ -0,0 +1,163 @@
+package analytics
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultBufferSize is the default amount of hits buffered before new hits are dropped
+const DefaultBufferSize = 4096
+
+// DefaultFlushInterval is the default interval at which buffered hits are recorded in the store
+const DefaultFlushInterval = 5 * time.Second
+
+// RecorderOptions represents optional settings for the recorder
+type RecorderOptions struct {
+	// BufferSize is the amount of hits buffered before new hits are dropped,
+	// DefaultBufferSize is used when 0 or lower
+	BufferSize int
+	// FlushInterval is the interval at which buffered hits are recorded in the store,
+	// DefaultFlushInterval is used when 0 or lower
+	FlushInterval time.Duration
+	// Salt is mixed into the client IP hashes, a random salt is used when empty
+	// With a random salt the hashes of the same client differ between restarts
+	Salt []byte
+	// Classifier flags hits of bots and prefetches,
+	// a classifier with DefaultBotUserAgents is used when nil
+	Classifier *Classifier
+	// SkipBots drops flagged hits instead of counting them separately
+	SkipBots bool
+}
+
+// NewRecorder returns a new recorder that records hits in the provided store
+func NewRecorder(store Store, opts RecorderOptions) *Recorder {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	if opts.Classifier == nil {
+		opts.Classifier = NewClassifier(nil)
+	}
+	if len(opts.Salt) == 0 {
+		opts.Salt = make([]byte, 32)
+		_, err := rand.Read(opts.Salt)
+		if err != nil {
+			log.Errorf("Failed to generate client IP hash salt: %s", err)
+		}
+	}
+
+	r := &Recorder{
+		store:         store,
+		salt:          opts.Salt,
+		classifier:    opts.Classifier,
+		skipBots:      opts.SkipBots,
+		flushInterval: opts.FlushInterval,
+		batchSize:     opts.BufferSize,
+		hits:          make(chan Hit, opts.BufferSize),
+		done:          make(chan struct{}),
+	}
+	go r.run()
+
+	return r
+}
+
+// Recorder buffers hits and records them in the store in the background,
+// so recording a hit does not wait for the store
+// It is safe for concurrent use
+type Recorder struct {
+	store         Store
+	salt          []byte
+	classifier    *Classifier
+	skipBots      bool
+	flushInterval time.Duration
+	batchSize     int
+
+	mu     sync.RWMutex
+	closed bool
+	hits   chan Hit
+	done   chan struct{}
+}
+
+// Record classifies and buffers a hit, replacing its client IP by a salted hash
+// returns false when the hit was dropped because it was flagged and bots are skipped,
+// the buffer is full or the recorder is closed
+func (r *Recorder) Record(hit Hit) bool {
+	if reason := r.classifier.Classify(hit); reason != "" {
+		if r.skipBots {
+			return false
+		}
+		hit.Bot = true
+	}
+	if hit.ClientIP != "" {
+		hit.IPHash = HashIP(hit.ClientIP, r.salt)
+		hit.ClientIP = ""
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return false
+	}
+	select {
+	case r.hits <- hit:
+		return true
+	default:
+		log.Debugf("Analytics buffer is full, dropping hit of %s", hit.ID)
+		return false
+	}
+}
+
+// Close records the buffered hits and closes the store
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.hits)
+	r.mu.Unlock()
+
+	<-r.done
+	return r.store.Close()
+}
+
+// run records the buffered hits in batches until the recorder is closed
+func (r *Recorder) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+
+	var batch []Hit
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := r.store.Record(batch)
+		if err != nil {
+			log.Errorf("Failed to record %d hits: %s", len(batch), err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case hit, ok := <-r.hits:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, hit)
+			if len(batch) >= r.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package analytics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chrisvdg/gotiny/analytics"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test_RecorderFlushesOnClose tests that buffered hits are recorded when the recorder is closed
+func Test_RecorderFlushesOnClose(t *testing.T) {
+	assert := assert.New(t)
+	s := analytics.NewMemoryStore()
+	r := analytics.NewRecorder(s, analytics.RecorderOptions{FlushInterval: time.Hour})
+
+	for i := 0; i < 10; i++ {
+		assert.True(r.Record(analytics.Hit{ID: "foo", Time: time.Now(), UserAgent: browserUA, ClientIP: "192.0.2.1"}))
+	}
+	assert.NoError(r.Close())
+
+	stats, err := s.Stats("foo")
+	assert.NoError(err)
+	assert.Equal(10, stats.Total)
+	if assert.Len(stats.Days, 1) {
+		assert.Equal(1, stats.Days[0].Visitors)
+	}
+
+	assert.False(r.Record(analytics.Hit{ID: "foo", Time: time.Now(), UserAgent: browserUA}), "Hits should be dropped after close")
+	assert.NoError(r.Close())
+}
+
+func Test_RecorderFlushInterval(t *testing.T) {
+	assert := assert.New(t)
+	s := analytics.NewMemoryStore()
+	r := analytics.NewRecorder(s, analytics.RecorderOptions{FlushInterval: 10 * time.Millisecond})
+	defer r.Close()
+
+	r.Record(analytics.Hit{ID: "foo", Time: time.Now(), UserAgent: browserUA})
+	assert.Eventually(func() bool {
+		stats, err := s.Stats("foo")
+		return err == nil && stats.Total == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+// Test_RecorderHashesClientIP tests that client IPs are hashed with the configured salt
+func Test_RecorderHashesClientIP(t *testing.T) {
+	assert := assert.New(t)
+	s := &hitCollector{}
+	r := analytics.NewRecorder(s, analytics.RecorderOptions{Salt: []byte("salt")})
+
+	r.Record(analytics.Hit{ID: "foo", Time: time.Now(), UserAgent: browserUA, ClientIP: "192.0.2.1"})
+	assert.NoError(r.Close())
+
+	if assert.Len(s.hits, 1) {
+		assert.Empty(s.hits[0].ClientIP, "The client IP should not be recorded")
+		assert.Equal(analytics.HashIP("192.0.2.1", []byte("salt")), s.hits[0].IPHash)
+	}
+}
+
+// Test_RecorderBots tests that flagged hits are counted separately or skipped
+func Test_RecorderBots(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, skip := range []bool{false, true} {
+		s := analytics.NewMemoryStore()
+		r := analytics.NewRecorder(s, analytics.RecorderOptions{SkipBots: skip})
+		assert.True(r.Record(analytics.Hit{ID: "foo", Time: time.Now(), UserAgent: browserUA, ClientIP: "192.0.2.1"}))
+		assert.Equal(!skip, r.Record(analytics.Hit{ID: "foo", Time: time.Now(), UserAgent: "Twitterbot/1.0", ClientIP: "192.0.2.2"}))
+		assert.Equal(!skip, r.Record(analytics.Hit{ID: "foo", Time: time.Now(), Method: "HEAD", UserAgent: browserUA}))
+		assert.NoError(r.Close())
+
+		stats, err := s.Stats("foo")
+		assert.NoError(err)
+		assert.Equal(1, stats.Total)
+		expectedBots := 2
+		if skip {
+			expectedBots = 0
+		}
+		assert.Equal(expectedBots, stats.Bots)
+		if assert.Len(stats.Days, 1) {
+			assert.Equal(1, stats.Days[0].Hits)
+			assert.Equal(1, stats.Days[0].Visitors, "Bots should not count as visitors")
+			assert.Equal(expectedBots, stats.Days[0].Bots)
+		}
+	}
+}
+
+// browserUA is the user agent of a desktop browser
+const browserUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:76.0) Gecko/20100101 Firefox/76.0"
+
+// hitCollector is a store that keeps the recorded hits
+type hitCollector struct {
+	analytics.MemoryStore
+	hits []analytics.Hit
+}
+
+func (c *hitCollector) Record(hits []analytics.Hit) error {
+	c.hits = append(c.hits, hits...)
+	return nil
+}
@@ -0,0 +1,539 @@
+package analytics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+const filePerm os.FileMode = 0666
+
+// dayFormat is the format of the per day bucket keys
+const dayFormat = "2006-01-02"
+
+// Store aggregates recorded hits into statistics
+// Implementations must be safe for concurrent use
+type Store interface {
+	// Record adds the hits to the statistics
+	Record(hits []Hit) error
+	// Stats returns the statistics of the tiny URL with the provided ID
+	// Tiny URLs without hits have empty statistics
+	Stats(id string) (Stats, error)
+	// Remove removes the statistics of the tiny URL with the provided ID
+	Remove(id string) error
+	// Close flushes the statistics and closes the store
+	Close() error
+}
+
+// Stats represents the statistics of a tiny URL
+type Stats struct {
+	ID    string `json:"id"`
+	Total int    `json:"total"`
+	// Bots counts the hits flagged as bots or prefetches, they are not part of the other statistics
+	Bots    int               `json:"bots"`
+	LastHit *backend.JSONTime `json:"last_hit,omitempty"`
+	// Referrers counts the hits per referrer host, hits without referrer are not counted
+	Referrers  map[string]int `json:"referrers"`
+	UserAgents map[string]int `json:"user_agents"`
+	// Days contains the per day buckets in UTC, oldest first
+	Days []DayStats `json:"days"`
+}
+
+// DayStats represents the statistics of a tiny URL on a single day
+type DayStats struct {
+	Date string `json:"date"` // YYYY-MM-DD
+	Hits int    `json:"hits"`
+	// Visitors is the amount of unique client IPs
+	Visitors int `json:"visitors"`
+	Bots     int `json:"bots"`
+}
+
+// linkStats represents the stored statistics of a tiny URL
+type linkStats struct {
+	Total      int                  `json:"total"`
+	Bots       int                  `json:"bots"`
+	LastHit    backend.JSONTime     `json:"last_hit"`
+	Referrers  map[string]int       `json:"referrers"`
+	UserAgents map[string]int       `json:"user_agents"`
+	Days       map[string]*dayStats `json:"days"`
+}
+
+// dayStats represents the stored statistics of a tiny URL on a single day
+// The IP hashes of the visitors are only kept until the day is compacted into VisitorCount
+type dayStats struct {
+	Hits         int             `json:"hits"`
+	Visitors     map[string]bool `json:"visitors,omitempty"`
+	VisitorCount int             `json:"visitor_count,omitempty"`
+	Compacted    bool            `json:"compacted,omitempty"`
+	Bots         int             `json:"bots"`
+}
+
+// visitors returns the amount of unique visitors of the day
+func (d *dayStats) visitors() int {
+	return d.VisitorCount + len(d.Visitors)
+}
+
+// compact replaces the IP hashes of the visitors by their amount
+// Visitors of later hits of the day are no longer counted
+func (d *dayStats) compact() {
+	d.VisitorCount += len(d.Visitors)
+	d.Visitors = nil
+	d.Compacted = true
+}
+
+// NewMemoryStore returns a new store that keeps the statistics in memory only
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		data: map[string]*linkStats{},
+	}
+}
+
+// MemoryStore represents a store that keeps the statistics in memory
+// The visitor IP hashes of days before yesterday are compacted into their amount once a day
+// It is safe for concurrent use
+type MemoryStore struct {
+	mu   sync.RWMutex
+	data map[string]*linkStats
+	// compactedBefore is the day before which the visitors were last compacted
+	compactedBefore string
+}
+
+// Record implements Store.Record
+func (m *MemoryStore) Record(hits []Hit) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.compactVisitors(time.Now().UTC().AddDate(0, 0, -1).Format(dayFormat))
+	m.record(hits)
+
+	return nil
+}
+
+// record adds the hits to the statistics
+// the caller is expected to hold the lock
+func (m *MemoryStore) record(hits []Hit) {
+	for _, hit := range hits {
+		s, ok := m.data[hit.ID]
+		if !ok {
+			s = &linkStats{
+				Referrers:  map[string]int{},
+				UserAgents: map[string]int{},
+				Days:       map[string]*dayStats{},
+			}
+			m.data[hit.ID] = s
+		}
+		day := hit.Time.UTC().Format(dayFormat)
+		d, ok := s.Days[day]
+		if !ok {
+			d = &dayStats{}
+			s.Days[day] = d
+		}
+
+		if hit.Bot {
+			s.Bots++
+			d.Bots++
+			continue
+		}
+		s.Total++
+		if hit.Time.After(s.LastHit.Time()) {
+			s.LastHit = backend.JSONTime(hit.Time)
+		}
+		if hit.ReferrerHost != "" {
+			s.Referrers[hit.ReferrerHost]++
+		}
+		s.UserAgents[hit.UAClass]++
+		d.Hits++
+		if hit.IPHash != "" && !d.Compacted {
+			if d.Visitors == nil {
+				d.Visitors = map[string]bool{}
+			}
+			d.Visitors[hit.IPHash] = true
+		}
+	}
+}
+
+// compactVisitors compacts the visitors of the days before the provided day, once per day
+// the caller is expected to hold the lock
+func (m *MemoryStore) compactVisitors(before string) {
+	if before == m.compactedBefore {
+		return
+	}
+	for _, s := range m.data {
+		for day, d := range s.Days {
+			if day < before && !d.Compacted {
+				d.compact()
+			}
+		}
+	}
+	m.compactedBefore = before
+}
+
+// Stats implements Store.Stats
+func (m *MemoryStore) Stats(id string) (Stats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := Stats{
+		ID:         id,
+		Referrers:  map[string]int{},
+		UserAgents: map[string]int{},
+		Days:       []DayStats{},
+	}
+	s, ok := m.data[id]
+	if !ok {
+		return result, nil
+	}
+
+	result.Total = s.Total
+	result.Bots = s.Bots
+	if s.Total > 0 {
+		lastHit := s.LastHit
+		result.LastHit = &lastHit
+	}
+	for k, v := range s.Referrers {
+		result.Referrers[k] = v
+	}
+	for k, v := range s.UserAgents {
+		result.UserAgents[k] = v
+	}
+	for day, d := range s.Days {
+		result.Days = append(result.Days, DayStats{
+			Date:     day,
+			Hits:     d.Hits,
+			Visitors: d.visitors(),
+			Bots:     d.Bots,
+		})
+	}
+	sort.Slice(result.Days, func(i, j int) bool {
+		return result.Days[i].Date < result.Days[j].Date
+	})
+
+	return result, nil
+}
+
+// Remove implements Store.Remove
+func (m *MemoryStore) Remove(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.data, id)
+
+	return nil
+}
+
+// Close implements Store.Close
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// DefaultCompactSize is the journal size in bytes after which the statistics file is rewritten
+const DefaultCompactSize int64 = 4 << 20
+
+// journalSuffix is appended to the statistics file path to get the journal path
+const journalSuffix = ".journal"
+
+// NewFileStore returns a new store that keeps the statistics in a JSON file
+// and appends the changes to a journal next to it, see FileStore
+// The statistics are loaded from the file and the journal when they exist
+func NewFileStore(filePath string) (*FileStore, error) {
+	return NewFileStoreWithCompactSize(filePath, DefaultCompactSize)
+}
+
+// NewFileStoreWithCompactSize returns a new file store that rewrites the statistics file
+// when the journal grows past compactSize bytes, when 0 or lower DefaultCompactSize is used
+func NewFileStoreWithCompactSize(filePath string, compactSize int64) (*FileStore, error) {
+	if filePath == "" {
+		return nil, fmt.Errorf("no statistics file location provided")
+	}
+	if compactSize <= 0 {
+		compactSize = DefaultCompactSize
+	}
+	f := &FileStore{
+		MemoryStore: NewMemoryStore(),
+		filePath:    filePath,
+		journalPath: filePath + journalSuffix,
+		compactSize: compactSize,
+	}
+
+	err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	err = f.replay()
+	if err != nil {
+		return nil, fmt.Errorf("failed to replay statistics journal: %s", err)
+	}
+
+	return f, nil
+}
+
+// FileStore represents a store that keeps the statistics in memory
+// Every change is appended to a journal, when it grows past the compaction size
+// the statistics are written to the file and the journal is truncated
+// It is safe for concurrent use
+type FileStore struct {
+	*MemoryStore
+	filePath    string
+	journalPath string
+	compactSize int64
+
+	// saveMu keeps the journal in the order of the changes
+	saveMu  sync.Mutex
+	journal *os.File
+	size    int64
+	// seq is the sequence number of the last journal record
+	seq int64
+}
+
+// statsSnapshot represents the statistics file
+// Seq is the sequence number of the last journal record that is part of the statistics,
+// so records left behind by a crash during compaction are not applied twice
+type statsSnapshot struct {
+	Seq   int64                 `json:"seq"`
+	Stats map[string]*linkStats `json:"stats"`
+}
+
+// statsRecord represents a change in the journal, either recorded hits or a removal
+type statsRecord struct {
+	Seq    int64        `json:"seq"`
+	Hits   []journalHit `json:"hits,omitempty"`
+	Remove string       `json:"remove,omitempty"`
+}
+
+// journalHit represents the details of a hit that are part of the statistics
+type journalHit struct {
+	ID           string    `json:"id"`
+	Time         time.Time `json:"time"`
+	ReferrerHost string    `json:"referrer,omitempty"`
+	UAClass      string    `json:"ua_class"`
+	IPHash       string    `json:"ip_hash,omitempty"`
+	Bot          bool      `json:"bot,omitempty"`
+}
+
+// Record implements Store.Record
+func (f *FileStore) Record(hits []Hit) error {
+	f.saveMu.Lock()
+	defer f.saveMu.Unlock()
+
+	err := f.MemoryStore.Record(hits)
+	if err != nil {
+		return err
+	}
+
+	r := statsRecord{}
+	for _, hit := range hits {
+		r.Hits = append(r.Hits, journalHit{
+			ID:           hit.ID,
+			Time:         hit.Time,
+			ReferrerHost: hit.ReferrerHost,
+			UAClass:      hit.UAClass,
+			IPHash:       hit.IPHash,
+			Bot:          hit.Bot,
+		})
+	}
+
+	return f.append(r)
+}
+
+// Remove implements Store.Remove
+func (f *FileStore) Remove(id string) error {
+	f.saveMu.Lock()
+	defer f.saveMu.Unlock()
+
+	err := f.MemoryStore.Remove(id)
+	if err != nil {
+		return err
+	}
+
+	return f.append(statsRecord{Remove: id})
+}
+
+// Close implements Store.Close
+// The statistics are written to the file and the journal is closed
+func (f *FileStore) Close() error {
+	f.saveMu.Lock()
+	defer f.saveMu.Unlock()
+
+	err := f.compact()
+	closeErr := f.journal.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// load reads the statistics file, files written before the journal was added only contain the statistics
+func (f *FileStore) load() error {
+	data, err := ioutil.ReadFile(f.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read statistics file: %s", err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	var snapshot statsSnapshot
+	if json.Unmarshal(data, &snapshot) == nil && snapshot.Stats != nil {
+		f.data, f.seq = snapshot.Stats, snapshot.Seq
+		return nil
+	}
+	err = json.Unmarshal(data, &f.data)
+	if err != nil {
+		return fmt.Errorf("failed to parse statistics file: %s", err)
+	}
+
+	return nil
+}
+
+// replay applies the journal records that are not part of the statistics file
+// and opens the journal for appending
+// A torn record at the end of the journal, left behind by a crash, is discarded
+func (f *FileStore) replay() error {
+	file, err := os.OpenFile(f.journalPath, os.O_RDWR|os.O_CREATE, filePerm)
+	if err != nil {
+		return fmt.Errorf("failed to open journal file: %s", err)
+	}
+
+	var offset int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) != 0 {
+				log.Warnf("Discarding incomplete record at the end of statistics journal %s", f.journalPath)
+			}
+			break
+		}
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("failed to read journal file: %s", err)
+		}
+
+		if len(bytes.TrimSpace(line)) != 0 {
+			var r statsRecord
+			err = json.Unmarshal(line, &r)
+			if err != nil {
+				file.Close()
+				return fmt.Errorf("failed to parse journal record at offset %d: %s", offset, err)
+			}
+			f.apply(r)
+		}
+		offset += int64(len(line))
+	}
+
+	err = file.Truncate(offset)
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to prepare journal file for writing: %s", err)
+	}
+	f.journal = file
+	f.size = offset
+
+	return nil
+}
+
+// apply applies a journal record to the in memory statistics
+// Records that are already part of the statistics file are skipped
+func (f *FileStore) apply(r statsRecord) {
+	if r.Seq <= f.seq {
+		return
+	}
+	f.seq = r.Seq
+	if r.Remove != "" {
+		f.MemoryStore.Remove(r.Remove)
+		return
+	}
+	hits := make([]Hit, 0, len(r.Hits))
+	for _, h := range r.Hits {
+		hits = append(hits, Hit{
+			ID:           h.ID,
+			Time:         h.Time,
+			ReferrerHost: h.ReferrerHost,
+			UAClass:      h.UAClass,
+			IPHash:       h.IPHash,
+			Bot:          h.Bot,
+		})
+	}
+	// The visitors are compacted by the next recorded hits, after the journal is replayed
+	f.mu.Lock()
+	f.record(hits)
+	f.mu.Unlock()
+}
+
+// append writes a record to the journal and flushes it to disk
+// The statistics file is rewritten when the journal passed the compaction size
+// the caller is expected to hold saveMu
+func (f *FileStore) append(r statsRecord) error {
+	f.seq++
+	r.Seq = f.seq
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal statistics: %s", err)
+	}
+	line = append(line, '\n')
+
+	n, err := f.journal.Write(line)
+	f.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to save statistics: %s", err)
+	}
+	err = f.journal.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to flush statistics journal: %s", err)
+	}
+
+	if f.size >= f.compactSize {
+		err = f.compact()
+		if err != nil {
+			return fmt.Errorf("failed to compact statistics journal: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// compact writes the statistics to the file and truncates the journal
+// A crash between writing the file and truncating the journal is harmless,
+// the records that are part of the file are skipped when the journal is replayed
+// the caller is expected to hold saveMu
+func (f *FileStore) compact() error {
+	f.mu.RLock()
+	data, err := json.Marshal(statsSnapshot{Seq: f.seq, Stats: f.data})
+	f.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal statistics: %s", err)
+	}
+
+	err = utils.WriteFileAtomic(f.filePath, data, filePerm)
+	if err != nil {
+		return fmt.Errorf("failed to save statistics: %s", err)
+	}
+	err = f.journal.Truncate(0)
+	if err == nil {
+		_, err = f.journal.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to truncate statistics journal: %s", err)
+	}
+	f.size = 0
+
+	return f.journal.Sync()
+}
@@ -0,0 +1,187 @@
+package analytics_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/chrisvdg/gotiny/analytics"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MemoryStoreStats(t *testing.T) {
+	assert := assert.New(t)
+	s := analytics.NewMemoryStore()
+	recordTestHits(t, s)
+
+	stats, err := s.Stats("foo")
+	assert.NoError(err)
+	assert.Equal("foo", stats.ID)
+	assert.Equal(4, stats.Total)
+	if assert.NotNil(stats.LastHit) {
+		assert.Equal(time.Date(2020, time.May, 25, 9, 0, 0, 0, time.UTC).Unix(), stats.LastHit.Unix())
+	}
+	assert.Equal(map[string]int{"example.com": 2}, stats.Referrers)
+	assert.Equal(map[string]int{analytics.UAClassDesktop: 3, analytics.UAClassMobile: 1}, stats.UserAgents)
+	assert.Equal([]analytics.DayStats{
+		{Date: "2020-05-24", Hits: 3, Visitors: 2},
+		{Date: "2020-05-25", Hits: 1, Visitors: 1},
+	}, stats.Days)
+
+	assert.NoError(s.Remove("foo"))
+	stats, err = s.Stats("foo")
+	assert.NoError(err)
+	assert.Equal(0, stats.Total)
+	assert.Nil(stats.LastHit)
+	assert.Len(stats.Days, 0)
+}
+
+func Test_FileStorePersists(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "analytics_test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	filePath := path.Join(dir, "stats.json")
+
+	s, err := analytics.NewFileStore(filePath)
+	assert.NoError(err)
+	recordTestHits(t, s)
+	expected, err := s.Stats("foo")
+	assert.NoError(err)
+	assert.NoError(s.Close())
+
+	s, err = analytics.NewFileStore(filePath)
+	assert.NoError(err)
+	defer s.Close()
+	stats, err := s.Stats("foo")
+	assert.NoError(err)
+	assert.Equal(expected.Total, stats.Total)
+	assert.Equal(expected.Days, stats.Days)
+	assert.Equal(expected.Referrers, stats.Referrers)
+}
+
+func Test_FileStoreJournal(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "analytics_test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	filePath := path.Join(dir, "stats.json")
+
+	s, err := analytics.NewFileStore(filePath)
+	assert.NoError(err)
+	recordTestHits(t, s)
+	assert.NoError(s.Remove("bar"))
+	_, err = os.Stat(filePath)
+	assert.True(os.IsNotExist(err), "Changes should only be appended to the journal")
+	expected, err := s.Stats("foo")
+	assert.NoError(err)
+
+	// Reopening without closing replays the journal
+	s, err = analytics.NewFileStore(filePath)
+	assert.NoError(err)
+	stats, err := s.Stats("foo")
+	assert.NoError(err)
+	assertSameStats(t, expected, stats)
+	stats, err = s.Stats("bar")
+	assert.NoError(err)
+	assert.Equal(0, stats.Total, "Removals should be replayed")
+	journal, err := ioutil.ReadFile(filePath + ".journal")
+	assert.NoError(err)
+
+	// A crash between writing the file and truncating the journal should not count hits twice
+	assert.NoError(s.Close())
+	assert.NoError(ioutil.WriteFile(filePath+".journal", journal, 0666))
+	s, err = analytics.NewFileStore(filePath)
+	assert.NoError(err)
+	defer s.Close()
+	stats, err = s.Stats("foo")
+	assert.NoError(err)
+	assertSameStats(t, expected, stats)
+}
+
+func Test_FileStoreCompaction(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "analytics_test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	filePath := path.Join(dir, "stats.json")
+
+	s, err := analytics.NewFileStoreWithCompactSize(filePath, 1)
+	assert.NoError(err)
+	recordTestHits(t, s)
+	info, err := os.Stat(filePath + ".journal")
+	assert.NoError(err)
+	assert.Equal(int64(0), info.Size(), "The journal should be truncated once it passed the compaction size")
+	expected, err := s.Stats("foo")
+	assert.NoError(err)
+	assert.NoError(s.Close())
+
+	// The visitors of past days are compacted into their amount by the next recorded hits
+	s, err = analytics.NewFileStore(filePath)
+	assert.NoError(err)
+	assert.NoError(s.Record([]analytics.Hit{{ID: "bar", Time: time.Now(), IPHash: "a"}}))
+	assert.NoError(s.Close())
+	data, err := ioutil.ReadFile(filePath)
+	assert.NoError(err)
+	assert.NotContains(string(data), `"visitors":{"a":true,"b":true}`)
+
+	s, err = analytics.NewFileStore(filePath)
+	assert.NoError(err)
+	defer s.Close()
+	stats, err := s.Stats("foo")
+	assert.NoError(err)
+	assert.Equal(expected.Days, stats.Days, "Compacted days should keep their amount of visitors")
+}
+
+func Test_FileStoreLegacyFile(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "analytics_test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	filePath := path.Join(dir, "stats.json")
+	err = ioutil.WriteFile(filePath, []byte(`{"foo":{"total":2,"bots":0,"last_hit":1590310800,"referrers":{},"user_agents":{"desktop":2},`+
+		`"days":{"2020-05-24":{"hits":2,"visitors":{"a":true,"b":true},"bots":0}}}}`), 0666)
+	assert.NoError(err)
+
+	s, err := analytics.NewFileStore(filePath)
+	assert.NoError(err)
+	defer s.Close()
+	stats, err := s.Stats("foo")
+	assert.NoError(err)
+	assert.Equal(2, stats.Total)
+	assert.Equal([]analytics.DayStats{{Date: "2020-05-24", Hits: 2, Visitors: 2}}, stats.Days)
+}
+
+func Test_FileStoreCreation(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := analytics.NewFileStore("")
+	assert.Error(err)
+}
+
+// assertSameStats asserts that the statistics are equal, apart from the location of the last hit time
+func assertSameStats(t *testing.T, expected analytics.Stats, actual analytics.Stats) {
+	if assert.NotNil(t, actual.LastHit) {
+		assert.Equal(t, expected.LastHit.Unix(), actual.LastHit.Unix())
+	}
+	expected.LastHit, actual.LastHit = nil, nil
+	assert.Equal(t, expected, actual)
+}
+
+// recordTestHits records 4 hits of foo spread over 2 days and 1 hit of bar
+func recordTestHits(t *testing.T, s analytics.Store) {
+	day := time.Date(2020, time.May, 24, 12, 0, 0, 0, time.UTC)
+	err := s.Record([]analytics.Hit{
+		{ID: "foo", Time: day, ReferrerHost: "example.com", UAClass: analytics.UAClassDesktop, IPHash: "a"},
+		{ID: "foo", Time: day.Add(time.Hour), UAClass: analytics.UAClassDesktop, IPHash: "a"},
+		{ID: "foo", Time: day.Add(2 * time.Hour), ReferrerHost: "example.com", UAClass: analytics.UAClassMobile, IPHash: "b"},
+		{ID: "bar", Time: day, UAClass: analytics.UAClassDesktop, IPHash: "a"},
+	})
+	assert.NoError(t, err)
+	err = s.Record([]analytics.Hit{
+		{ID: "foo", Time: day.Add(21 * time.Hour), UAClass: analytics.UAClassDesktop, IPHash: "a"},
+	})
+	assert.NoError(t, err)
+}
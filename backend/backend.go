@@ -22,6 +22,7 @@ type Backend interface {
 	// Get returns information of a tiny URL matching provided ID
 	Get(id string) (TinyURL, error)
 	// Update updates the tiny URL of the provided ID in the entry with the provided values
+	// Every value except the creation time stamp is replaced
 	Update(entry TinyURL) error
 	// Remove removes an entry from the backend
 	Remove(id string) error
@@ -29,11 +30,72 @@ type Backend interface {
 	Close() error
 }
 
+// URLFinder is implemented by backends that can look up an entry by URL
+// without listing every entry
+type URLFinder interface {
+	// FindURL returns the first entry with the provided URL for which match returns true,
+	// or ErrNotFound when there is none
+	// A nil match accepts every entry
+	FindURL(url string, match func(TinyURL) bool) (TinyURL, error)
+}
+
+// EntryCreator is implemented by backends that can create an entry with all of its values,
+// e.g. the creation time stamp of a migrated entry or the password hash of a protected entry
+// Backends without it only store the ID and URL of new entries
+type EntryCreator interface {
+	// CreateEntry creates a new tiny URL entry with the values of the provided entry
+	// The current time is used as creation time stamp when entry.Created is not set
+	CreateEntry(entry TinyURL) (TinyURL, error)
+}
+
+// PrefixLister is implemented by backends that can list entries by ID prefix
+// without listing every entry
+type PrefixLister interface {
+	// ListPrefix returns the entries of which the ID starts with the provided prefix
+	ListPrefix(prefix string) ([]TinyURL, error)
+}
+
+// EntryWalker is implemented by backends that can visit every entry
+// without loading all of them into memory at once
+type EntryWalker interface {
+	// Walk calls fn for every entry, reading the entries from the backend in batches
+	// Every entry is visited once, entries changed during the walk may be visited with their old or new values
+	// Walk stops at the first error returned by fn and returns it
+	Walk(fn func(TinyURL) error) error
+}
+
+// walkBatchSize is the amount of entries read at once by backends that walk their entries in batches
+const walkBatchSize = 500
+
 // TinyURL represents a tiny url entry
 type TinyURL struct {
 	ID      string   `json:"id"`
 	URL     string   `json:"url"`
 	Created JSONTime `json:"created"`
+	// Expires is the time after which the entry expires, nil when it never expires
+	Expires *JSONTime `json:"expires,omitempty"`
+	// Redirect is the HTTP status code used to redirect to the URL, 0 for the server default
+	Redirect int `json:"redirect,omitempty"`
+	// Owner identifies the token that created the entry, empty for entries created without owner
+	Owner string `json:"owner,omitempty"`
+	// PasswordHash is the hash of the password required to follow the entry, empty when it is not protected
+	// It is never part of the JSON representation
+	PasswordHash string `json:"-"`
+}
+
+// Expired returns true if the entry expired at the provided time
+func (t TinyURL) Expired(now time.Time) bool {
+	return t.Expires != nil && !now.Before(t.Expires.Time())
+}
+
+// newEntry returns the entry to store for a create
+// sets the creation time stamp to the current time when it is not set
+func newEntry(entry TinyURL) TinyURL {
+	if entry.Created.Time().IsZero() {
+		entry.Created = JSONTime(time.Now())
+	}
+
+	return entry
 }
 
 // JSONTime is a time.Time wrapper that JSON (un)marshals into a unix timestamp
@@ -0,0 +1,585 @@
+// Package backendtest provides a conformance test suite for backend.Backend implementations
+//
+// Every backend, including ones maintained outside of this repository, can verify
+// it meets the contract expected by the business logic by running the suite from its own tests:
+//
+//	func Test_Conformance(t *testing.T) {
+//		backendtest.Run(t, func(t *testing.T) backend.Backend {
+//			return newMyBackend(t)
+//		})
+//	}
+package backendtest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/stretchr/testify/assert"
+)
+
+// Factory returns a new and empty backend instance
+// It is called once for every test in the suite, the suite closes the backend when the test is done
+type Factory func(t *testing.T) backend.Backend
+
+// Run runs the conformance test suite against backends created by the factory
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, backend.Backend)
+	}{
+		{"Create", testCreate},
+		{"CreateSameIDAndURL", testCreateSameIDAndURL},
+		{"CreateIDInUse", testCreateIDInUse},
+		{"CreateKeepsCreated", testCreateKeepsCreated},
+		{"Get", testGet},
+		{"GetNotFound", testGetNotFound},
+		{"ListEmpty", testListEmpty},
+		{"List", testList},
+		{"Update", testUpdate},
+		{"UpdateKeepsCreated", testUpdateKeepsCreated},
+		{"UpdateNotFound", testUpdateNotFound},
+		{"Expires", testExpires},
+		{"Redirect", testRedirect},
+		{"PasswordHash", testPasswordHash},
+		{"Owner", testOwner},
+		{"Remove", testRemove},
+		{"RemoveNotFound", testRemoveNotFound},
+		{"ConcurrentAccess", testConcurrentAccess},
+		{"FindURL", testFindURL},
+		{"ListPrefix", testListPrefix},
+		{"Walk", testWalk},
+		{"TokenStore", testTokenStore},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			b := factory(t)
+			if b == nil {
+				t.Fatal("Factory returned a nil backend")
+			}
+			defer func() {
+				assert.NoError(t, b.Close(), "Close should not return an error")
+			}()
+			tc.fn(t, b)
+		})
+	}
+}
+
+// testCreate tests that Create returns the created entry with a creation timestamp
+func testCreate(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+	before := time.Now().Add(-time.Second)
+
+	res, err := b.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+	assert.Equal("foo", res.ID)
+	assert.Equal("http://foo.bar", res.URL)
+	assert.True(res.Created.Time().After(before), "Created should be set to the creation time, got %s", res.Created)
+}
+
+// testCreateSameIDAndURL tests that creating an existing entry with the same URL is idempotent
+func testCreateSameIDAndURL(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+
+	first, err := b.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+	second, err := b.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+	assert.Equal(first.ID, second.ID)
+	assert.Equal(first.URL, second.URL)
+	assert.Equal(first.Created.Unix(), second.Created.Unix(), "Created should not change when creating an existing entry")
+
+	list, err := b.List()
+	assert.NoError(err)
+	assert.Len(list, 1)
+}
+
+// testCreateIDInUse tests that creating an existing ID with a different URL returns ErrIDInUse
+func testCreateIDInUse(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+
+	_, err := b.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+	_, err = b.Create("foo", "http://lorem.ipsum")
+	assert.Equal(backend.ErrIDInUse, err)
+
+	res, err := b.Get("foo")
+	assert.NoError(err)
+	assert.Equal("http://foo.bar", res.URL, "A failed create should not modify the existing entry")
+}
+
+// testCreateKeepsCreated tests that a provided creation timestamp is stored instead of the current time
+func testCreateKeepsCreated(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+	creator := entryCreator(t, b)
+	created := time.Date(2019, time.March, 14, 15, 9, 26, 0, time.UTC)
+
+	res, err := creator.CreateEntry(backend.TinyURL{ID: "foo", URL: "http://foo.bar", Created: backend.JSONTime(created)})
+	assert.NoError(err)
+	assert.Equal(created.Unix(), res.Created.Unix())
+
+	res, err = b.Get("foo")
+	assert.NoError(err)
+	assert.Equal(created.Unix(), res.Created.Unix(), "The provided creation timestamp should be stored")
+}
+
+// entryCreator returns the backend as backend.EntryCreator, the test is skipped when it does not implement it
+func entryCreator(t *testing.T, b backend.Backend) backend.EntryCreator {
+	creator, ok := b.(backend.EntryCreator)
+	if !ok {
+		t.Skip("Backend does not implement backend.EntryCreator")
+	}
+
+	return creator
+}
+
+// testGet tests that Get returns the entry as it was created
+func testGet(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+
+	created, err := b.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+	res, err := b.Get("foo")
+	assert.NoError(err)
+	assert.Equal("foo", res.ID)
+	assert.Equal("http://foo.bar", res.URL)
+	assert.Equal(created.Created.Unix(), res.Created.Unix())
+}
+
+// testGetNotFound tests that Get of a missing ID returns ErrNotFound
+func testGetNotFound(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+
+	_, err := b.Get("missing")
+	assert.Equal(backend.ErrNotFound, err)
+}
+
+// testListEmpty tests that List of an empty backend returns an empty, non nil list
+func testListEmpty(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+
+	list, err := b.List()
+	assert.NoError(err)
+	assert.NotNil(list, "List should return an empty list rather than nil")
+	assert.Len(list, 0)
+}
+
+// testList tests that List returns every entry
+func testList(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+
+	expected := map[string]string{
+		"foo":   "http://foo.bar",
+		"ping":  "http://ping.pong",
+		"lorem": "http://lorem.ipsum",
+	}
+	for id, url := range expected {
+		_, err := b.Create(id, url)
+		assert.NoError(err)
+	}
+
+	list, err := b.List()
+	assert.NoError(err)
+	assert.Len(list, len(expected))
+	for _, entry := range list {
+		assert.Equal(expected[entry.ID], entry.URL, "Unexpected URL for %s", entry.ID)
+		assert.NotZero(entry.Created.Unix(), "Created should be set for %s", entry.ID)
+	}
+}
+
+// testUpdate tests that Update replaces the URL of an entry
+func testUpdate(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+
+	_, err := b.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+	err = b.Update(backend.TinyURL{ID: "foo", URL: "http://lorem.ipsum"})
+	assert.NoError(err)
+
+	res, err := b.Get("foo")
+	assert.NoError(err)
+	assert.Equal("http://lorem.ipsum", res.URL)
+}
+
+// testUpdateKeepsCreated tests that Update does not modify the creation timestamp
+func testUpdateKeepsCreated(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+
+	created, err := b.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+	err = b.Update(backend.TinyURL{
+		ID:      "foo",
+		URL:     "http://lorem.ipsum",
+		Created: backend.JSONTime(time.Unix(42, 0)),
+	})
+	assert.NoError(err)
+
+	res, err := b.Get("foo")
+	assert.NoError(err)
+	assert.Equal(created.Created.Unix(), res.Created.Unix())
+}
+
+// testUpdateNotFound tests that Update of a missing ID returns ErrNotFound
+func testUpdateNotFound(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+
+	err := b.Update(backend.TinyURL{ID: "missing", URL: "http://foo.bar"})
+	assert.Equal(backend.ErrNotFound, err)
+	_, err = b.Get("missing")
+	assert.Equal(backend.ErrNotFound, err, "Update should not create missing entries")
+}
+
+// testExpires tests that the expiry of an entry is stored on create and replaced on update
+func testExpires(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+	creator := entryCreator(t, b)
+	expires := backend.JSONTime(time.Now().Add(time.Hour))
+
+	res, err := creator.CreateEntry(backend.TinyURL{ID: "foo", URL: "http://foo.bar", Expires: &expires})
+	assert.NoError(err)
+	if assert.NotNil(res.Expires) {
+		assert.Equal(expires.Unix(), res.Expires.Unix())
+	}
+	res, err = b.Get("foo")
+	assert.NoError(err)
+	if assert.NotNil(res.Expires, "Expires should be stored") {
+		assert.Equal(expires.Unix(), res.Expires.Unix())
+	}
+
+	later := backend.JSONTime(expires.Time().Add(time.Hour))
+	err = b.Update(backend.TinyURL{ID: "foo", URL: "http://foo.bar", Expires: &later})
+	assert.NoError(err)
+	res, err = b.Get("foo")
+	assert.NoError(err)
+	if assert.NotNil(res.Expires, "Expires should be updated") {
+		assert.Equal(later.Unix(), res.Expires.Unix())
+	}
+
+	err = b.Update(backend.TinyURL{ID: "foo", URL: "http://foo.bar"})
+	assert.NoError(err)
+	res, err = b.Get("foo")
+	assert.NoError(err)
+	assert.Nil(res.Expires, "Updating without expiry should remove it")
+
+	res, err = b.Create("lorem", "http://lorem.ipsum")
+	assert.NoError(err)
+	assert.Nil(res.Expires, "Entries created without expiry should not expire")
+}
+
+// testRedirect tests that the redirect status code of an entry is stored on create and replaced on update
+func testRedirect(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+	creator := entryCreator(t, b)
+
+	res, err := creator.CreateEntry(backend.TinyURL{ID: "foo", URL: "http://foo.bar", Redirect: 307})
+	assert.NoError(err)
+	assert.Equal(307, res.Redirect)
+	res, err = b.Get("foo")
+	assert.NoError(err)
+	assert.Equal(307, res.Redirect, "Redirect should be stored")
+
+	err = b.Update(backend.TinyURL{ID: "foo", URL: "http://foo.bar", Redirect: 308})
+	assert.NoError(err)
+	res, err = b.Get("foo")
+	assert.NoError(err)
+	assert.Equal(308, res.Redirect, "Redirect should be updated")
+
+	err = b.Update(backend.TinyURL{ID: "foo", URL: "http://foo.bar"})
+	assert.NoError(err)
+	res, err = b.Get("foo")
+	assert.NoError(err)
+	assert.Equal(0, res.Redirect)
+}
+
+// testPasswordHash tests that the password hash of an entry is stored on create and replaced on update
+func testPasswordHash(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+	creator := entryCreator(t, b)
+
+	res, err := creator.CreateEntry(backend.TinyURL{ID: "foo", URL: "http://foo.bar", PasswordHash: "hash"})
+	assert.NoError(err)
+	assert.Equal("hash", res.PasswordHash)
+	res, err = b.Get("foo")
+	assert.NoError(err)
+	assert.Equal("hash", res.PasswordHash, "PasswordHash should be stored")
+
+	err = b.Update(backend.TinyURL{ID: "foo", URL: "http://foo.bar", PasswordHash: "other"})
+	assert.NoError(err)
+	list, err := b.List()
+	assert.NoError(err)
+	if assert.Len(list, 1) {
+		assert.Equal("other", list[0].PasswordHash, "PasswordHash should be updated")
+	}
+
+	err = b.Update(backend.TinyURL{ID: "foo", URL: "http://foo.bar"})
+	assert.NoError(err)
+	res, err = b.Get("foo")
+	assert.NoError(err)
+	assert.Empty(res.PasswordHash)
+}
+
+// testOwner tests that the owner of an entry is stored on create and replaced on update
+func testOwner(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+	creator := entryCreator(t, b)
+
+	res, err := creator.CreateEntry(backend.TinyURL{ID: "foo", URL: "http://foo.bar", Owner: "token:foo"})
+	assert.NoError(err)
+	assert.Equal("token:foo", res.Owner)
+	res, err = b.Get("foo")
+	assert.NoError(err)
+	assert.Equal("token:foo", res.Owner, "Owner should be stored")
+
+	err = b.Update(backend.TinyURL{ID: "foo", URL: "http://foo.bar", Owner: "token:bar"})
+	assert.NoError(err)
+	list, err := b.List()
+	assert.NoError(err)
+	if assert.Len(list, 1) {
+		assert.Equal("token:bar", list[0].Owner, "Owner should be updated")
+	}
+}
+
+// testRemove tests that Remove only removes the provided entry
+func testRemove(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+
+	_, err := b.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+	_, err = b.Create("ping", "http://ping.pong")
+	assert.NoError(err)
+
+	err = b.Remove("foo")
+	assert.NoError(err)
+	_, err = b.Get("foo")
+	assert.Equal(backend.ErrNotFound, err)
+	_, err = b.Get("ping")
+	assert.NoError(err)
+	list, err := b.List()
+	assert.NoError(err)
+	assert.Len(list, 1)
+
+	// A removed ID can be used again
+	_, err = b.Create("foo", "http://lorem.ipsum")
+	assert.NoError(err)
+}
+
+// testRemoveNotFound tests that Remove of a missing ID does not return an error
+func testRemoveNotFound(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+
+	err := b.Remove("missing")
+	assert.NoError(err)
+}
+
+// testFindURL tests the optional backend.URLFinder implementation
+func testFindURL(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+	finder, ok := b.(backend.URLFinder)
+	if !ok {
+		t.Skip("Backend does not implement backend.URLFinder")
+	}
+
+	_, err := finder.FindURL("http://foo.bar", nil)
+	assert.Equal(backend.ErrNotFound, err)
+
+	_, err = b.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+	_, err = b.Create("foobar", "http://foo.bar/baz")
+	assert.NoError(err)
+	res, err := finder.FindURL("http://foo.bar", nil)
+	assert.NoError(err)
+	assert.Equal("foo", res.ID)
+	assert.Equal("http://foo.bar", res.URL)
+
+	// Entries with the same URL are filtered by match
+	_, err = b.Create("bar", "http://foo.bar")
+	assert.NoError(err)
+	err = b.Update(backend.TinyURL{ID: "bar", URL: "http://foo.bar", Owner: "bar"})
+	assert.NoError(err)
+	res, err = finder.FindURL("http://foo.bar", func(entry backend.TinyURL) bool {
+		return entry.Owner == "bar"
+	})
+	assert.NoError(err)
+	assert.Equal("bar", res.ID)
+	_, err = finder.FindURL("http://foo.bar", func(entry backend.TinyURL) bool {
+		return false
+	})
+	assert.Equal(backend.ErrNotFound, err)
+	assert.NoError(b.Remove("bar"))
+
+	// The lookup should follow updates and removals
+	err = b.Update(backend.TinyURL{ID: "foo", URL: "http://lorem.ipsum"})
+	assert.NoError(err)
+	_, err = finder.FindURL("http://foo.bar", nil)
+	assert.Equal(backend.ErrNotFound, err)
+	res, err = finder.FindURL("http://lorem.ipsum", nil)
+	assert.NoError(err)
+	assert.Equal("foo", res.ID)
+
+	err = b.Remove("foo")
+	assert.NoError(err)
+	_, err = finder.FindURL("http://lorem.ipsum", nil)
+	assert.Equal(backend.ErrNotFound, err)
+}
+
+// testWalk tests the optional backend.EntryWalker implementation
+func testWalk(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+	walker, ok := b.(backend.EntryWalker)
+	if !ok {
+		t.Skip("Backend does not implement backend.EntryWalker")
+	}
+
+	err := walker.Walk(func(entry backend.TinyURL) error {
+		t.Errorf("An empty backend should not visit %s", entry.ID)
+		return nil
+	})
+	assert.NoError(err)
+
+	// More entries than a single batch of the backends in this repository
+	expected := map[string]string{}
+	for i := 0; i < 1234; i++ {
+		id := fmt.Sprintf("walk%d", i)
+		expected[id] = "http://" + id + ".bar"
+		_, err := b.Create(id, expected[id])
+		assert.NoError(err)
+	}
+	visited := map[string]string{}
+	err = walker.Walk(func(entry backend.TinyURL) error {
+		assert.NotContains(visited, entry.ID, "Every entry should be visited once")
+		visited[entry.ID] = entry.URL
+		return nil
+	})
+	assert.NoError(err)
+	assert.Equal(expected, visited)
+
+	// An error returned by fn stops the walk
+	errStop := errors.New("stop")
+	count := 0
+	err = walker.Walk(func(entry backend.TinyURL) error {
+		count++
+		return errStop
+	})
+	assert.Equal(errStop, err)
+	assert.Equal(1, count)
+}
+
+// testListPrefix tests the optional backend.PrefixLister implementation
+func testListPrefix(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+	lister, ok := b.(backend.PrefixLister)
+	if !ok {
+		t.Skip("Backend does not implement backend.PrefixLister")
+	}
+
+	for _, id := range []string{"foo", "foobar", "fo", "bar"} {
+		_, err := b.Create(id, "http://"+id+".bar")
+		assert.NoError(err)
+	}
+
+	list, err := lister.ListPrefix("foo")
+	assert.NoError(err)
+	ids := []string{}
+	for _, entry := range list {
+		ids = append(ids, entry.ID)
+		assert.Equal("http://"+entry.ID+".bar", entry.URL)
+	}
+	assert.ElementsMatch([]string{"foo", "foobar"}, ids)
+
+	list, err = lister.ListPrefix("none")
+	assert.NoError(err)
+	assert.NotNil(list)
+	assert.Len(list, 0)
+
+	list, err = lister.ListPrefix("")
+	assert.NoError(err)
+	assert.Len(list, 4)
+}
+
+// testConcurrentAccess calls every backend method in parallel
+// Run with the race detector enabled to detect unsynchronized access
+func testConcurrentAccess(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+
+	const workers = 8
+	const iterations = 10
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				id := fmt.Sprintf("w%di%d", w, i)
+				_, err := b.Create(id, fmt.Sprintf("http://%s.foo.bar", id))
+				assert.NoError(err)
+				_, err = b.Get(id)
+				assert.NoError(err)
+				_, err = b.List()
+				assert.NoError(err)
+				err = b.Update(backend.TinyURL{ID: id, URL: fmt.Sprintf("http://%s.lorem.ipsum", id)})
+				assert.NoError(err)
+				if i%2 == 0 {
+					assert.NoError(b.Remove(id))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	list, err := b.List()
+	assert.NoError(err)
+	assert.Len(list, workers*iterations/2)
+}
+
+// testTokenStore tests the optional backend.TokenStore implementation
+func testTokenStore(t *testing.T, b backend.Backend) {
+	assert := assert.New(t)
+	store, ok := b.(backend.TokenStore)
+	if !ok {
+		t.Skip("Backend does not implement backend.TokenStore")
+	}
+
+	list, err := store.ListTokens()
+	assert.NoError(err)
+	assert.Empty(list)
+	_, err = store.GetToken("foo")
+	assert.Equal(backend.ErrNotFound, err)
+
+	created := backend.JSONTime(time.Unix(time.Now().Unix(), 0))
+	foo := backend.Token{ID: "foo", Name: "Foo", Hash: "hash", Scopes: []string{"read", "update"}, Created: created}
+	assert.NoError(store.CreateToken(foo))
+	assert.NoError(store.CreateToken(backend.Token{ID: "bar", Name: "Bar", Hash: "other", Scopes: []string{}, Created: created}))
+	assert.Equal(backend.ErrIDInUse, store.CreateToken(backend.Token{ID: "foo", Hash: "different"}))
+
+	res, err := store.GetToken("foo")
+	assert.NoError(err)
+	assert.Equal(foo.ID, res.ID)
+	assert.Equal(foo.Name, res.Name)
+	assert.Equal(foo.Hash, res.Hash, "Creating a token with an ID in use should not replace it")
+	assert.Equal(foo.Scopes, res.Scopes)
+	assert.Equal(created.Unix(), res.Created.Unix())
+
+	list, err = store.ListTokens()
+	assert.NoError(err)
+	if assert.Len(list, 2) {
+		assert.Equal("bar", list[0].ID, "Tokens should be ordered by ID")
+		assert.Equal("foo", list[1].ID)
+	}
+
+	assert.NoError(store.RemoveToken("foo"))
+	assert.Equal(backend.ErrNotFound, store.RemoveToken("foo"))
+	_, err = store.GetToken("foo")
+	assert.Equal(backend.ErrNotFound, err)
+	list, err = store.ListTokens()
+	assert.NoError(err)
+	assert.Len(list, 1)
+
+	entries, err := b.List()
+	assert.NoError(err)
+	assert.Empty(entries, "Tokens should not be listed as entries")
+}
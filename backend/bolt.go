@@ -0,0 +1,362 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// boltEntriesBucket maps tiny URL IDs to their entry
+	boltEntriesBucket = []byte("entries")
+	// boltURLsBucket indexes entries by URL, keys are the URL and ID separated by urlIndexSep
+	boltURLsBucket = []byte("urls")
+	// boltTokensBucket maps token IDs to their token
+	boltTokensBucket = []byte("tokens")
+)
+
+// urlIndexSep separates the URL from the ID in URL index keys
+// URLs and IDs are URL safe so they never contain it
+const urlIndexSep = 0
+
+// NewBolt returns a new backend stored in an embedded B+tree key/value file
+func NewBolt(filePath string) (*Bolt, error) {
+	if filePath == "" {
+		return nil, fmt.Errorf("no backend file location provided")
+	}
+
+	db, err := bolt.Open(filePath, filePerm, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt backend file: %s", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltEntriesBucket, boltURLsBucket, boltTokensBucket} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize bolt backend file: %s", err)
+	}
+
+	return &Bolt{db: db}, nil
+}
+
+// Bolt represents a backend stored in a single page based key/value file
+// Entries are read from disk on demand, so the data set does not need to fit in memory
+// It is safe for concurrent use
+type Bolt struct {
+	db *bolt.DB
+}
+
+// List implements backend.List
+func (b *Bolt) List() ([]TinyURL, error) {
+	return b.ListPrefix("")
+}
+
+// ListPrefix implements backend.PrefixLister
+func (b *Bolt) ListPrefix(prefix string) ([]TinyURL, error) {
+	result := []TinyURL{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltEntriesBucket).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			entry, err := decodeBoltEntry(k, v)
+			if err != nil {
+				return err
+			}
+			result = append(result, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bolt backend entries: %s", err)
+	}
+
+	return result, nil
+}
+
+// Walk implements backend.EntryWalker
+// Entries are read in ID order, every batch in its own read transaction
+func (b *Bolt) Walk(fn func(TinyURL) error) error {
+	var after []byte
+	for {
+		batch := make([]TinyURL, 0, walkBatchSize)
+		err := b.db.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket(boltEntriesBucket).Cursor()
+			k, v := c.First()
+			if after != nil {
+				k, v = c.Seek(after)
+				if bytes.Equal(k, after) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil && len(batch) < walkBatchSize; k, v = c.Next() {
+				entry, err := decodeBoltEntry(k, v)
+				if err != nil {
+					return err
+				}
+				batch = append(batch, entry)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to walk bolt backend entries: %s", err)
+		}
+
+		for _, entry := range batch {
+			err = fn(entry)
+			if err != nil {
+				return err
+			}
+		}
+		if len(batch) < walkBatchSize {
+			return nil
+		}
+		after = []byte(batch[len(batch)-1].ID)
+	}
+}
+
+// FindURL implements backend.URLFinder
+func (b *Bolt) FindURL(url string, match func(TinyURL) bool) (TinyURL, error) {
+	var result TinyURL
+	err := b.db.View(func(tx *bolt.Tx) error {
+		prefix := urlIndexKey(url, "")
+		c := tx.Bucket(boltURLsBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			entry, err := getBoltEntry(tx, string(k[len(prefix):]))
+			if err != nil {
+				return err
+			}
+			if match == nil || match(entry) {
+				result = entry
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+
+	return result, err
+}
+
+// Create implements backend.Create
+func (b *Bolt) Create(id string, url string) (TinyURL, error) {
+	return b.CreateEntry(TinyURL{ID: id, URL: url})
+}
+
+// CreateEntry implements backend.EntryCreator
+func (b *Bolt) CreateEntry(entry TinyURL) (TinyURL, error) {
+	var result TinyURL
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		existing, err := getBoltEntry(tx, entry.ID)
+		if err == nil {
+			if existing.URL == entry.URL {
+				result = existing
+				return nil
+			}
+			return ErrIDInUse
+		}
+		if err != ErrNotFound {
+			return err
+		}
+
+		result = newEntry(entry)
+		return putBoltEntry(tx, result)
+	})
+	if err != nil {
+		if err == ErrIDInUse {
+			return TinyURL{}, err
+		}
+		return TinyURL{}, fmt.Errorf("failed to save to backend: %s", err)
+	}
+
+	return result, nil
+}
+
+// Get implements backend.Get
+func (b *Bolt) Get(id string) (TinyURL, error) {
+	var result TinyURL
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		result, err = getBoltEntry(tx, id)
+		return err
+	})
+
+	return result, err
+}
+
+// Update implements backend.Update
+func (b *Bolt) Update(entry TinyURL) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		existing, err := getBoltEntry(tx, entry.ID)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(boltURLsBucket).Delete(urlIndexKey(existing.URL, existing.ID))
+		if err != nil {
+			return err
+		}
+		entry.Created = existing.Created // Created time stamp should not be updated
+		return putBoltEntry(tx, entry)
+	})
+	if err != nil && err != ErrNotFound {
+		return fmt.Errorf("failed to save update to bolt backend: %s", err)
+	}
+
+	return err
+}
+
+// Remove implements backend.Remove
+func (b *Bolt) Remove(id string) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		existing, err := getBoltEntry(tx, id)
+		if err == ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(boltURLsBucket).Delete(urlIndexKey(existing.URL, id))
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(boltEntriesBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save delete to bolt backend: %s", err)
+	}
+
+	return nil
+}
+
+// Close implements backend.Close
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+// ListTokens implements backend.TokenStore
+func (b *Bolt) ListTokens() ([]Token, error) {
+	result := []Token{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltTokensBucket).ForEach(func(k []byte, v []byte) error {
+			var token Token
+			err := json.Unmarshal(v, &token)
+			if err != nil {
+				return fmt.Errorf("failed to parse token %s: %s", k, err)
+			}
+			result = append(result, token)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bolt backend tokens: %s", err)
+	}
+
+	return result, nil
+}
+
+// CreateToken implements backend.TokenStore
+func (b *Bolt) CreateToken(token Token) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltTokensBucket)
+		if bucket.Get([]byte(token.ID)) != nil {
+			return ErrIDInUse
+		}
+		data, err := json.Marshal(token)
+		if err != nil {
+			return fmt.Errorf("failed to marshal token: %s", err)
+		}
+		return bucket.Put([]byte(token.ID), data)
+	})
+	if err != nil && err != ErrIDInUse {
+		return fmt.Errorf("failed to save token to bolt backend: %s", err)
+	}
+
+	return err
+}
+
+// GetToken implements backend.TokenStore
+func (b *Bolt) GetToken(id string) (Token, error) {
+	var result Token
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltTokensBucket).Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		err := json.Unmarshal(v, &result)
+		if err != nil {
+			return fmt.Errorf("failed to parse token %s: %s", id, err)
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+// RemoveToken implements backend.TokenStore
+func (b *Bolt) RemoveToken(id string) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltTokensBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return bucket.Delete([]byte(id))
+	})
+	if err != nil && err != ErrNotFound {
+		return fmt.Errorf("failed to remove token from bolt backend: %s", err)
+	}
+
+	return err
+}
+
+// getBoltEntry returns the entry matching the provided ID within a transaction
+func getBoltEntry(tx *bolt.Tx, id string) (TinyURL, error) {
+	v := tx.Bucket(boltEntriesBucket).Get([]byte(id))
+	if v == nil {
+		return TinyURL{}, ErrNotFound
+	}
+
+	return decodeBoltEntry([]byte(id), v)
+}
+
+// putBoltEntry stores the entry and indexes its URL within a transaction
+func putBoltEntry(tx *bolt.Tx, entry TinyURL) error {
+	data, err := json.Marshal(newFileEntry(entry))
+	if err != nil {
+		return fmt.Errorf("failed to marshal entry: %s", err)
+	}
+	err = tx.Bucket(boltEntriesBucket).Put([]byte(entry.ID), data)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(boltURLsBucket).Put(urlIndexKey(entry.URL, entry.ID), []byte{})
+}
+
+// decodeBoltEntry converts a stored key/value pair into an entry
+func decodeBoltEntry(k []byte, v []byte) (TinyURL, error) {
+	var val fileEntry
+	err := json.Unmarshal(v, &val)
+	if err != nil {
+		return TinyURL{}, fmt.Errorf("failed to parse entry %s: %s", k, err)
+	}
+
+	return val.tinyURL(string(k)), nil
+}
+
+// urlIndexKey returns the URL index key of an entry
+// with an empty ID it returns the prefix shared by all entries with the provided URL
+func urlIndexKey(url string, id string) []byte {
+	key := make([]byte, 0, len(url)+1+len(id))
+	key = append(key, url...)
+	key = append(key, urlIndexSep)
+
+	return append(key, id...)
+}
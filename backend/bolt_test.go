@@ -0,0 +1,60 @@
+package backend_test
+
+import (
+	"fmt"
+	"path"
+	"testing"
+
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/backend/backendtest"
+	"github.com/chrisvdg/gotiny/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_BoltConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) backend.Backend {
+		_, b := createBoltBackend(t)
+		return b
+	})
+}
+
+func Test_BoltCreation(t *testing.T) {
+	assert := assert.New(t)
+
+	// Empty file location should return an error
+	_, err := backend.NewBolt("")
+	assert.Error(err)
+
+	boltFile, b := createBoltBackend(t)
+	assert.FileExists(boltFile)
+	assert.NoError(b.Close())
+}
+
+func Test_BoltReuseBackendFile(t *testing.T) {
+	assert := assert.New(t)
+	boltFile, b := createBoltBackend(t)
+	id1, url1 := addEntry(t, b)
+	id2, url2 := addEntry(t, b)
+	assert.NoError(b.Close())
+
+	b2, err := backend.NewBolt(boltFile)
+	assert.NoError(err)
+	defer b2.Close()
+	res, err := b2.Get(id1)
+	assert.NoError(err)
+	assert.Equal(url1, res.URL)
+	res, err = b2.FindURL(url2, nil)
+	assert.NoError(err)
+	assert.Equal(id2, res.ID)
+}
+
+// createBoltBackend is a convenience function to create a bolt backend
+// returns backend file, backend object
+func createBoltBackend(t *testing.T) (string, *backend.Bolt) {
+	assert := assert.New(t)
+	boltFile := path.Join(testDir, fmt.Sprintf("backend%s.db", utils.GenerateID(5)))
+	b, err := backend.NewBolt(boltFile)
+	assert.NoError(err)
+
+	return boltFile, b
+}
@@ -5,19 +5,38 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"time"
+	"sync"
+
+	"github.com/chrisvdg/gotiny/utils"
+	log "github.com/sirupsen/logrus"
 )
 
 const filePerm os.FileMode = 0666
 
+// backupSuffix is appended to the backend file path to get the backup file path
+const backupSuffix = ".bak"
+
+// FileOptions represents optional settings for the file backend
+type FileOptions struct {
+	// Backup keeps the previous generation of the backend file next to it,
+	// NewFile falls back to it when the backend file is corrupt
+	Backup bool
+}
+
 // NewFile returns a new file backend
 func NewFile(filePath string) (*File, error) {
+	return NewFileWithOptions(filePath, FileOptions{})
+}
+
+// NewFileWithOptions returns a new file backend with the provided options
+func NewFileWithOptions(filePath string, opts FileOptions) (*File, error) {
 	if filePath == "" {
 		return nil, fmt.Errorf("no backend file location provided")
 	}
 	backend := &File{
 		filePath: filePath,
 		data:     fileData{},
+		backup:   opts.Backup,
 	}
 	err := backend.ensureFile()
 	if err != nil {
@@ -28,25 +47,35 @@ func NewFile(filePath string) (*File, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Failed to read data from backendfile: %s", err)
 	}
+	backend.tokenSet, err = newTokenSet(filePath + tokensSuffix)
+	if err != nil {
+		return nil, err
+	}
 
 	return backend, nil
 }
 
 // File represents a file backend implementation
+// It is safe for concurrent use
 type File struct {
 	filePath string
 	data     fileData
+	mu       sync.RWMutex
+	backup   bool
+	// skipRotate prevents a corrupt backend file from replacing a valid backup
+	skipRotate bool
+	// tokenSet implements TokenStore, the tokens are stored in a separate file next to the backend file
+	*tokenSet
 }
 
 // List implements backend.List
 func (f *File) List() ([]TinyURL, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
 	result := []TinyURL{}
 	for k, v := range f.data {
-		result = append(result, TinyURL{
-			ID:      k,
-			URL:     v.URL,
-			Created: v.Created,
-		})
+		result = append(result, v.tinyURL(k))
 	}
 
 	return result, nil
@@ -54,26 +83,27 @@ func (f *File) List() ([]TinyURL, error) {
 
 // Create implements backend.Create
 func (f *File) Create(id string, url string) (TinyURL, error) {
-	if res, ok := f.data[id]; ok {
-		if res.URL == url {
-			return f.Get(id)
+	return f.CreateEntry(TinyURL{ID: id, URL: url})
+}
+
+// CreateEntry implements backend.EntryCreator
+func (f *File) CreateEntry(entry TinyURL) (TinyURL, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if res, ok := f.data[entry.ID]; ok {
+		if res.URL == entry.URL {
+			return f.get(entry.ID)
 		}
 		return TinyURL{}, ErrIDInUse
 	}
-	t := TinyURL{
-		ID:      id,
-		URL:     url,
-		Created: JSONTime(time.Now()),
-	}
-	f.data[id] = fileEntry{
-		URL:     url,
-		Created: JSONTime(t.Created),
-	}
+	t := newEntry(entry)
+	f.data[t.ID] = newFileEntry(t)
 
 	err := f.save()
 	if err != nil {
 		// Remove new entry on error
-		delete(f.data, id)
+		delete(f.data, t.ID)
 		return TinyURL{}, fmt.Errorf("failed to save to backend: %s", err)
 	}
 
@@ -82,31 +112,35 @@ func (f *File) Create(id string, url string) (TinyURL, error) {
 
 // Get implements backend.Get
 func (f *File) Get(id string) (TinyURL, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.get(id)
+}
+
+// get returns the entry matching the provided ID
+// the caller is expected to hold the lock
+func (f *File) get(id string) (TinyURL, error) {
 	val, ok := f.data[id]
 	if !ok {
 		return TinyURL{}, ErrNotFound
 	}
 
-	result := TinyURL{
-		ID:      id,
-		URL:     val.URL,
-		Created: val.Created,
-	}
-
-	return result, nil
+	return val.tinyURL(id), nil
 }
 
 // Update implements backend.Update
 func (f *File) Update(entry TinyURL) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	val, ok := f.data[entry.ID]
 	if !ok {
 		return ErrNotFound
 	}
 
-	f.data[entry.ID] = fileEntry{
-		URL:     entry.URL,
-		Created: val.Created, // Created time stamp should not be updated, maybe add updated timestamp in later release
-	}
+	entry.Created = val.Created // Created time stamp should not be updated, maybe add updated timestamp in later release
+	f.data[entry.ID] = newFileEntry(entry)
 
 	err := f.save()
 	if err != nil {
@@ -120,6 +154,9 @@ func (f *File) Update(entry TinyURL) error {
 
 // Remove implents backend.Remove
 func (f *File) Remove(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	val, ok := f.data[id]
 	if !ok {
 		return nil
@@ -136,33 +173,94 @@ func (f *File) Remove(id string) error {
 
 // Close implements backend.Close
 func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	return f.save()
 }
 
 // save writes the current file backend data to the backend file
+// the caller is expected to hold the lock
 func (f *File) save() error {
 	data, err := json.Marshal(f.data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal backend data to json: %s", err)
 	}
-	err = ioutil.WriteFile(f.filePath, data, filePerm)
+	if f.backup && !f.skipRotate {
+		err = f.rotateBackup()
+		if err != nil {
+			return err
+		}
+	}
+	err = utils.WriteFileAtomic(f.filePath, data, filePerm)
+	if err != nil {
+		return fmt.Errorf("failed to write backend file: %s", err)
+	}
+	f.skipRotate = false
+
+	return nil
+}
+
+// rotateBackup replaces the backup file with the current backend file
+func (f *File) rotateBackup() error {
+	data, err := ioutil.ReadFile(f.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read backend file for backup: %s", err)
+	}
+	err = utils.WriteFileAtomic(f.filePath+backupSuffix, data, filePerm)
 	if err != nil {
-		return fmt.Errorf("failed to openfile to write to: %s", err)
+		return fmt.Errorf("failed to write backup file: %s", err)
 	}
+
 	return nil
 }
 
 // read reads the backend file to in memory objects for the file backend
+// falls back to the backup file when enabled and the backend file is corrupt
 func (f *File) read() error {
-	data, err := ioutil.ReadFile(f.filePath)
+	err := readFileData(f.filePath, &f.data)
+	if !f.backup {
+		return err
+	}
+
+	backupPath := f.filePath + backupSuffix
+	backupInfo, statErr := os.Stat(backupPath)
+	if statErr != nil {
+		return err
+	}
+	if err == nil {
+		// A saved backend file is never empty, an empty one next to a backup was truncated
+		info, statErr := os.Stat(f.filePath)
+		if statErr != nil || info.Size() != 0 || backupInfo.Size() == 0 {
+			return nil
+		}
+		err = fmt.Errorf("backend file is empty")
+	}
+	log.Warnf("Backend file is unusable (%s), falling back to backup file %s", err, backupPath)
+	f.data = fileData{}
+	backupErr := readFileData(backupPath, &f.data)
+	if backupErr != nil {
+		return fmt.Errorf("%s, backup file is also unusable: %s", err, backupErr)
+	}
+	f.skipRotate = true
+
+	return nil
+}
+
+// readFileData parses the backend data found at filePath into data
+func readFileData(filePath string, data *fileData) error {
+	raw, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to read backend file: %s", err)
 	}
 
-	if string(data) == "" || string(data) == "[]" {
+	if string(raw) == "" || string(raw) == "[]" {
 		return nil
 	}
-	err = json.Unmarshal(data, &f.data)
+	err = json.Unmarshal(raw, data)
 	if err != nil {
 		return fmt.Errorf("failed to parse data from backend file: %s", err)
 	}
@@ -185,6 +283,35 @@ type fileData map[string]fileEntry
 
 // fileEntry represents a tiny URL entry in the backend
 type fileEntry struct {
-	URL     string   `json:"url"`
-	Created JSONTime `json:"created"`
+	URL          string    `json:"url"`
+	Created      JSONTime  `json:"created"`
+	Expires      *JSONTime `json:"expires,omitempty"`
+	Redirect     int       `json:"redirect,omitempty"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	Owner        string    `json:"owner,omitempty"`
+}
+
+// newFileEntry returns the stored representation of an entry
+func newFileEntry(t TinyURL) fileEntry {
+	return fileEntry{
+		URL:          t.URL,
+		Created:      t.Created,
+		Expires:      t.Expires,
+		Redirect:     t.Redirect,
+		PasswordHash: t.PasswordHash,
+		Owner:        t.Owner,
+	}
+}
+
+// tinyURL returns the entry stored under the provided ID
+func (e fileEntry) tinyURL(id string) TinyURL {
+	return TinyURL{
+		ID:           id,
+		URL:          e.URL,
+		Created:      e.Created,
+		Expires:      e.Expires,
+		Redirect:     e.Redirect,
+		PasswordHash: e.PasswordHash,
+		Owner:        e.Owner,
+	}
 }
@@ -5,9 +5,11 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"sync"
 	"testing"
 
 	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/backend/backendtest"
 	"github.com/chrisvdg/gotiny/utils"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -26,6 +28,13 @@ func TestMain(m *testing.M) {
 	os.Exit(exitCode)
 }
 
+func Test_FileConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) backend.Backend {
+		_, b := createFilebackend(t)
+		return b
+	})
+}
+
 func Test_BackendCreation(t *testing.T) {
 	assert := assert.New(t)
 
@@ -222,6 +231,113 @@ func Test_UseOfExistingEmptyFile(t *testing.T) {
 	assert.NoError(err)
 }
 
+// Test_SaveReplacesFile tests that saving does not leave temporary files behind
+func Test_SaveReplacesFile(t *testing.T) {
+	assert := assert.New(t)
+	backendFilePath, b := createFilebackend(t)
+	addEntry(t, b)
+	assert.NoError(b.Close())
+
+	_, err := os.Stat(backendFilePath + ".tmp")
+	assert.True(os.IsNotExist(err))
+	_, err = backend.NewFile(backendFilePath)
+	assert.NoError(err)
+}
+
+// Test_BackupFallback tests that a corrupt backend file is recovered from its backup
+func Test_BackupFallback(t *testing.T) {
+	assert := assert.New(t)
+	backendFilePath := path.Join(testDir, generateBackendfilename())
+	opts := backend.FileOptions{Backup: true}
+	b, err := backend.NewFileWithOptions(backendFilePath, opts)
+	assert.NoError(err)
+	id1, url1 := addEntry(t, b)
+	id2, _ := addEntry(t, b)
+	assert.FileExists(backendFilePath + ".bak")
+
+	// Simulate a torn write
+	err = ioutil.WriteFile(backendFilePath, []byte(`{"foo":{"url":"http://`), 0666)
+	assert.NoError(err)
+	_, err = backend.NewFile(backendFilePath)
+	assert.Error(err)
+
+	b2, err := backend.NewFileWithOptions(backendFilePath, opts)
+	assert.NoError(err)
+	res, err := b2.Get(id1)
+	assert.NoError(err)
+	assert.Equal(url1, res.URL)
+	// The backup holds the generation before the last save
+	_, err = b2.Get(id2)
+	assert.EqualError(err, backend.ErrNotFound.Error())
+
+	// Saving after recovery should not rotate the corrupt file into the backup
+	addEntry(t, b2)
+	b3, err := backend.NewFileWithOptions(backendFilePath+".bak", backend.FileOptions{})
+	assert.NoError(err)
+	_, err = b3.Get(id1)
+	assert.NoError(err)
+}
+
+// Test_BackupFallbackTruncated tests that an empty backend file next to a backup is recovered
+func Test_BackupFallbackTruncated(t *testing.T) {
+	assert := assert.New(t)
+	backendFilePath := path.Join(testDir, generateBackendfilename())
+	opts := backend.FileOptions{Backup: true}
+	b, err := backend.NewFileWithOptions(backendFilePath, opts)
+	assert.NoError(err)
+	id, _ := addEntry(t, b)
+	addEntry(t, b)
+
+	err = ioutil.WriteFile(backendFilePath, []byte{}, 0666)
+	assert.NoError(err)
+	b2, err := backend.NewFileWithOptions(backendFilePath, opts)
+	assert.NoError(err)
+	_, err = b2.Get(id)
+	assert.NoError(err)
+}
+
+// Test_ConcurrentAccess calls every backend method in parallel
+// Run with the race detector enabled to detect unsynchronized access
+func Test_ConcurrentAccess(t *testing.T) {
+	assert := assert.New(t)
+	_, b := createFilebackend(t)
+	seedID, _ := addEntry(t, b)
+
+	const workers = 8
+	const iterations = 24
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				id := fmt.Sprintf("w%di%d", w, i)
+				url := generateURL()
+				_, err := b.Create(id, url)
+				assert.NoError(err)
+				_, err = b.Get(id)
+				assert.NoError(err)
+				_, err = b.Get(seedID)
+				assert.NoError(err)
+				_, err = b.List()
+				assert.NoError(err)
+				err = b.Update(backend.TinyURL{ID: id, URL: generateURL()})
+				assert.NoError(err)
+				if i%2 == 0 {
+					err = b.Remove(id)
+					assert.NoError(err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	assert.NoError(b.Close())
+
+	list, err := b.List()
+	assert.NoError(err)
+	assert.Len(list, 1+workers*iterations/2)
+}
+
 // create_backend_single_entry is a convenience function to create a backend
 // returns backendfile, backend object
 func createFilebackend(t *testing.T) (string, backend.Backend) {
@@ -252,3 +368,26 @@ func generateBackendfilename() string {
 func generateURL() string {
 	return fmt.Sprintf("%s.%s", utils.GenerateID(5), utils.GenerateID(3))
 }
+
+func Test_FileTokensPersisted(t *testing.T) {
+	assert := assert.New(t)
+	backendFile := path.Join(testDir, generateBackendfilename())
+	b, err := backend.NewFile(backendFile)
+	assert.NoError(err)
+
+	err = b.CreateToken(backend.Token{ID: "foo", Name: "Foo", Hash: "hash", Scopes: []string{"read"}})
+	assert.NoError(err)
+	assert.FileExists(backendFile + ".tokens")
+	assert.NoError(b.Close())
+
+	b, err = backend.NewFile(backendFile)
+	assert.NoError(err)
+	token, err := b.GetToken("foo")
+	assert.NoError(err)
+	assert.Equal("hash", token.Hash)
+	assert.Equal([]string{"read"}, token.Scopes)
+
+	list, err := b.List()
+	assert.NoError(err)
+	assert.Empty(list, "Tokens should not be stored in the backend file")
+}
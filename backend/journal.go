@@ -0,0 +1,389 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/chrisvdg/gotiny/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultJournalCompactSize is the journal size in bytes after which it is compacted
+const DefaultJournalCompactSize int64 = 16 << 20
+
+// snapshotSuffix is appended to the journal path to get the snapshot file path
+const snapshotSuffix = ".snapshot"
+
+const (
+	journalOpCreate = "create"
+	journalOpUpdate = "update"
+	journalOpRemove = "remove"
+)
+
+// NewJournal returns a new journal backend
+// compactSize is the journal size in bytes after which the journal is compacted into a snapshot,
+// when 0 or lower DefaultJournalCompactSize is used
+func NewJournal(journalPath string, compactSize int64) (*Journal, error) {
+	if journalPath == "" {
+		return nil, fmt.Errorf("no backend journal location provided")
+	}
+	if compactSize <= 0 {
+		compactSize = DefaultJournalCompactSize
+	}
+	j := &Journal{
+		journalPath:  journalPath,
+		snapshotPath: journalPath + snapshotSuffix,
+		compactSize:  compactSize,
+		data:         fileData{},
+		compactCh:    make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+
+	var err error
+	j.tokenSet, err = newTokenSet(journalPath + tokensSuffix)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(j.snapshotPath); err == nil {
+		err = readFileData(j.snapshotPath, &j.data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read journal snapshot: %s", err)
+		}
+	}
+	err = j.replay()
+	if err != nil {
+		return nil, fmt.Errorf("failed to replay journal: %s", err)
+	}
+
+	go j.compactor()
+
+	return j, nil
+}
+
+// Journal represents a backend that appends every change to a journal file
+// and keeps the current state in memory
+// When the journal grows past the compaction size, the state is written to a snapshot
+// in the background and the journal is truncated
+// It is safe for concurrent use
+type Journal struct {
+	journalPath  string
+	snapshotPath string
+	compactSize  int64
+
+	mu   sync.RWMutex
+	data fileData
+	file *os.File
+	size int64
+
+	compactCh chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// tokenSet implements TokenStore, the tokens are stored in a separate file next to the journal
+	*tokenSet
+}
+
+// journalRecord represents a single change in the journal
+type journalRecord struct {
+	Op           string    `json:"op"`
+	ID           string    `json:"id"`
+	URL          string    `json:"url,omitempty"`
+	Created      JSONTime  `json:"created"`
+	Expires      *JSONTime `json:"expires,omitempty"`
+	Redirect     int       `json:"redirect,omitempty"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	Owner        string    `json:"owner,omitempty"`
+}
+
+// List implements backend.List
+func (j *Journal) List() ([]TinyURL, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	result := []TinyURL{}
+	for k, v := range j.data {
+		result = append(result, v.tinyURL(k))
+	}
+
+	return result, nil
+}
+
+// Create implements backend.Create
+func (j *Journal) Create(id string, url string) (TinyURL, error) {
+	return j.CreateEntry(TinyURL{ID: id, URL: url})
+}
+
+// CreateEntry implements backend.EntryCreator
+func (j *Journal) CreateEntry(entry TinyURL) (TinyURL, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if res, ok := j.data[entry.ID]; ok {
+		if res.URL == entry.URL {
+			return j.get(entry.ID)
+		}
+		return TinyURL{}, ErrIDInUse
+	}
+	t := newEntry(entry)
+
+	err := j.append(journalRecord{
+		Op:           journalOpCreate,
+		ID:           t.ID,
+		URL:          t.URL,
+		Created:      t.Created,
+		Expires:      t.Expires,
+		Redirect:     t.Redirect,
+		PasswordHash: t.PasswordHash,
+		Owner:        t.Owner,
+	})
+	if err != nil {
+		return TinyURL{}, fmt.Errorf("failed to save to backend: %s", err)
+	}
+	j.data[t.ID] = newFileEntry(t)
+
+	return t, nil
+}
+
+// Get implements backend.Get
+func (j *Journal) Get(id string) (TinyURL, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return j.get(id)
+}
+
+// get returns the entry matching the provided ID
+// the caller is expected to hold the lock
+func (j *Journal) get(id string) (TinyURL, error) {
+	val, ok := j.data[id]
+	if !ok {
+		return TinyURL{}, ErrNotFound
+	}
+
+	return val.tinyURL(id), nil
+}
+
+// Update implements backend.Update
+func (j *Journal) Update(entry TinyURL) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	val, ok := j.data[entry.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	entry.Created = val.Created // Created time stamp should not be updated
+	err := j.append(journalRecord{
+		Op:           journalOpUpdate,
+		ID:           entry.ID,
+		URL:          entry.URL,
+		Created:      entry.Created,
+		Expires:      entry.Expires,
+		Redirect:     entry.Redirect,
+		PasswordHash: entry.PasswordHash,
+		Owner:        entry.Owner,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save update to journal backend: %s", err)
+	}
+	j.data[entry.ID] = newFileEntry(entry)
+
+	return nil
+}
+
+// Remove implements backend.Remove
+func (j *Journal) Remove(id string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	val, ok := j.data[id]
+	if !ok {
+		return nil
+	}
+
+	err := j.append(journalRecord{
+		Op:      journalOpRemove,
+		ID:      id,
+		Created: val.Created,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save delete to journal backend: %s", err)
+	}
+	delete(j.data, id)
+
+	return nil
+}
+
+// Close implements backend.Close
+// Stops the background compaction and closes the journal file
+func (j *Journal) Close() error {
+	var err error
+	j.closeOnce.Do(func() {
+		close(j.compactCh)
+		<-j.done
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		err = j.file.Sync()
+		closeErr := j.file.Close()
+		if err == nil {
+			err = closeErr
+		}
+	})
+
+	return err
+}
+
+// append writes a record to the journal and flushes it to disk
+// signals the compactor when the journal passed the compaction size
+// the caller is expected to hold the lock
+func (j *Journal) append(r journalRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal record: %s", err)
+	}
+	line = append(line, '\n')
+
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write to journal: %s", err)
+	}
+	err = j.file.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to flush journal: %s", err)
+	}
+
+	if j.size >= j.compactSize {
+		select {
+		case j.compactCh <- struct{}{}:
+		default:
+			// Compaction already pending
+		}
+	}
+
+	return nil
+}
+
+// replay applies the journal records on top of the loaded snapshot
+// and opens the journal for appending
+// A torn record at the end of the journal, left behind by a crash, is discarded
+func (j *Journal) replay() error {
+	file, err := os.OpenFile(j.journalPath, os.O_RDWR|os.O_CREATE, filePerm)
+	if err != nil {
+		return fmt.Errorf("failed to open journal file: %s", err)
+	}
+
+	var offset int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) != 0 {
+				log.Warnf("Discarding incomplete record at the end of journal %s", j.journalPath)
+			}
+			break
+		}
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("failed to read journal file: %s", err)
+		}
+
+		if len(bytes.TrimSpace(line)) != 0 {
+			var r journalRecord
+			err = json.Unmarshal(line, &r)
+			if err != nil {
+				file.Close()
+				return fmt.Errorf("failed to parse journal record at offset %d: %s", offset, err)
+			}
+			err = j.apply(r)
+			if err != nil {
+				file.Close()
+				return fmt.Errorf("failed to apply journal record at offset %d: %s", offset, err)
+			}
+		}
+		offset += int64(len(line))
+	}
+
+	err = file.Truncate(offset)
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to prepare journal file for writing: %s", err)
+	}
+	j.file = file
+	j.size = offset
+
+	return nil
+}
+
+// apply applies a journal record to the in memory data
+// Applying records that are already part of the snapshot leaves the data unchanged
+func (j *Journal) apply(r journalRecord) error {
+	switch r.Op {
+	case journalOpCreate, journalOpUpdate:
+		j.data[r.ID] = fileEntry{
+			URL:          r.URL,
+			Created:      r.Created,
+			Expires:      r.Expires,
+			Redirect:     r.Redirect,
+			PasswordHash: r.PasswordHash,
+			Owner:        r.Owner,
+		}
+	case journalOpRemove:
+		delete(j.data, r.ID)
+	default:
+		return fmt.Errorf("unknown journal operation %q", r.Op)
+	}
+
+	return nil
+}
+
+// compactor compacts the journal whenever it is signaled until the backend is closed
+func (j *Journal) compactor() {
+	defer close(j.done)
+	for range j.compactCh {
+		err := j.compact()
+		if err != nil {
+			log.Errorf("Failed to compact journal %s: %s", j.journalPath, err)
+		}
+	}
+}
+
+// compact writes the current state to the snapshot file and truncates the journal
+// Only the read lock is held so lookups are served during compaction, writes wait until it is done
+// A crash between writing the snapshot and truncating the journal is harmless,
+// replaying the journal on top of the newer snapshot results in the same state
+func (j *Journal) compact() error {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	data, err := json.Marshal(j.data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %s", err)
+	}
+	err = utils.WriteFileAtomic(j.snapshotPath, data, filePerm)
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot: %s", err)
+	}
+
+	// Writers are excluded while the read lock is held, so nothing is appended to the journal here
+	err = j.file.Truncate(0)
+	if err == nil {
+		_, err = j.file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to truncate journal: %s", err)
+	}
+	j.size = 0
+
+	return j.file.Sync()
+}
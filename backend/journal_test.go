@@ -0,0 +1,187 @@
+package backend_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/backend/backendtest"
+	"github.com/chrisvdg/gotiny/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_JournalConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) backend.Backend {
+		_, b := createJournalBackend(t, 0)
+		return b
+	})
+}
+
+func Test_JournalCreation(t *testing.T) {
+	assert := assert.New(t)
+
+	// Empty journal location should return an error
+	_, err := backend.NewJournal("", 0)
+	assert.Error(err)
+
+	journalFile := path.Join(testDir, generateJournalFilename())
+	b, err := backend.NewJournal(journalFile, 0)
+	assert.NoError(err)
+	assert.FileExists(journalFile)
+	assert.NoError(b.Close())
+}
+
+func Test_JournalReplay(t *testing.T) {
+	assert := assert.New(t)
+	journalFile, b := createJournalBackend(t, 0)
+	id1, _ := addEntry(t, b)
+	id2, url2 := addEntry(t, b)
+	id3, _ := addEntry(t, b)
+	url2 = generateURL()
+	assert.NoError(b.Update(backend.TinyURL{ID: id2, URL: url2}))
+	assert.NoError(b.Remove(id3))
+	created, err := b.Get(id1)
+	assert.NoError(err)
+	assert.NoError(b.Close())
+
+	b2, err := backend.NewJournal(journalFile, 0)
+	assert.NoError(err)
+	defer b2.Close()
+	res, err := b2.Get(id1)
+	assert.NoError(err)
+	assert.Equal(created.Created.Unix(), res.Created.Unix())
+	res, err = b2.Get(id2)
+	assert.NoError(err)
+	assert.Equal(url2, res.URL)
+	_, err = b2.Get(id3)
+	assert.EqualError(err, backend.ErrNotFound.Error())
+	list, err := b2.List()
+	assert.NoError(err)
+	assert.Len(list, 2)
+}
+
+func Test_JournalTornRecord(t *testing.T) {
+	assert := assert.New(t)
+	journalFile, b := createJournalBackend(t, 0)
+	id, url := addEntry(t, b)
+	assert.NoError(b.Close())
+
+	// Simulate a crash halfway through appending a record
+	f, err := os.OpenFile(journalFile, os.O_WRONLY|os.O_APPEND, 0666)
+	assert.NoError(err)
+	_, err = f.WriteString(`{"op":"create","id":"foo","url":"http://fo`)
+	assert.NoError(err)
+	assert.NoError(f.Close())
+
+	b2, err := backend.NewJournal(journalFile, 0)
+	assert.NoError(err)
+	res, err := b2.Get(id)
+	assert.NoError(err)
+	assert.Equal(url, res.URL)
+	_, err = b2.Get("foo")
+	assert.EqualError(err, backend.ErrNotFound.Error())
+
+	// New records should not be glued to the torn one
+	id2, _ := addEntry(t, b2)
+	assert.NoError(b2.Close())
+	b3, err := backend.NewJournal(journalFile, 0)
+	assert.NoError(err)
+	defer b3.Close()
+	_, err = b3.Get(id2)
+	assert.NoError(err)
+}
+
+func Test_JournalCorruptRecord(t *testing.T) {
+	assert := assert.New(t)
+	journalFile := path.Join(testDir, generateJournalFilename())
+	err := ioutil.WriteFile(journalFile, []byte("{\"op\":\"create\"\n{}\n"), 0666)
+	assert.NoError(err)
+
+	_, err = backend.NewJournal(journalFile, 0)
+	assert.Error(err)
+}
+
+func Test_JournalCompaction(t *testing.T) {
+	assert := assert.New(t)
+	journalFile, b := createJournalBackend(t, 512)
+
+	entries := make(map[string]string)
+	for i := 0; i < 50; i++ {
+		id, url := addEntry(t, b)
+		entries[id] = url
+	}
+
+	// Compaction runs in the background, wait for the journal to be truncated
+	assert.Eventually(func() bool {
+		info, err := os.Stat(journalFile)
+		return err == nil && info.Size() < 512
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.FileExists(journalFile + ".snapshot")
+	assert.NoError(b.Close())
+
+	b2, err := backend.NewJournal(journalFile, 512)
+	assert.NoError(err)
+	defer b2.Close()
+	list, err := b2.List()
+	assert.NoError(err)
+	assert.Len(list, len(entries))
+	for _, entry := range list {
+		assert.Equal(entries[entry.ID], entry.URL)
+	}
+}
+
+// Test_JournalConcurrentAccess calls every backend method in parallel while compacting
+// Run with the race detector enabled to detect unsynchronized access
+func Test_JournalConcurrentAccess(t *testing.T) {
+	assert := assert.New(t)
+	_, b := createJournalBackend(t, 1024)
+	defer b.Close()
+
+	const workers = 8
+	const iterations = 24
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				id := fmt.Sprintf("w%di%d", w, i)
+				_, err := b.Create(id, generateURL())
+				assert.NoError(err)
+				_, err = b.Get(id)
+				assert.NoError(err)
+				_, err = b.List()
+				assert.NoError(err)
+				assert.NoError(b.Update(backend.TinyURL{ID: id, URL: generateURL()}))
+				if i%2 == 0 {
+					assert.NoError(b.Remove(id))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	list, err := b.List()
+	assert.NoError(err)
+	assert.Len(list, workers*iterations/2)
+}
+
+// createJournalBackend is a convenience function to create a journal backend
+// returns journal file, backend object
+func createJournalBackend(t *testing.T, compactSize int64) (string, backend.Backend) {
+	assert := assert.New(t)
+	journalFile := path.Join(testDir, generateJournalFilename())
+	b, err := backend.NewJournal(journalFile, compactSize)
+	assert.NoError(err)
+
+	return journalFile, b
+}
+
+func generateJournalFilename() string {
+	return fmt.Sprintf("backend%s.journal", utils.GenerateID(5))
+}
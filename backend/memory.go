@@ -0,0 +1,102 @@
+package backend
+
+import (
+	"sync"
+)
+
+// NewMemory returns a new in-memory backend
+func NewMemory() *Memory {
+	return &Memory{
+		data:     map[string]TinyURL{},
+		tokenSet: &tokenSet{tokens: map[string]Token{}},
+	}
+}
+
+// Memory represents a backend that only keeps entries in memory
+// Entries are lost when the process exits, which makes it suited for tests and ephemeral deployments
+// It is safe for concurrent use
+type Memory struct {
+	data map[string]TinyURL
+	mu   sync.RWMutex
+	// tokenSet implements TokenStore
+	*tokenSet
+}
+
+// List implements backend.List
+func (m *Memory) List() ([]TinyURL, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := []TinyURL{}
+	for _, v := range m.data {
+		result = append(result, v)
+	}
+
+	return result, nil
+}
+
+// Create implements backend.Create
+func (m *Memory) Create(id string, url string) (TinyURL, error) {
+	return m.CreateEntry(TinyURL{ID: id, URL: url})
+}
+
+// CreateEntry implements backend.EntryCreator
+func (m *Memory) CreateEntry(entry TinyURL) (TinyURL, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if res, ok := m.data[entry.ID]; ok {
+		if res.URL == entry.URL {
+			return res, nil
+		}
+		return TinyURL{}, ErrIDInUse
+	}
+	t := newEntry(entry)
+	m.data[t.ID] = t
+
+	return t, nil
+}
+
+// Get implements backend.Get
+func (m *Memory) Get(id string) (TinyURL, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	val, ok := m.data[id]
+	if !ok {
+		return TinyURL{}, ErrNotFound
+	}
+
+	return val, nil
+}
+
+// Update implements backend.Update
+func (m *Memory) Update(entry TinyURL) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	val, ok := m.data[entry.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	entry.Created = val.Created // Created time stamp should not be updated
+	m.data[entry.ID] = entry
+
+	return nil
+}
+
+// Remove implements backend.Remove
+func (m *Memory) Remove(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.data, id)
+
+	return nil
+}
+
+// Close implements backend.Close
+// There is nothing to flush, the entries are discarded with the backend
+func (m *Memory) Close() error {
+	return nil
+}
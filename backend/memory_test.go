@@ -0,0 +1,14 @@
+package backend_test
+
+import (
+	"testing"
+
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/backend/backendtest"
+)
+
+func Test_MemoryConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) backend.Backend {
+		return backend.NewMemory()
+	})
+}
@@ -0,0 +1,514 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// DefaultRedisKeyPrefix is prepended to every key the redis backend stores
+const DefaultRedisKeyPrefix = "gotiny:"
+
+// redisScanCount is the amount of keys requested per SCAN iteration
+const redisScanCount = 500
+
+// redisTransactionAttempts is the amount of times a transaction is tried when the entry changes concurrently
+const redisTransactionAttempts = 10
+
+// NewRedis returns a new backend stored in a Redis compatible server
+// redisURL has the form redis://[:password@]host[:port][/database]
+// keyPrefix is prepended to every stored key, DefaultRedisKeyPrefix is used when empty
+func NewRedis(redisURL string, keyPrefix string) (*Redis, error) {
+	if redisURL == "" {
+		return nil, fmt.Errorf("no redis URL provided")
+	}
+	if keyPrefix == "" {
+		keyPrefix = DefaultRedisKeyPrefix
+	}
+
+	r := &Redis{
+		keyPrefix: keyPrefix,
+		pool: &redis.Pool{
+			MaxIdle:     16,
+			IdleTimeout: 4 * time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialURL(redisURL,
+					redis.DialConnectTimeout(5*time.Second),
+					redis.DialReadTimeout(5*time.Second),
+					redis.DialWriteTimeout(5*time.Second),
+				)
+			},
+			TestOnBorrow: func(c redis.Conn, t time.Time) error {
+				if time.Since(t) < time.Minute {
+					return nil
+				}
+				_, err := c.Do("PING")
+				return err
+			},
+		},
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	if err != nil {
+		r.pool.Close()
+		return nil, fmt.Errorf("failed to connect to redis: %s", err)
+	}
+
+	return r, nil
+}
+
+// Redis represents a backend stored in a Redis compatible server
+// so multiple gotiny instances can share their entries
+// Every entry is stored as a JSON value under its ID key,
+// a set per URL holds the IDs pointing to that URL
+// Entries and their URL sets are written together in MULTI/EXEC transactions
+// Tokens are stored as JSON values in a hash keyed by token ID
+// It is safe for concurrent use
+type Redis struct {
+	pool      *redis.Pool
+	keyPrefix string
+}
+
+// List implements backend.List
+func (r *Redis) List() ([]TinyURL, error) {
+	return r.ListPrefix("")
+}
+
+// ListPrefix implements backend.PrefixLister
+func (r *Redis) ListPrefix(prefix string) ([]TinyURL, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	match := r.entryKey(escapeGlob(prefix)) + "*"
+	keys := []string{}
+	cursor := "0"
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", match, "COUNT", redisScanCount))
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan redis backend: %s", err)
+		}
+		var batch []string
+		_, err = redis.Scan(values, &cursor, &batch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan redis backend: %s", err)
+		}
+		keys = append(keys, batch...)
+		if cursor == "0" {
+			break
+		}
+	}
+	keys = uniqueStrings(keys)
+
+	result := []TinyURL{}
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > redisScanCount {
+			batch = batch[:redisScanCount]
+		}
+		keys = keys[len(batch):]
+
+		entries, err := r.getEntries(conn, batch)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, entries...)
+	}
+
+	return result, nil
+}
+
+// Walk implements backend.EntryWalker
+// Entries are read per SCAN iteration, the IDs of visited entries are kept to skip keys SCAN returns again
+func (r *Redis) Walk(fn func(TinyURL) error) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	match := r.entryKey("") + "*"
+	visited := map[string]bool{}
+	cursor := "0"
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", match, "COUNT", redisScanCount))
+		if err != nil {
+			return fmt.Errorf("failed to scan redis backend: %s", err)
+		}
+		var keys []string
+		_, err = redis.Scan(values, &cursor, &keys)
+		if err != nil {
+			return fmt.Errorf("failed to scan redis backend: %s", err)
+		}
+		batch := keys[:0]
+		for _, key := range keys {
+			if !visited[key] {
+				visited[key] = true
+				batch = append(batch, key)
+			}
+		}
+
+		if len(batch) > 0 {
+			entries, err := r.getEntries(conn, batch)
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				err = fn(entry)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// FindURL implements backend.URLFinder
+func (r *Redis) FindURL(url string, match func(TinyURL) bool) (TinyURL, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("SMEMBERS", r.urlKey(url)))
+	if err != nil {
+		return TinyURL{}, fmt.Errorf("failed to query redis backend: %s", err)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		entry, err := r.get(conn, id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return TinyURL{}, err
+		}
+		// The entry can change after the URL set is read, skip entries that no longer match
+		if entry.URL == url && (match == nil || match(entry)) {
+			return entry, nil
+		}
+	}
+
+	return TinyURL{}, ErrNotFound
+}
+
+// Create implements backend.Create
+func (r *Redis) Create(id string, url string) (TinyURL, error) {
+	return r.CreateEntry(TinyURL{ID: id, URL: url})
+}
+
+// CreateEntry implements backend.EntryCreator
+func (r *Redis) CreateEntry(entry TinyURL) (TinyURL, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	t := newEntry(entry)
+	// Time stamps are stored with a precision of seconds
+	t.Created = JSONTime(time.Unix(t.Created.Unix(), 0))
+	id, url := t.ID, t.URL
+	data, err := encodeRedisEntry(t)
+	if err != nil {
+		return TinyURL{}, err
+	}
+
+	result := t
+	err = r.transaction(conn, id, func(existing TinyURL, found bool) ([]redisCommand, error) {
+		if found {
+			if existing.URL == url {
+				result = existing
+				return nil, nil
+			}
+			return nil, ErrIDInUse
+		}
+		return []redisCommand{
+			{"SET", redis.Args{r.entryKey(id), data}},
+			{"SADD", redis.Args{r.urlKey(url), id}},
+		}, nil
+	})
+	if err != nil {
+		return TinyURL{}, err
+	}
+
+	return result, nil
+}
+
+// Get implements backend.Get
+func (r *Redis) Get(id string) (TinyURL, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	return r.get(conn, id)
+}
+
+// Update implements backend.Update
+func (r *Redis) Update(entry TinyURL) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	return r.transaction(conn, entry.ID, func(existing TinyURL, found bool) ([]redisCommand, error) {
+		if !found {
+			return nil, ErrNotFound
+		}
+		entry.Created = existing.Created // Created time stamp should not be updated
+		data, err := encodeRedisEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		commands := []redisCommand{{"SET", redis.Args{r.entryKey(entry.ID), data}}}
+		if existing.URL != entry.URL {
+			commands = append(commands,
+				redisCommand{"SREM", redis.Args{r.urlKey(existing.URL), entry.ID}},
+				redisCommand{"SADD", redis.Args{r.urlKey(entry.URL), entry.ID}},
+			)
+		}
+		return commands, nil
+	})
+}
+
+// Remove implements backend.Remove
+func (r *Redis) Remove(id string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	return r.transaction(conn, id, func(existing TinyURL, found bool) ([]redisCommand, error) {
+		if !found {
+			return nil, nil
+		}
+		return []redisCommand{
+			{"DEL", redis.Args{r.entryKey(id)}},
+			{"SREM", redis.Args{r.urlKey(existing.URL), id}},
+		}, nil
+	})
+}
+
+// Close implements backend.Close
+func (r *Redis) Close() error {
+	return r.pool.Close()
+}
+
+// ListTokens implements backend.TokenStore
+func (r *Redis) ListTokens() ([]Token, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.ByteSlices(conn.Do("HVALS", r.tokensKey()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query redis backend tokens: %s", err)
+	}
+	result := []Token{}
+	for _, v := range values {
+		var token Token
+		err = json.Unmarshal(v, &token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse token: %s", err)
+		}
+		result = append(result, token)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	return result, nil
+}
+
+// CreateToken implements backend.TokenStore
+func (r *Redis) CreateToken(token Token) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	data, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("failed to marshal token: %s", err)
+	}
+	// HSETNX only stores the token if the ID is not in use
+	created, err := redis.Bool(conn.Do("HSETNX", r.tokensKey(), token.ID, data))
+	if err != nil {
+		return fmt.Errorf("failed to save token to redis backend: %s", err)
+	}
+	if !created {
+		return ErrIDInUse
+	}
+
+	return nil
+}
+
+// GetToken implements backend.TokenStore
+func (r *Redis) GetToken(id string) (Token, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("HGET", r.tokensKey(), id))
+	if err == redis.ErrNil {
+		return Token{}, ErrNotFound
+	}
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to query redis backend token: %s", err)
+	}
+	var token Token
+	err = json.Unmarshal(data, &token)
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to parse token %s: %s", id, err)
+	}
+
+	return token, nil
+}
+
+// RemoveToken implements backend.TokenStore
+func (r *Redis) RemoveToken(id string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	removed, err := redis.Bool(conn.Do("HDEL", r.tokensKey(), id))
+	if err != nil {
+		return fmt.Errorf("failed to remove token from redis backend: %s", err)
+	}
+	if !removed {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// redisCommand represents a command queued in a transaction
+type redisCommand struct {
+	name string
+	args redis.Args
+}
+
+// transaction atomically writes an entry and its URL index
+// The entry is watched and read, commands returns the commands to execute based on it,
+// which are executed with MULTI/EXEC
+// When the entry changed before EXEC, nothing is executed and the transaction is retried
+// Nothing is executed when commands returns an error or no commands
+func (r *Redis) transaction(conn redis.Conn, id string, commands func(existing TinyURL, found bool) ([]redisCommand, error)) error {
+	for attempt := 0; attempt < redisTransactionAttempts; attempt++ {
+		_, err := conn.Do("WATCH", r.entryKey(id))
+		if err != nil {
+			return fmt.Errorf("failed to watch redis backend entry: %s", err)
+		}
+		existing, err := r.get(conn, id)
+		found := err == nil
+		var queue []redisCommand
+		if err == nil || err == ErrNotFound {
+			queue, err = commands(existing, found)
+		}
+		if err != nil || len(queue) == 0 {
+			conn.Do("UNWATCH")
+			return err
+		}
+
+		conn.Send("MULTI")
+		for _, c := range queue {
+			conn.Send(c.name, c.args...)
+		}
+		replies, err := redis.Values(conn.Do("EXEC"))
+		if err == redis.ErrNil {
+			// The entry changed after it was read
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save to redis backend: %s", err)
+		}
+		for _, reply := range replies {
+			if err, ok := reply.(redis.Error); ok {
+				return fmt.Errorf("failed to save to redis backend: %s", err)
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("failed to save to redis backend: entry %s kept changing", id)
+}
+
+// get returns the entry matching the provided ID using the provided connection
+func (r *Redis) get(conn redis.Conn, id string) (TinyURL, error) {
+	data, err := redis.Bytes(conn.Do("GET", r.entryKey(id)))
+	if err == redis.ErrNil {
+		return TinyURL{}, ErrNotFound
+	}
+	if err != nil {
+		return TinyURL{}, fmt.Errorf("failed to query redis backend: %s", err)
+	}
+
+	return decodeRedisEntry(id, data)
+}
+
+// getEntries returns the entries stored under the keys, keys of removed entries are skipped
+func (r *Redis) getEntries(conn redis.Conn, keys []string) ([]TinyURL, error) {
+	values, err := redis.ByteSlices(conn.Do("MGET", redis.Args{}.AddFlat(keys)...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read redis backend entries: %s", err)
+	}
+
+	result := make([]TinyURL, 0, len(values))
+	for i, v := range values {
+		if v == nil {
+			// Removed after it was scanned
+			continue
+		}
+		entry, err := decodeRedisEntry(strings.TrimPrefix(keys[i], r.entryKey("")), v)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, entry)
+	}
+
+	return result, nil
+}
+
+// entryKey returns the key an entry is stored under
+func (r *Redis) entryKey(id string) string {
+	return r.keyPrefix + "tiny:" + id
+}
+
+// urlKey returns the key of the set with the IDs pointing to the URL
+func (r *Redis) urlKey(url string) string {
+	return r.keyPrefix + "url:" + url
+}
+
+// tokensKey returns the key of the hash holding the tokens
+func (r *Redis) tokensKey() string {
+	return r.keyPrefix + "tokens"
+}
+
+// encodeRedisEntry converts an entry into the stored value
+func encodeRedisEntry(entry TinyURL) ([]byte, error) {
+	data, err := json.Marshal(newFileEntry(entry))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal entry: %s", err)
+	}
+
+	return data, nil
+}
+
+// decodeRedisEntry converts a stored value into an entry
+func decodeRedisEntry(id string, data []byte) (TinyURL, error) {
+	var val fileEntry
+	err := json.Unmarshal(data, &val)
+	if err != nil {
+		return TinyURL{}, fmt.Errorf("failed to parse entry %s: %s", id, err)
+	}
+
+	return val.tinyURL(id), nil
+}
+
+// uniqueStrings returns the sorted unique values of s, SCAN can return a key more than once
+func uniqueStrings(s []string) []string {
+	sort.Strings(s)
+	result := s[:0]
+	for i, v := range s {
+		if i == 0 || v != s[i-1] {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+// escapeGlob escapes the redis glob pattern characters in s so it is matched literally
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
@@ -0,0 +1,486 @@
+package backend_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/backend/backendtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RedisConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) backend.Backend {
+		srv := newRESPServer(t)
+		b, err := backend.NewRedis(srv.URL(), "")
+		if err != nil {
+			t.Fatalf("Failed to create redis backend: %s", err)
+		}
+		return &standInRedis{Redis: b, srv: srv}
+	})
+}
+
+// standInRedis is a redis backend that stops its stand-in server when closed
+type standInRedis struct {
+	*backend.Redis
+	srv *respServer
+}
+
+func (r *standInRedis) Close() error {
+	defer r.srv.Close()
+	return r.Redis.Close()
+}
+
+func Test_RedisCreation(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := backend.NewRedis("", "")
+	assert.Error(err)
+
+	// Nothing is listening on this address
+	srv := newRESPServer(t)
+	srv.Close()
+	_, err = backend.NewRedis(srv.URL(), "")
+	assert.Error(err)
+}
+
+// Test_RedisSharedState tests that entries created through one instance
+// are visible and deduplicated through another
+func Test_RedisSharedState(t *testing.T) {
+	assert := assert.New(t)
+	srv := newRESPServer(t)
+	defer srv.Close()
+	b1, err := backend.NewRedis(srv.URL(), "")
+	assert.NoError(err)
+	defer b1.Close()
+	b2, err := backend.NewRedis(srv.URL(), "")
+	assert.NoError(err)
+	defer b2.Close()
+
+	id, url := addEntry(t, b1)
+	res, err := b2.Get(id)
+	assert.NoError(err)
+	assert.Equal(url, res.URL)
+	res, err = b2.FindURL(url, nil)
+	assert.NoError(err)
+	assert.Equal(id, res.ID)
+
+	_, err = b2.Create(id, generateURL())
+	assert.EqualError(err, backend.ErrIDInUse.Error())
+}
+
+// Test_RedisKeyPrefix tests that instances with different key prefixes do not share entries
+func Test_RedisKeyPrefix(t *testing.T) {
+	assert := assert.New(t)
+	srv := newRESPServer(t)
+	defer srv.Close()
+	b1, err := backend.NewRedis(srv.URL(), "one:")
+	assert.NoError(err)
+	defer b1.Close()
+	b2, err := backend.NewRedis(srv.URL(), "two:")
+	assert.NoError(err)
+	defer b2.Close()
+
+	id, _ := addEntry(t, b1)
+	_, err = b2.Get(id)
+	assert.EqualError(err, backend.ErrNotFound.Error())
+	list, err := b2.List()
+	assert.NoError(err)
+	assert.Len(list, 0)
+}
+
+// Test_RedisTransaction tests that an update retries when another instance changes the entry concurrently
+// and keeps the URL sets consistent with the entries
+func Test_RedisTransaction(t *testing.T) {
+	assert := assert.New(t)
+	srv := newRESPServer(t)
+	defer srv.Close()
+	b, err := backend.NewRedis(srv.URL(), "")
+	assert.NoError(err)
+	defer b.Close()
+
+	id, url := addEntry(t, b)
+	concurrentURL, updatedURL := generateURL(), generateURL()
+	execs := 0
+	srv.beforeExec = func() {
+		execs++
+		if execs > 1 {
+			return
+		}
+		// Another instance changes the URL of the entry between the read and the write
+		key := backend.DefaultRedisKeyPrefix + "tiny:" + id
+		srv.strings[key] = strings.Replace(srv.strings[key], url, concurrentURL, 1)
+		srv.versions[key]++
+		delete(srv.sets, backend.DefaultRedisKeyPrefix+"url:"+url)
+		srv.sets[backend.DefaultRedisKeyPrefix+"url:"+concurrentURL] = map[string]bool{id: true}
+	}
+
+	err = b.Update(backend.TinyURL{ID: id, URL: updatedURL})
+	assert.NoError(err)
+	assert.Equal(2, execs, "The transaction should be retried once")
+	res, err := b.Get(id)
+	assert.NoError(err)
+	assert.Equal(updatedURL, res.URL)
+	assert.Empty(srv.sets[backend.DefaultRedisKeyPrefix+"url:"+concurrentURL], "The ID should be removed from the URL set it was read with")
+	assert.Equal(map[string]bool{id: true}, srv.sets[backend.DefaultRedisKeyPrefix+"url:"+updatedURL])
+}
+
+// respServer is an in-process stand-in for a Redis server
+// It speaks enough of the RESP protocol and command set for the redis backend
+type respServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	strings  map[string]string
+	sets     map[string]map[string]bool
+	hashes   map[string]map[string]string
+	// versions counts the writes of every key for WATCH
+	versions map[string]int
+	// beforeExec is called with mu held before a transaction is executed, if set
+	beforeExec func()
+	wg         sync.WaitGroup
+}
+
+// respConn represents the transaction state of a connection
+type respConn struct {
+	watched map[string]int
+	multi   bool
+	queue   [][]string
+}
+
+// newRESPServer starts a RESP stand-in server
+func newRESPServer(t *testing.T) *respServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	s := &respServer{
+		listener: l,
+		strings:  map[string]string{},
+		sets:     map[string]map[string]bool{},
+		hashes:   map[string]map[string]string{},
+		versions: map[string]int{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+
+	return s
+}
+
+// URL returns the redis URL of the server
+func (s *respServer) URL() string {
+	return fmt.Sprintf("redis://%s/0", s.listener.Addr())
+}
+
+// Close stops the server
+func (s *respServer) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *respServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *respServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	c := &respConn{}
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+		s.transaction(w, c, args)
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
+
+// transaction handles the WATCH, MULTI and EXEC commands and queues commands within a transaction
+// Other commands are executed right away
+func (s *respServer) transaction(w *bufio.Writer, c *respConn, args []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(args) == 0 {
+		fmt.Fprint(w, "-ERR empty command\r\n")
+		return
+	}
+	switch strings.ToUpper(args[0]) {
+	case "WATCH":
+		if c.watched == nil {
+			c.watched = map[string]int{}
+		}
+		for _, k := range args[1:] {
+			c.watched[k] = s.versions[k]
+		}
+		fmt.Fprint(w, "+OK\r\n")
+	case "UNWATCH":
+		c.watched = nil
+		fmt.Fprint(w, "+OK\r\n")
+	case "MULTI":
+		c.multi = true
+		fmt.Fprint(w, "+OK\r\n")
+	case "EXEC":
+		if s.beforeExec != nil {
+			s.beforeExec()
+		}
+		queue, watched := c.queue, c.watched
+		c.multi, c.queue, c.watched = false, nil, nil
+		for k, version := range watched {
+			if s.versions[k] != version {
+				fmt.Fprint(w, "*-1\r\n")
+				return
+			}
+		}
+		fmt.Fprintf(w, "*%d\r\n", len(queue))
+		for _, args := range queue {
+			s.exec(w, args)
+		}
+	default:
+		if c.multi {
+			c.queue = append(c.queue, args)
+			fmt.Fprint(w, "+QUEUED\r\n")
+			return
+		}
+		s.exec(w, args)
+	}
+}
+
+// exec executes a command and writes its reply, mu is held by the caller
+func (s *respServer) exec(w *bufio.Writer, args []string) {
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		fmt.Fprint(w, "+PONG\r\n")
+	case "SELECT":
+		fmt.Fprint(w, "+OK\r\n")
+	case "GET":
+		v, ok := s.strings[args[1]]
+		writeRESPBulk(w, v, ok)
+	case "MGET":
+		fmt.Fprintf(w, "*%d\r\n", len(args)-1)
+		for _, k := range args[1:] {
+			v, ok := s.strings[k]
+			writeRESPBulk(w, v, ok)
+		}
+	case "SET":
+		_, exists := s.strings[args[1]]
+		for _, opt := range args[3:] {
+			if (strings.ToUpper(opt) == "NX" && exists) || (strings.ToUpper(opt) == "XX" && !exists) {
+				writeRESPBulk(w, "", false)
+				return
+			}
+		}
+		s.strings[args[1]] = args[2]
+		s.versions[args[1]]++
+		fmt.Fprint(w, "+OK\r\n")
+	case "SETNX":
+		if _, exists := s.strings[args[1]]; exists {
+			fmt.Fprint(w, ":0\r\n")
+			return
+		}
+		s.strings[args[1]] = args[2]
+		s.versions[args[1]]++
+		fmt.Fprint(w, ":1\r\n")
+	case "DEL":
+		n := 0
+		for _, k := range args[1:] {
+			if _, ok := s.strings[k]; ok {
+				n++
+			}
+			if _, ok := s.sets[k]; ok {
+				n++
+			}
+			if _, ok := s.hashes[k]; ok {
+				n++
+			}
+			s.versions[k]++
+			delete(s.strings, k)
+			delete(s.sets, k)
+			delete(s.hashes, k)
+		}
+		fmt.Fprintf(w, ":%d\r\n", n)
+	case "SADD":
+		set, ok := s.sets[args[1]]
+		if !ok {
+			set = map[string]bool{}
+			s.sets[args[1]] = set
+		}
+		n := 0
+		for _, m := range args[2:] {
+			if !set[m] {
+				set[m] = true
+				n++
+			}
+		}
+		fmt.Fprintf(w, ":%d\r\n", n)
+	case "SREM":
+		set := s.sets[args[1]]
+		n := 0
+		for _, m := range args[2:] {
+			if set[m] {
+				delete(set, m)
+				n++
+			}
+		}
+		if len(set) == 0 {
+			delete(s.sets, args[1])
+		}
+		fmt.Fprintf(w, ":%d\r\n", n)
+	case "SMEMBERS":
+		members := []string{}
+		for m := range s.sets[args[1]] {
+			members = append(members, m)
+		}
+		writeRESPArray(w, members)
+	case "HGET":
+		v, ok := s.hashes[args[1]][args[2]]
+		writeRESPBulk(w, v, ok)
+	case "HSETNX":
+		hash, ok := s.hashes[args[1]]
+		if !ok {
+			hash = map[string]string{}
+			s.hashes[args[1]] = hash
+		}
+		if _, exists := hash[args[2]]; exists {
+			fmt.Fprint(w, ":0\r\n")
+			return
+		}
+		hash[args[2]] = args[3]
+		fmt.Fprint(w, ":1\r\n")
+	case "HDEL":
+		hash := s.hashes[args[1]]
+		n := 0
+		for _, f := range args[2:] {
+			if _, ok := hash[f]; ok {
+				delete(hash, f)
+				n++
+			}
+		}
+		if len(hash) == 0 {
+			delete(s.hashes, args[1])
+		}
+		fmt.Fprintf(w, ":%d\r\n", n)
+	case "HVALS":
+		values := []string{}
+		for _, v := range s.hashes[args[1]] {
+			values = append(values, v)
+		}
+		writeRESPArray(w, values)
+	case "SCAN":
+		// The cursor is the offset in the sorted matches, the last key of an iteration is returned again
+		// by the next one as SCAN may return keys more than once
+		offset, _ := strconv.Atoi(args[1])
+		pattern := "*"
+		count := 10
+		for i := 2; i+1 < len(args); i += 2 {
+			switch strings.ToUpper(args[i]) {
+			case "MATCH":
+				pattern = args[i+1]
+			case "COUNT":
+				count, _ = strconv.Atoi(args[i+1])
+			}
+		}
+		keys := []string{}
+		for k := range s.strings {
+			if ok, _ := path.Match(pattern, k); ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		next := 0
+		if offset > len(keys) {
+			offset = len(keys)
+		}
+		if offset+count < len(keys) {
+			keys = keys[offset : offset+count]
+			next = offset + count - 1
+		} else {
+			keys = keys[offset:]
+		}
+		fmt.Fprint(w, "*2\r\n")
+		writeRESPBulk(w, strconv.Itoa(next), true)
+		writeRESPArray(w, keys)
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+// readRESPCommand reads a command sent as an array of bulk strings
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected command %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err = readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("unexpected argument %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+
+	return args, nil
+}
+
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+func writeRESPBulk(w *bufio.Writer, v string, ok bool) {
+	if !ok {
+		fmt.Fprint(w, "$-1\r\n")
+		return
+	}
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+}
+
+func writeRESPArray(w *bufio.Writer, values []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(values))
+	for _, v := range values {
+		writeRESPBulk(w, v, true)
+	}
+}
@@ -0,0 +1,501 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SQLDialectPostgres is the dialect of PostgreSQL compatible databases
+	SQLDialectPostgres = "postgres"
+	// SQLDialectSQLite is the dialect of SQLite databases
+	SQLDialectSQLite = "sqlite3"
+)
+
+// sqlMigration represents a schema change, applied once in order of the sqlMigrations list
+// Statements that differ between dialects are set per dialect, others are shared
+type sqlMigration struct {
+	shared    []string
+	dialected map[string][]string
+}
+
+// sqlMigrations contains the schema migrations, the version of a migration is its index + 1
+// Applied migrations should never be changed, add a new migration instead
+var sqlMigrations = []sqlMigration{
+	{
+		shared: []string{
+			`CREATE TABLE tinyurls (
+				id VARCHAR(255) NOT NULL,
+				url TEXT NOT NULL,
+				created BIGINT NOT NULL
+			)`,
+			`CREATE UNIQUE INDEX tinyurls_id ON tinyurls (id)`,
+		},
+		dialected: map[string][]string{
+			// URLs can be longer than what fits in a B-tree index entry
+			SQLDialectPostgres: {`CREATE INDEX tinyurls_url ON tinyurls USING hash (url)`},
+			SQLDialectSQLite:   {`CREATE INDEX tinyurls_url ON tinyurls (url)`},
+		},
+	},
+	{
+		shared: []string{
+			`ALTER TABLE tinyurls ADD COLUMN expires BIGINT`,
+		},
+	},
+	{
+		shared: []string{
+			`ALTER TABLE tinyurls ADD COLUMN redirect INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		shared: []string{
+			`ALTER TABLE tinyurls ADD COLUMN password_hash TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		shared: []string{
+			`CREATE TABLE tokens (
+				id TEXT NOT NULL PRIMARY KEY,
+				name TEXT NOT NULL,
+				hash TEXT NOT NULL,
+				scopes TEXT NOT NULL,
+				created BIGINT NOT NULL
+			)`,
+		},
+	},
+	{
+		shared: []string{
+			`ALTER TABLE tinyurls ADD COLUMN owner TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+}
+
+// sqlColumns are the selected columns of an entry, in the order scanned by scanSQLEntry
+const sqlColumns = `id, url, created, expires, redirect, password_hash, owner`
+
+// sqlTokenColumns are the selected columns of a token, in the order scanned by scanSQLToken
+const sqlTokenColumns = `id, name, hash, scopes, created`
+
+// sqlScopeSep separates the scopes of a token in the scopes column
+const sqlScopeSep = ","
+
+// NewSQL returns a new backend stored in an SQL database
+// driver is the name of a registered database/sql driver, the SQL dialect is derived from it
+// The database schema is created or migrated to the latest version
+func NewSQL(driver string, dsn string) (*SQL, error) {
+	dialect, err := sqlDialect(driver)
+	if err != nil {
+		return nil, err
+	}
+	if dsn == "" {
+		return nil, fmt.Errorf("no database DSN provided")
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %s", err)
+	}
+	if dialect == SQLDialectSQLite {
+		// SQLite allows a single writer, sharing one connection avoids lock errors
+		db.SetMaxOpenConns(1)
+	}
+
+	s := &SQL{
+		db:      db,
+		dialect: dialect,
+	}
+	err = s.migrate()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database schema: %s", err)
+	}
+
+	return s, nil
+}
+
+// SQL represents a backend stored in an SQL database
+// It is safe for concurrent use
+type SQL struct {
+	db      *sql.DB
+	dialect string
+}
+
+// List implements backend.List
+func (s *SQL) List() ([]TinyURL, error) {
+	return s.query(`SELECT ` + sqlColumns + ` FROM tinyurls ORDER BY id`)
+}
+
+// ListPrefix implements backend.PrefixLister
+func (s *SQL) ListPrefix(prefix string) ([]TinyURL, error) {
+	return s.query(`SELECT `+sqlColumns+` FROM tinyurls WHERE id LIKE ? ESCAPE '\' ORDER BY id`, escapeLike(prefix)+"%")
+}
+
+// Walk implements backend.EntryWalker
+// Entries are read in ID order, every batch with its own query
+func (s *SQL) Walk(fn func(TinyURL) error) error {
+	after := ""
+	for {
+		batch, err := s.query(`SELECT `+sqlColumns+` FROM tinyurls WHERE id > ? ORDER BY id LIMIT ?`, after, walkBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range batch {
+			err = fn(entry)
+			if err != nil {
+				return err
+			}
+		}
+		if len(batch) < walkBatchSize {
+			return nil
+		}
+		after = batch[len(batch)-1].ID
+	}
+}
+
+// FindURL implements backend.URLFinder
+func (s *SQL) FindURL(url string, match func(TinyURL) bool) (TinyURL, error) {
+	entries, err := s.query(`SELECT `+sqlColumns+` FROM tinyurls WHERE url = ? ORDER BY created, id`, url)
+	if err != nil {
+		return TinyURL{}, err
+	}
+	for _, entry := range entries {
+		if match == nil || match(entry) {
+			return entry, nil
+		}
+	}
+
+	return TinyURL{}, ErrNotFound
+}
+
+// Create implements backend.Create
+func (s *SQL) Create(id string, url string) (TinyURL, error) {
+	return s.CreateEntry(TinyURL{ID: id, URL: url})
+}
+
+// CreateEntry implements backend.EntryCreator
+func (s *SQL) CreateEntry(entry TinyURL) (TinyURL, error) {
+	t := newEntry(entry)
+	// Time stamps are stored with a precision of seconds
+	t.Created = JSONTime(time.Unix(t.Created.Unix(), 0))
+	id, url := t.ID, t.URL
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return TinyURL{}, fmt.Errorf("failed to start transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	existing, err := s.queryRow(tx, `SELECT `+sqlColumns+` FROM tinyurls WHERE id = ?`, id)
+	if err == nil {
+		if existing.URL == url {
+			return existing, nil
+		}
+		return TinyURL{}, ErrIDInUse
+	}
+	if err != ErrNotFound {
+		return TinyURL{}, err
+	}
+
+	_, err = tx.Exec(s.rebind(`INSERT INTO tinyurls (id, url, created, expires, redirect, password_hash, owner) VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		id, url, t.Created.Unix(), sqlExpires(t), t.Redirect, t.PasswordHash, t.Owner)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		// Another connection may have inserted the ID since it was looked up,
+		// the unique index rejects this insert in that case
+		tx.Rollback()
+		existing, getErr := s.Get(id)
+		if getErr == nil {
+			if existing.URL == url {
+				return existing, nil
+			}
+			return TinyURL{}, ErrIDInUse
+		}
+		return TinyURL{}, fmt.Errorf("failed to save to backend: %s", err)
+	}
+
+	return t, nil
+}
+
+// Get implements backend.Get
+func (s *SQL) Get(id string) (TinyURL, error) {
+	return s.queryRow(s.db, `SELECT `+sqlColumns+` FROM tinyurls WHERE id = ?`, id)
+}
+
+// Update implements backend.Update
+func (s *SQL) Update(entry TinyURL) error {
+	// Created time stamp should not be updated
+	res, err := s.db.Exec(s.rebind(`UPDATE tinyurls SET url = ?, expires = ?, redirect = ?, password_hash = ?, owner = ? WHERE id = ?`),
+		entry.URL, sqlExpires(entry), entry.Redirect, entry.PasswordHash, entry.Owner, entry.ID)
+	if err != nil {
+		return fmt.Errorf("failed to save update to sql backend: %s", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to save update to sql backend: %s", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Remove implements backend.Remove
+func (s *SQL) Remove(id string) error {
+	_, err := s.db.Exec(s.rebind(`DELETE FROM tinyurls WHERE id = ?`), id)
+	if err != nil {
+		return fmt.Errorf("failed to save delete to sql backend: %s", err)
+	}
+
+	return nil
+}
+
+// Close implements backend.Close
+func (s *SQL) Close() error {
+	return s.db.Close()
+}
+
+// ListTokens implements backend.TokenStore
+func (s *SQL) ListTokens() ([]Token, error) {
+	rows, err := s.db.Query(`SELECT ` + sqlTokenColumns + ` FROM tokens ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sql backend tokens: %s", err)
+	}
+	defer rows.Close()
+
+	result := []Token{}
+	for rows.Next() {
+		token, err := scanSQLToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sql backend token: %s", err)
+		}
+		result = append(result, token)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sql backend tokens: %s", err)
+	}
+
+	return result, nil
+}
+
+// CreateToken implements backend.TokenStore
+func (s *SQL) CreateToken(token Token) error {
+	_, err := s.db.Exec(s.rebind(`INSERT INTO tokens (`+sqlTokenColumns+`) VALUES (?, ?, ?, ?, ?)`),
+		token.ID, token.Name, token.Hash, strings.Join(token.Scopes, sqlScopeSep), token.Created.Unix())
+	if err != nil {
+		// The primary key rejects the insert when the ID is in use
+		if _, getErr := s.GetToken(token.ID); getErr == nil {
+			return ErrIDInUse
+		}
+		return fmt.Errorf("failed to save token to sql backend: %s", err)
+	}
+
+	return nil
+}
+
+// GetToken implements backend.TokenStore
+func (s *SQL) GetToken(id string) (Token, error) {
+	token, err := scanSQLToken(s.db.QueryRow(s.rebind(`SELECT `+sqlTokenColumns+` FROM tokens WHERE id = ?`), id))
+	if err == sql.ErrNoRows {
+		return Token{}, ErrNotFound
+	}
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to query sql backend token: %s", err)
+	}
+
+	return token, nil
+}
+
+// RemoveToken implements backend.TokenStore
+func (s *SQL) RemoveToken(id string) error {
+	res, err := s.db.Exec(s.rebind(`DELETE FROM tokens WHERE id = ?`), id)
+	if err != nil {
+		return fmt.Errorf("failed to remove token from sql backend: %s", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to remove token from sql backend: %s", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// migrate applies the schema migrations that were not applied to the database yet
+// Every migration is applied in its own transaction together with its version record
+func (s *SQL) migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY)`)
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %s", err)
+	}
+
+	var current int
+	err = s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %s", err)
+	}
+
+	for i := current; i < len(sqlMigrations); i++ {
+		version := i + 1
+		m := sqlMigrations[i]
+		tx, err := s.db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to start migration %d: %s", version, err)
+		}
+		statements := append(append([]string{}, m.shared...), m.dialected[s.dialect]...)
+		for _, stmt := range statements {
+			_, err = tx.Exec(stmt)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to apply migration %d: %s", version, err)
+			}
+		}
+		_, err = tx.Exec(s.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), version)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %s", version, err)
+		}
+	}
+
+	return nil
+}
+
+// sqlQueryer is implemented by both *sql.DB and *sql.Tx
+type sqlQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// scanSQLToken scans the sqlTokenColumns of a row into a token
+func scanSQLToken(row sqlScanner) (Token, error) {
+	var t Token
+	var scopes string
+	var created int64
+	err := row.Scan(&t.ID, &t.Name, &t.Hash, &scopes, &created)
+	if err != nil {
+		return Token{}, err
+	}
+	t.Scopes = []string{}
+	if scopes != "" {
+		t.Scopes = strings.Split(scopes, sqlScopeSep)
+	}
+	t.Created = JSONTime(time.Unix(created, 0))
+
+	return t, nil
+}
+
+// sqlScanner is implemented by both *sql.Row and *sql.Rows
+type sqlScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSQLEntry scans the sqlColumns of a row into an entry
+func scanSQLEntry(row sqlScanner) (TinyURL, error) {
+	var t TinyURL
+	var created int64
+	var expires sql.NullInt64
+	err := row.Scan(&t.ID, &t.URL, &created, &expires, &t.Redirect, &t.PasswordHash, &t.Owner)
+	if err != nil {
+		return TinyURL{}, err
+	}
+	t.Created = JSONTime(time.Unix(created, 0))
+	if expires.Valid {
+		e := JSONTime(time.Unix(expires.Int64, 0))
+		t.Expires = &e
+	}
+
+	return t, nil
+}
+
+// sqlExpires returns the stored expires value of an entry
+func sqlExpires(t TinyURL) sql.NullInt64 {
+	if t.Expires == nil {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: t.Expires.Unix(), Valid: true}
+}
+
+// queryRow returns the single entry selected by the query or ErrNotFound
+func (s *SQL) queryRow(q sqlQueryer, query string, args ...interface{}) (TinyURL, error) {
+	t, err := scanSQLEntry(q.QueryRow(s.rebind(query), args...))
+	if err == sql.ErrNoRows {
+		return TinyURL{}, ErrNotFound
+	}
+	if err != nil {
+		return TinyURL{}, fmt.Errorf("failed to query sql backend: %s", err)
+	}
+
+	return t, nil
+}
+
+// query returns the entries selected by the query
+func (s *SQL) query(query string, args ...interface{}) ([]TinyURL, error) {
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sql backend: %s", err)
+	}
+	defer rows.Close()
+
+	result := []TinyURL{}
+	for rows.Next() {
+		t, err := scanSQLEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sql backend entry: %s", err)
+		}
+		result = append(result, t)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sql backend: %s", err)
+	}
+
+	return result, nil
+}
+
+// rebind replaces the ? placeholders of a query with the placeholders of the dialect
+func (s *SQL) rebind(query string) string {
+	if s.dialect != SQLDialectPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// sqlDialect returns the SQL dialect spoken by the provided driver
+func sqlDialect(driver string) (string, error) {
+	switch driver {
+	case "postgres", "pgx":
+		return SQLDialectPostgres, nil
+	case "sqlite3", "sqlite":
+		return SQLDialectSQLite, nil
+	default:
+		return "", fmt.Errorf("unsupported sql driver %q", driver)
+	}
+}
+
+// escapeLike escapes the LIKE wildcards in s so it is matched literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
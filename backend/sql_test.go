@@ -0,0 +1,143 @@
+package backend_test
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/backend/backendtest"
+	"github.com/chrisvdg/gotiny/utils"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+// postgresDSNEnv contains the DSN of a PostgreSQL database to run the SQL backend tests against
+// The tests clear the database tables, do not point it to a database in use
+const postgresDSNEnv = "GOTINY_TEST_POSTGRES_DSN"
+
+func Test_SQLiteConformance(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) backend.Backend {
+		_, b := createSQLiteBackend(t)
+		return b
+	})
+}
+
+func Test_PostgresConformance(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", postgresDSNEnv)
+	}
+
+	backendtest.Run(t, func(t *testing.T) backend.Backend {
+		b, err := backend.NewSQL("postgres", dsn)
+		if err != nil {
+			t.Fatalf("Failed to create postgres backend: %s", err)
+		}
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Fatalf("Failed to connect to postgres: %s", err)
+		}
+		defer db.Close()
+		_, err = db.Exec(`DELETE FROM tinyurls`)
+		if err != nil {
+			t.Fatalf("Failed to clear postgres backend: %s", err)
+		}
+
+		return b
+	})
+}
+
+func Test_SQLCreation(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := backend.NewSQL("unknown", "foo")
+	assert.Error(err)
+	_, err = backend.NewSQL("sqlite3", "")
+	assert.Error(err)
+}
+
+func Test_SQLMigrationsApplyOnce(t *testing.T) {
+	assert := assert.New(t)
+	dbFile, b := createSQLiteBackend(t)
+	id, url := addEntry(t, b)
+	assert.NoError(b.Close())
+
+	// Reopening should not reapply migrations or lose data
+	b2, err := backend.NewSQL("sqlite3", dbFile)
+	assert.NoError(err)
+	defer b2.Close()
+	res, err := b2.Get(id)
+	assert.NoError(err)
+	assert.Equal(url, res.URL)
+
+	db, err := sql.Open("sqlite3", dbFile)
+	assert.NoError(err)
+	defer db.Close()
+	var versions int
+	err = db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&versions)
+	assert.NoError(err)
+	assert.Equal(6, versions)
+}
+
+// Test_SQLMigrateExistingDatabase tests that a database created by an earlier schema version is upgraded
+func Test_SQLMigrateExistingDatabase(t *testing.T) {
+	assert := assert.New(t)
+	dbFile, b := createSQLiteBackend(t)
+	assert.NoError(b.Close())
+
+	// Revert the database to the first schema version
+	db, err := sql.Open("sqlite3", dbFile)
+	assert.NoError(err)
+	for _, stmt := range []string{
+		`DROP TABLE tinyurls`,
+		`DROP TABLE tokens`,
+		`DELETE FROM schema_migrations WHERE version > 1`,
+		`CREATE TABLE tinyurls (id VARCHAR(255) NOT NULL, url TEXT NOT NULL, created BIGINT NOT NULL)`,
+		`INSERT INTO tinyurls (id, url, created) VALUES ('foo', 'http://foo.bar', 1590330837)`,
+	} {
+		_, err = db.Exec(stmt)
+		assert.NoError(err)
+	}
+	assert.NoError(db.Close())
+
+	b2, err := backend.NewSQL("sqlite3", dbFile)
+	assert.NoError(err)
+	defer b2.Close()
+	res, err := b2.Get("foo")
+	assert.NoError(err)
+	assert.Equal("http://foo.bar", res.URL)
+	assert.Equal(int64(1590330837), res.Created.Unix())
+	assert.Nil(res.Expires)
+}
+
+func Test_SQLListPrefixEscapesWildcards(t *testing.T) {
+	assert := assert.New(t)
+	_, b := createSQLiteBackend(t)
+	defer b.Close()
+
+	for _, id := range []string{"a_b", "axb", "a%b"} {
+		_, err := b.Create(id, "http://foo.bar/"+id)
+		assert.NoError(err)
+	}
+	list, err := b.ListPrefix("a_")
+	assert.NoError(err)
+	assert.Len(list, 1)
+}
+
+// createSQLiteBackend is a convenience function to create an SQL backend in a SQLite database
+// returns database file, backend object
+// Skips the test when the SQLite driver was built without cgo
+func createSQLiteBackend(t *testing.T) (string, *backend.SQL) {
+	dbFile := path.Join(testDir, fmt.Sprintf("backend%s.sqlite", utils.GenerateID(5)))
+	b, err := backend.NewSQL("sqlite3", dbFile)
+	if err != nil && strings.Contains(err.Error(), "cgo") {
+		t.Skipf("SQLite driver unavailable: %s", err)
+	}
+	assert.NoError(t, err)
+
+	return dbFile, b
+}
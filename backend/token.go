@@ -0,0 +1,162 @@
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/chrisvdg/gotiny/utils"
+)
+
+// tokensSuffix is appended to the backend file path to get the token file path
+const tokensSuffix = ".tokens"
+
+// tokenFilePerm keeps the token hashes private to the server user
+const tokenFilePerm os.FileMode = 0600
+
+// TokenStore is implemented by backends that can store API tokens
+type TokenStore interface {
+	// ListTokens returns the stored tokens ordered by ID
+	ListTokens() ([]Token, error)
+	// CreateToken stores a new token, returns ErrIDInUse when a token with the ID exists
+	CreateToken(token Token) error
+	// GetToken returns the token with the provided ID or ErrNotFound when there is none
+	GetToken(id string) (Token, error)
+	// RemoveToken removes the token with the provided ID or returns ErrNotFound when there is none
+	RemoveToken(id string) error
+}
+
+// Token represents a stored API token
+// Only the hash of the token secret is stored
+type Token struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Hash    string   `json:"hash"`
+	Scopes  []string `json:"scopes"`
+	Created JSONTime `json:"created"`
+}
+
+// newTokenSet returns a new token set saved to the provided file
+// The tokens are loaded from the file when it exists, an empty path keeps the tokens in memory only
+func newTokenSet(filePath string) (*tokenSet, error) {
+	s := &tokenSet{
+		filePath: filePath,
+		tokens:   map[string]Token{},
+	}
+	if filePath == "" {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token file: %s", err)
+	}
+	if len(data) != 0 {
+		err = json.Unmarshal(data, &s.tokens)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse token file: %s", err)
+		}
+	}
+
+	return s, nil
+}
+
+// tokenSet implements TokenStore for the backends that keep their data in memory
+// It is safe for concurrent use
+type tokenSet struct {
+	filePath string
+	mu       sync.RWMutex
+	tokens   map[string]Token
+}
+
+// ListTokens implements backend.TokenStore
+func (s *tokenSet) ListTokens() ([]Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []Token{}
+	for _, v := range s.tokens {
+		result = append(result, v)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	return result, nil
+}
+
+// CreateToken implements backend.TokenStore
+func (s *tokenSet) CreateToken(token Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tokens[token.ID]; ok {
+		return ErrIDInUse
+	}
+	s.tokens[token.ID] = token
+
+	err := s.save()
+	if err != nil {
+		delete(s.tokens, token.ID)
+		return err
+	}
+
+	return nil
+}
+
+// GetToken implements backend.TokenStore
+func (s *tokenSet) GetToken(id string) (Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return Token{}, ErrNotFound
+	}
+
+	return token, nil
+}
+
+// RemoveToken implements backend.TokenStore
+func (s *tokenSet) RemoveToken(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.tokens, id)
+
+	err := s.save()
+	if err != nil {
+		s.tokens[id] = token
+		return err
+	}
+
+	return nil
+}
+
+// save writes the tokens to the token file, if there is one
+// the caller is expected to hold the lock
+func (s *tokenSet) save() error {
+	if s.filePath == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.tokens)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tokens: %s", err)
+	}
+	err = utils.WriteFileAtomic(s.filePath, data, tokenFilePerm)
+	if err != nil {
+		return fmt.Errorf("failed to save tokens: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package backend
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Open returns the backend described by the provided URI
+// The scheme selects the backend:
+//
+//	file:<path>                 JSON file backend
+//	journal:<path>              journal backend
+//	bolt:<path>                 bolt key/value backend
+//	memory:                     memory backend
+//	postgres://<dsn>            sql backend on PostgreSQL, the URI is used as DSN
+//	sqlite3:<path>              sql backend on SQLite
+//	redis://<server>?prefix=<p> redis backend, prefix sets the key prefix
+//
+// Paths may be written as file:./backend.json or file:///var/lib/gotiny/backend.json
+// The sql backends require their database/sql driver to be registered
+func Open(uri string) (Backend, error) {
+	i := strings.Index(uri, ":")
+	if i <= 0 {
+		return nil, fmt.Errorf("backend URI %q has no scheme", uri)
+	}
+	scheme := strings.ToLower(uri[:i])
+	path := strings.TrimPrefix(uri[i+1:], "//")
+
+	switch scheme {
+	case "file":
+		return NewFile(path)
+	case "journal":
+		return NewJournal(path, 0)
+	case "bolt":
+		return NewBolt(path)
+	case "memory":
+		return NewMemory(), nil
+	case "postgres", "postgresql":
+		return NewSQL("postgres", uri)
+	case "sqlite3", "sqlite":
+		return NewSQL("sqlite3", path)
+	case "redis", "rediss":
+		return openRedis(uri)
+	default:
+		return nil, fmt.Errorf("unknown backend URI scheme %q", scheme)
+	}
+}
+
+// openRedis returns the redis backend described by the URI
+// the prefix query parameter is used as key prefix and not passed on to the redis client
+func openRedis(uri string) (*Redis, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse redis URI: %s", err)
+	}
+	q := u.Query()
+	prefix := q.Get("prefix")
+	q.Del("prefix")
+	u.RawQuery = q.Encode()
+
+	return NewRedis(u.String(), prefix)
+}
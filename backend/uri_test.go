@@ -0,0 +1,61 @@
+package backend_test
+
+import (
+	"path"
+	"testing"
+
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Open(t *testing.T) {
+	assert := assert.New(t)
+
+	tt := []struct {
+		uri      string
+		expected interface{}
+	}{
+		{"file:" + path.Join(testDir, "open.json"), &backend.File{}},
+		{"file://" + path.Join(testDir, "open_slashes.json"), &backend.File{}},
+		{"journal:" + path.Join(testDir, "open.journal"), &backend.Journal{}},
+		{"bolt:" + path.Join(testDir, "open.db"), &backend.Bolt{}},
+		{"memory:", &backend.Memory{}},
+	}
+
+	for _, tc := range tt {
+		b, err := backend.Open(tc.uri)
+		if !assert.NoError(err, tc.uri) {
+			continue
+		}
+		assert.IsType(tc.expected, b, tc.uri)
+		_, err = b.Create("foo", "http://foo.bar")
+		assert.NoError(err, tc.uri)
+		assert.NoError(b.Close(), tc.uri)
+	}
+}
+
+func Test_OpenRedisPrefix(t *testing.T) {
+	assert := assert.New(t)
+	srv := newRESPServer(t)
+	defer srv.Close()
+
+	b1, err := backend.Open(srv.URL() + "?prefix=one:")
+	assert.NoError(err)
+	defer b1.Close()
+	b2, err := backend.Open(srv.URL() + "?prefix=two:")
+	assert.NoError(err)
+	defer b2.Close()
+
+	id, _ := addEntry(t, b1)
+	_, err = b2.Get(id)
+	assert.Equal(backend.ErrNotFound, err)
+}
+
+func Test_OpenInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, uri := range []string{"", "backend.json", "foo:bar", "file:", "bolt://"} {
+		_, err := backend.Open(uri)
+		assert.Error(err, uri)
+	}
+}
@@ -0,0 +1,132 @@
+package business
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/chrisvdg/gotiny/backend"
+	log "github.com/sirupsen/logrus"
+)
+
+// noExpiry is the ttl or expires value that removes the expiry of an entry
+const noExpiry = "0"
+
+// parseExpiry returns the expiry time set by either a ttl duration (e.g. 24h)
+// or an absolute expires time, given as unix time stamp or RFC 3339 time
+// A nil time is returned when neither is set or when either is set to noExpiry
+func parseExpiry(ttl string, expires string, now time.Time) (*backend.JSONTime, error) {
+	if ttl != "" && expires != "" {
+		return nil, ErrInvalidExpiry
+	}
+	if ttl == noExpiry || expires == noExpiry {
+		return nil, nil
+	}
+
+	var t time.Time
+	switch {
+	case ttl != "":
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			return nil, ErrInvalidExpiry
+		}
+		t = now.Add(d)
+	case expires != "":
+		if unix, err := strconv.ParseInt(expires, 10, 64); err == nil {
+			t = time.Unix(unix, 0)
+		} else if t, err = time.Parse(time.RFC3339, expires); err != nil {
+			return nil, ErrInvalidExpiry
+		}
+		if !t.After(now) {
+			return nil, ErrInvalidExpiry
+		}
+	default:
+		return nil, nil
+	}
+
+	result := backend.JSONTime(t)
+	return &result, nil
+}
+
+// RemoveExpired removes the entries that expired longer than the grace period ago from the backend
+// and returns the amount of removed entries
+func (l *Logic) RemoveExpired(grace time.Duration) (int, error) {
+	list, err := l.backend.List()
+	if err != nil {
+		return 0, err
+	}
+
+	deadline := time.Now().Add(-grace)
+	removed := 0
+	for _, entry := range list {
+		if !entry.Expired(deadline) {
+			continue
+		}
+
+		l.writeMu.Lock()
+		// The entry may have been updated since it was listed
+		current, err := l.backend.Get(entry.ID)
+		if err == nil && current.Expired(deadline) {
+			err = l.backend.Remove(entry.ID)
+			if err == nil {
+				l.removeStats(entry.ID)
+				removed++
+			}
+		}
+		l.writeMu.Unlock()
+		if err != nil && err != backend.ErrNotFound {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// StartReaper removes expired entries in the background every interval, see RemoveExpired
+// The reaper is stopped by Close
+func (l *Logic) StartReaper(interval time.Duration, grace time.Duration) {
+	l.reaperMu.Lock()
+	defer l.reaperMu.Unlock()
+	if l.reaperStop != nil {
+		return
+	}
+
+	l.reaperStop = make(chan struct{})
+	l.reaperDone = make(chan struct{})
+	go l.reaper(interval, grace, l.reaperStop, l.reaperDone)
+}
+
+// stopReaper stops the reaper and waits for it to finish, if it was started
+func (l *Logic) stopReaper() {
+	l.reaperMu.Lock()
+	defer l.reaperMu.Unlock()
+	if l.reaperStop == nil {
+		return
+	}
+
+	close(l.reaperStop)
+	<-l.reaperDone
+	l.reaperStop = nil
+	l.reaperDone = nil
+}
+
+// reaper removes expired entries every interval until stop is closed
+func (l *Logic) reaper(interval time.Duration, grace time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			n, err := l.RemoveExpired(grace)
+			if err != nil {
+				log.Errorf("Failed to remove expired entries: %s", err)
+			}
+			if n > 0 {
+				log.Debugf("Removed %d expired entries", n)
+			}
+		}
+	}
+}
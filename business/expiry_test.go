@@ -0,0 +1,221 @@
+package business_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/business"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CreateWithTTL(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+	var tResult backend.TinyURL
+
+	before := time.Now()
+	result, err := l.CreateWithOptions("foo", "http://foo.bar", business.EntryOptions{TTL: "24h"})
+	assert.NoError(err)
+	err = json.Unmarshal(result, &tResult)
+	assert.NoError(err)
+	if assert.NotNil(tResult.Expires) {
+		assert.InDelta(before.Add(24*time.Hour).Unix(), tResult.Expires.Unix(), 1)
+	}
+
+	url, err := l.GetURL("foo")
+	assert.NoError(err)
+	assert.Equal("http://foo.bar", url)
+}
+
+func Test_CreateWithExpires(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	for i, value := range []string{
+		expires.Format(time.RFC3339),
+		strconv.FormatInt(expires.Unix(), 10),
+	} {
+		var tResult backend.TinyURL
+		result, err := l.CreateWithOptions("", "http://foo.bar", business.EntryOptions{Expires: value})
+		assert.NoError(err, "case %d", i)
+		err = json.Unmarshal(result, &tResult)
+		assert.NoError(err)
+		if assert.NotNil(tResult.Expires) {
+			assert.Equal(expires.Unix(), tResult.Expires.Unix())
+		}
+	}
+}
+
+func Test_CreateInvalidExpiry(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	for _, opts := range []business.EntryOptions{
+		{TTL: "tomorrow"},
+		{TTL: "-1h"},
+		{Expires: "yesterday"},
+		{Expires: "1590330837"},
+		{TTL: "1h", Expires: "4102444800"},
+	} {
+		_, err := l.CreateWithOptions("foo", "http://foo.bar", opts)
+		assert.Equal(business.ErrInvalidExpiry, err, "%+v", opts)
+		assert.True(business.IsValidationError(err))
+	}
+}
+
+// Test_CreateExpiringNotShared tests that generated IDs are not shared with or by entries that expire
+func Test_CreateExpiringNotShared(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+	var first, second, third backend.TinyURL
+
+	result, err := l.Create("", "http://foo.bar")
+	assert.NoError(err)
+	assert.NoError(json.Unmarshal(result, &first))
+	result, err = l.CreateWithOptions("", "http://foo.bar", business.EntryOptions{TTL: "1h"})
+	assert.NoError(err)
+	assert.NoError(json.Unmarshal(result, &second))
+	assert.NotEqual(first.ID, second.ID)
+
+	b := backend.NewMemory()
+	l = business.NewLogic(b, false, 5)
+	result, err = l.CreateWithOptions("", "http://foo.bar", business.EntryOptions{TTL: "1h"})
+	assert.NoError(err)
+	assert.NoError(json.Unmarshal(result, &second))
+	result, err = l.Create("", "http://foo.bar")
+	assert.NoError(err)
+	assert.NoError(json.Unmarshal(result, &third))
+	assert.NotEqual(second.ID, third.ID)
+	assert.Nil(third.Expires)
+}
+
+func Test_GetURLExpired(t *testing.T) {
+	assert := assert.New(t)
+	b := backend.NewMemory()
+	l := business.NewLogic(b, false, 5)
+
+	addExpiredEntry(t, b, "foo", time.Minute)
+	_, err := l.GetURL("foo")
+	assert.Equal(business.ErrTinyURLExpired, err)
+}
+
+// Test_CreateReplacesExpired tests that the ID of an expired entry can be reused before it is removed
+func Test_CreateReplacesExpired(t *testing.T) {
+	assert := assert.New(t)
+	b := backend.NewMemory()
+	l := business.NewLogic(b, false, 5)
+
+	addExpiredEntry(t, b, "foo", time.Minute)
+	_, err := l.Create("foo", "http://lorem.ipsum")
+	assert.NoError(err)
+	url, err := l.GetURL("foo")
+	assert.NoError(err)
+	assert.Equal("http://lorem.ipsum", url)
+}
+
+func Test_UpdateExpiry(t *testing.T) {
+	assert := assert.New(t)
+	b := backend.NewMemory()
+	l := business.NewLogic(b, false, 5)
+
+	_, err := l.CreateWithOptions("foo", "http://foo.bar", business.EntryOptions{TTL: "1h"})
+	assert.NoError(err)
+
+	// Options that are not set are left unchanged
+	err = l.Update("foo", "http://lorem.ipsum")
+	assert.NoError(err)
+	entry, err := b.Get("foo")
+	assert.NoError(err)
+	assert.NotNil(entry.Expires)
+
+	err = l.UpdateWithOptions("foo", "http://lorem.ipsum", business.EntryOptions{TTL: "48h"})
+	assert.NoError(err)
+	entry, err = b.Get("foo")
+	assert.NoError(err)
+	if assert.NotNil(entry.Expires) {
+		assert.InDelta(time.Now().Add(48*time.Hour).Unix(), entry.Expires.Unix(), 1)
+	}
+
+	err = l.UpdateWithOptions("foo", "http://lorem.ipsum", business.EntryOptions{TTL: "0"})
+	assert.NoError(err)
+	entry, err = b.Get("foo")
+	assert.NoError(err)
+	assert.Nil(entry.Expires)
+
+	err = l.UpdateWithOptions("foo", "http://lorem.ipsum", business.EntryOptions{TTL: "never"})
+	assert.Equal(business.ErrInvalidExpiry, err)
+}
+
+func Test_RemoveExpired(t *testing.T) {
+	assert := assert.New(t)
+	b := backend.NewMemory()
+	l := business.NewLogic(b, false, 5)
+
+	addExpiredEntry(t, b, "old", 2*time.Hour)
+	addExpiredEntry(t, b, "recent", time.Minute)
+	_, err := l.CreateWithOptions("future", "http://foo.bar", business.EntryOptions{TTL: "1h"})
+	assert.NoError(err)
+	_, err = l.Create("forever", "http://foo.bar")
+	assert.NoError(err)
+
+	n, err := l.RemoveExpired(time.Hour)
+	assert.NoError(err)
+	assert.Equal(1, n)
+	_, err = b.Get("old")
+	assert.Equal(backend.ErrNotFound, err)
+	for _, id := range []string{"recent", "future", "forever"} {
+		_, err = b.Get(id)
+		assert.NoError(err, id)
+	}
+}
+
+func Test_Reaper(t *testing.T) {
+	assert := assert.New(t)
+	b := backend.NewMemory()
+	l := business.NewLogic(b, false, 5)
+
+	addExpiredEntry(t, b, "foo", time.Minute)
+	l.StartReaper(10*time.Millisecond, 0)
+	assert.Eventually(func() bool {
+		_, err := b.Get("foo")
+		return err == backend.ErrNotFound
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(l.Close())
+}
+
+// addExpiredEntry adds an entry with the provided ID that expired the provided duration ago
+func addExpiredEntry(t *testing.T, b backend.EntryCreator, id string, ago time.Duration) {
+	expires := backend.JSONTime(time.Now().Add(-ago))
+	_, err := b.CreateEntry(backend.TinyURL{ID: id, URL: "http://" + id + ".bar", Expires: &expires})
+	assert.NoError(t, err)
+}
+
+func Test_UpdateOptionsOnly(t *testing.T) {
+	assert := assert.New(t)
+	b := backend.NewMemory()
+	l := business.NewLogic(b, false, 5)
+
+	_, err := l.CreateWithOptions("foo", "http://foo.bar", business.EntryOptions{})
+	assert.NoError(err)
+
+	// An update without URL keeps the URL
+	err = l.UpdateWithOptions("foo", "", business.EntryOptions{TTL: "1h"})
+	assert.NoError(err)
+	err = l.UpdateWithOptions("foo", "", business.EntryOptions{Redirect: "308"})
+	assert.NoError(err)
+	err = l.UpdateWithOptions("foo", "", business.EntryOptions{Password: "secret"})
+	assert.NoError(err)
+	entry, err := b.Get("foo")
+	assert.NoError(err)
+	assert.Equal("http://foo.bar", entry.URL)
+	assert.NotNil(entry.Expires)
+	assert.Equal(308, entry.Redirect)
+	assert.NotEmpty(entry.PasswordHash)
+
+	err = l.UpdateWithOptions("foo", "foo.bar", business.EntryOptions{})
+	assert.Error(err, "A URL that is sent should still be validated")
+}
@@ -4,8 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/chrisvdg/gotiny/analytics"
 	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/policy"
 	"github.com/chrisvdg/gotiny/utils"
 	log "github.com/sirupsen/logrus"
 )
@@ -17,33 +21,140 @@ func NewFileBackedLogic(backendPath string, prettyJSON bool, defaultIDLen int) (
 		return nil, err
 	}
 
+	return NewLogic(b, prettyJSON, defaultIDLen), nil
+}
+
+// NewLogic creates a new Logic instance on top of the provided backend
+func NewLogic(b backend.Backend, prettyJSON bool, defaultIDLen int) *Logic {
 	if defaultIDLen <= 0 {
 		defaultIDLen = 5
 	}
-	l := &Logic{
-		backend:      b,
-		prettyJSON:   prettyJSON,
-		defaultIDLen: defaultIDLen,
-	}
 
-	return l, nil
+	return &Logic{
+		backend:         b,
+		prettyJSON:      prettyJSON,
+		defaultIDLen:    defaultIDLen,
+		defaultRedirect: DefaultRedirect,
+		unlockLimiter:   newAttemptLimiter(UnlockAttempts, UnlockWindow),
+		policy:          policy.Default(),
+	}
 }
 
 // Logic contains a stateful set of business logic
+// It is safe for concurrent use
 type Logic struct {
 	backend backend.Backend
 	// Prettifies the json respresentation
 	prettyJSON   bool
 	defaultIDLen int
+	// defaultRedirect is the redirect status code of entries without one
+	defaultRedirect int
+	// writeMu serializes operations that read from the backend before writing to it
+	writeMu sync.Mutex
+	// unlockLimiter counts failed unlock attempts of password protected entries
+	unlockLimiter *attemptLimiter
+	// enforceOwners restricts access to entries to their owner
+	enforceOwners bool
+	// policy checks the URLs and IDs of entries
+	policy Policy
+
+	reaperMu   sync.Mutex
+	reaperStop chan struct{}
+	reaperDone chan struct{}
+
+	// Set when analytics are enabled
+	recorder *analytics.Recorder
+	stats    analytics.Store
+}
+
+// Policy checks the destination URLs and IDs of entries, see policy.File
+type Policy interface {
+	// CheckURL returns ErrURLNotAllowed when entries can not redirect to the URL
+	CheckURL(url string) error
+	// CheckID returns ErrIDNotAllowed when entries can not use the ID
+	CheckID(id string) error
+}
+
+// SetPolicy checks the URLs and IDs of created and updated entries with the policy
+// policy.Default() is used until it is set
+// It should be called before the logic instance is used
+func (l *Logic) SetPolicy(p Policy) {
+	l.policy = p
+}
+
+// checkURL validates the URL and checks it with the policy
+func (l *Logic) checkURL(url string) error {
+	err := utils.ValidateURL(url)
+	if err != nil {
+		return err
+	}
+
+	return l.policy.CheckURL(url)
+}
+
+// maxGenerateAttempts is the amount of generated IDs that are tried before creating an entry fails
+const maxGenerateAttempts = 100
+
+// generateID returns a random ID the policy allows
+// Not guaranteed to be unique
+func (l *Logic) generateID() (string, error) {
+	for i := 0; i < maxGenerateAttempts; i++ {
+		id := utils.GenerateID(l.defaultIDLen)
+		if l.policy.CheckID(id) == nil {
+			return id, nil
+		}
+	}
+
+	return "", fmt.Errorf("failed to generate an ID the policy allows in %d attempts", maxGenerateAttempts)
+}
+
+// EntryOptions represents the optional settings of an entry
+type EntryOptions struct {
+	// TTL is the duration after which the entry expires (e.g. 24h)
+	TTL string
+	// Expires is the time at which the entry expires, as unix time stamp or RFC 3339 time
+	// Only one of TTL and Expires can be set, on update either set to "0" removes the expiry
+	Expires string
+	// Redirect is the HTTP status code used to redirect (301, 302, 307 or 308),
+	// "0" selects the server default
+	Redirect string
+	// Password protects the entry, it is stored as hash
+	// On update an empty password leaves the current one unchanged
+	Password string
+	// RemovePassword removes the password of the entry on update
+	RemovePassword bool
+	// Caller is the identity creating or updating the entry, it becomes the owner of created entries
+	// A nil caller creates entries without owner and is not restricted by ownership
+	Caller *Identity
 }
 
 // List retrieves a list of entries from the backend and returns a json encoding of that list
 func (l *Logic) List() ([]byte, error) {
-	bData, err := l.backend.List()
+	return l.ListPrefix("")
+}
+
+// ListPrefix retrieves a list of entries of which the ID starts with the provided prefix
+// from the backend and returns a json encoding of that list
+func (l *Logic) ListPrefix(prefix string) ([]byte, error) {
+	return l.ListPrefixAs(nil, prefix)
+}
+
+// ListPrefixAs is ListPrefix for the provided caller,
+// the list only contains the entries the caller owns when ownership is enforced and it lacks the read scope
+// The URL of password protected entries is left out
+func (l *Logic) ListPrefixAs(caller *Identity, prefix string) ([]byte, error) {
+	bData, err := l.listPrefix(prefix)
 	if err != nil {
 		log.Error(err)
 		return nil, fmt.Errorf("Failed to list tiny URL entries")
 	}
+	owned := []backend.TinyURL{}
+	for _, i := range bData {
+		if l.canList(caller, i) {
+			owned = append(owned, withoutProtectedURL(i))
+		}
+	}
+	bData = owned
 
 	result, err := formatList(bData, l.prettyJSON)
 	if err != nil {
@@ -54,11 +165,45 @@ func (l *Logic) List() ([]byte, error) {
 	return result, nil
 }
 
+// listPrefix lists the backend entries of which the ID starts with the provided prefix
+// using the backend's prefix scan if it has one
+func (l *Logic) listPrefix(prefix string) ([]backend.TinyURL, error) {
+	if prefix == "" {
+		return l.backend.List()
+	}
+	if lister, ok := l.backend.(backend.PrefixLister); ok {
+		return lister.ListPrefix(prefix)
+	}
+
+	list, err := l.backend.List()
+	if err != nil {
+		return nil, err
+	}
+	result := []backend.TinyURL{}
+	for _, i := range list {
+		if strings.HasPrefix(i.ID, prefix) {
+			result = append(result, i)
+		}
+	}
+
+	return result, nil
+}
+
 // Create creates a new entry in the backend
 func (l *Logic) Create(id string, url string) ([]byte, error) {
+	return l.CreateWithOptions(id, url, EntryOptions{})
+}
+
+// CreateWithOptions creates a new entry with the provided options in the backend
+func (l *Logic) CreateWithOptions(id string, url string, opts EntryOptions) ([]byte, error) {
 	errDefault := fmt.Errorf("Failed to create new entry")
+	var err error
 	if id != "" {
-		err := utils.ValidateID(id)
+		err = utils.ValidateID(id)
+		if err != nil {
+			return nil, err
+		}
+		err = l.policy.CheckID(id)
 		if err != nil {
 			return nil, err
 		}
@@ -67,50 +212,98 @@ func (l *Logic) Create(id string, url string) ([]byte, error) {
 	if !strings.HasPrefix(url, "http") {
 		url = fmt.Sprintf("http://%s", url)
 	}
-	err := utils.ValidateURL(url)
+	err = l.checkURL(url)
+	if err != nil {
+		return nil, err
+	}
+	expires, err := parseExpiry(opts.TTL, opts.Expires, time.Now())
 	if err != nil {
 		return nil, err
 	}
+	redirect, err := parseRedirect(opts.Redirect)
+	if err != nil {
+		return nil, err
+	}
+	passwordHash, _, err := passwordOption(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	owner := ownerOf(opts.Caller)
+	var existing backend.TinyURL
+	var data []byte
 
 	// If requesting generated ID, check if URL already has an entry in the backend
-	if id == "" {
-		list, err := l.backend.List()
-		if err != nil {
+	// Entries that expire, redirect differently or are password protected are not shared,
+	// nor are entries of other owners when ownership is enforced
+	if id == "" && expires == nil && passwordHash == "" {
+		existing, err = l.findURL(url, func(existing backend.TinyURL) bool {
+			return existing.Expires == nil && existing.Redirect == redirect && !protected(existing) &&
+				(!l.enforceOwners || existing.Owner == owner)
+		})
+		if err == nil {
+			data, err = formatEntry(existing, l.prettyJSON)
+			if err != nil {
+				log.Error(err)
+				return nil, errDefault
+			}
+
+			return data, nil
+		}
+		if err != backend.ErrNotFound {
 			log.Error(err)
 			return nil, errDefault
 		}
-		for _, i := range list {
-			if i.URL == url {
-				data, err := formatEntry(i, l.prettyJSON)
-				if err != nil {
-					log.Error(err)
-					return nil, errDefault
-				}
+	}
 
-				return data, nil
+	// Expired entries free up their ID before they are removed by the reaper
+	if id != "" {
+		existing, err = l.backend.Get(id)
+		if err == nil && existing.Expired(time.Now()) {
+			err = l.backend.Remove(id)
+			if err == nil {
+				l.removeStats(id)
 			}
 		}
+		if err != nil && err != backend.ErrNotFound {
+			log.Error(err)
+			return nil, errDefault
+		}
 	}
 
 	// Retry when ID was generated
 	var res backend.TinyURL
-	entryID := id
-	for {
+	for attempt := 1; ; attempt++ {
+		entryID := id
 		if entryID == "" {
-			entryID = utils.GenerateID(l.defaultIDLen)
-		}
-		err := utils.ValidateID(id)
-		if err != nil {
-			return nil, err
+			entryID, err = l.generateID()
+			if err != nil {
+				log.Error(err)
+				return nil, errDefault
+			}
 		}
 
-		res, err = l.backend.Create(entryID, url)
+		res, err = l.createEntry(backend.TinyURL{
+			ID:           entryID,
+			URL:          url,
+			Expires:      expires,
+			Redirect:     redirect,
+			PasswordHash: passwordHash,
+			Owner:        owner,
+		})
 		if err != nil {
 			if err == backend.ErrIDInUse && id == "" {
-				continue
+				if attempt < maxGenerateAttempts {
+					continue
+				}
+				log.Errorf("Failed to generate an unused ID in %d attempts", maxGenerateAttempts)
+				return nil, errDefault
 			}
 			log.Error(err)
-			if !IsValidationError(err) {
+			if !IsValidationError(err) && err != ErrOptionsUnsupported {
 				err = errDefault
 			}
 			return nil, err
@@ -119,7 +312,7 @@ func (l *Logic) Create(id string, url string) ([]byte, error) {
 		break
 	}
 
-	data, err := formatEntry(res, l.prettyJSON)
+	data, err = formatEntry(res, l.prettyJSON)
 	if err != nil {
 		log.Error(err)
 		return nil, errDefault
@@ -128,23 +321,77 @@ func (l *Logic) Create(id string, url string) ([]byte, error) {
 	return data, nil
 }
 
+// SupportsEntryOptions returns true if the backend can create entries with an expiry, redirect,
+// password or owner
+func (l *Logic) SupportsEntryOptions() bool {
+	_, ok := l.backend.(backend.EntryCreator)
+	return ok
+}
+
+// createEntry creates the entry using the backend's entry creation if it has one
+// Other backends only store the ID and URL, ErrOptionsUnsupported is returned for entries with options
+// and the owner is not recorded
+func (l *Logic) createEntry(entry backend.TinyURL) (backend.TinyURL, error) {
+	if creator, ok := l.backend.(backend.EntryCreator); ok {
+		return creator.CreateEntry(entry)
+	}
+	if entry.Expires != nil || entry.Redirect != 0 || protected(entry) {
+		return backend.TinyURL{}, ErrOptionsUnsupported
+	}
+
+	return l.backend.Create(entry.ID, entry.URL)
+}
+
+// findURL returns an entry with the provided URL for which match returns true or backend.ErrNotFound
+// using the backend's URL lookup if it has one
+func (l *Logic) findURL(url string, match func(backend.TinyURL) bool) (backend.TinyURL, error) {
+	if finder, ok := l.backend.(backend.URLFinder); ok {
+		return finder.FindURL(url, match)
+	}
+
+	list, err := l.backend.List()
+	if err != nil {
+		return backend.TinyURL{}, err
+	}
+	for _, i := range list {
+		if i.URL == url && match(i) {
+			return i, nil
+		}
+	}
+
+	return backend.TinyURL{}, backend.ErrNotFound
+}
+
 // GetURL returns the URL for the given ID
+// returns ErrTinyURLExpired when the entry expired
+// and ErrPasswordRequired when the entry is password protected, see Unlock
 func (l *Logic) GetURL(id string) (string, error) {
+	url, _, err := l.GetRedirect(id)
+
+	return url, err
+}
+
+// getUnexpired returns the entry of the given ID or ErrTinyURLExpired when it expired
+func (l *Logic) getUnexpired(id string) (backend.TinyURL, error) {
 	entry, err := l.backend.Get(id)
 	if err != nil {
-		return "", err
+		return backend.TinyURL{}, err
+	}
+	if entry.Expired(time.Now()) {
+		return backend.TinyURL{}, ErrTinyURLExpired
 	}
 
-	return entry.URL, nil
+	return entry, nil
 }
 
 // Get returns a json endcoded
+// The URL of a password protected entry is left out, see Unlock
 func (l *Logic) Get(id string) ([]byte, error) {
 	entry, err := l.backend.Get(id)
 	if err != nil {
 		return nil, err
 	}
-	data, err := formatEntry(entry, l.prettyJSON)
+	data, err := formatEntry(withoutProtectedURL(entry), l.prettyJSON)
 	if err != nil {
 		log.Error(err)
 		return nil, fmt.Errorf("Failed to get tiny URL entry")
@@ -154,24 +401,62 @@ func (l *Logic) Get(id string) ([]byte, error) {
 
 // Update updates an entry in the backend
 func (l *Logic) Update(id string, url string) error {
-	original, err := l.backend.Get(id)
+	return l.UpdateWithOptions(id, url, EntryOptions{})
+}
+
+// UpdateWithOptions updates an entry and the provided options in the backend
+// The URL and options that are not set are left unchanged, the owner of the entry is never changed
+// returns ErrNotOwner when ownership is enforced and the caller does not own the entry
+func (l *Logic) UpdateWithOptions(id string, url string, opts EntryOptions) error {
+	expires, err := parseExpiry(opts.TTL, opts.Expires, time.Now())
 	if err != nil {
-		log.Error(err)
 		return err
 	}
-	if url == original.URL {
-		return nil
+	redirect, err := parseRedirect(opts.Redirect)
+	if err != nil {
+		return err
+	}
+	passwordHash, setPassword, err := passwordOption(opts)
+	if err != nil {
+		return err
 	}
 
-	err = utils.ValidateURL(url)
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	original, err := l.backend.Get(id)
 	if err != nil {
+		log.Error(err)
 		return err
 	}
+	if !l.canAccess(opts.Caller, original) {
+		return ErrNotOwner
+	}
+	entry := original
+	if url != "" {
+		entry.URL = url
+	}
+	if opts.TTL != "" || opts.Expires != "" {
+		entry.Expires = expires
+	}
+	if opts.Redirect != "" {
+		entry.Redirect = redirect
+	}
+	if setPassword {
+		entry.PasswordHash = passwordHash
+	}
+	if entry.URL == original.URL && sameExpiry(entry.Expires, original.Expires) &&
+		entry.Redirect == original.Redirect && entry.PasswordHash == original.PasswordHash {
+		return nil
+	}
 
-	entry := backend.TinyURL{
-		ID:  id,
-		URL: url,
+	if entry.URL != original.URL {
+		err = l.checkURL(entry.URL)
+		if err != nil {
+			return err
+		}
 	}
+
 	err = l.backend.Update(entry)
 	if err != nil {
 		log.Error(err)
@@ -183,7 +468,16 @@ func (l *Logic) Update(id string, url string) error {
 
 // Delete deletes an entry from the backend
 func (l *Logic) Delete(id string) error {
-	_, err := l.backend.Get(id)
+	return l.DeleteAs(nil, id)
+}
+
+// DeleteAs is Delete for the provided caller
+// returns ErrNotOwner when ownership is enforced and the caller does not own the entry
+func (l *Logic) DeleteAs(caller *Identity, id string) error {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	entry, err := l.backend.Get(id)
 	if err != nil {
 		if err == backend.ErrNotFound {
 			return nil
@@ -191,21 +485,92 @@ func (l *Logic) Delete(id string) error {
 		log.Error(err)
 		return fmt.Errorf("Failed to delete entry")
 	}
+	if !l.canAccess(caller, entry) {
+		return ErrNotOwner
+	}
 
 	err = l.backend.Remove(id)
 	if err != nil {
 		log.Error(err)
 		return fmt.Errorf("Failed to delete entry")
 	}
+	l.removeStats(id)
 
 	return nil
 }
 
-// Close gracefully closes the backend
+// EnableAnalytics records the hits of followed entries in the provided store
+// Hits are buffered and recorded in the background, so statistics lag behind by up to the flush interval
+// It should be called before the logic instance is used, the store is closed by Close
+func (l *Logic) EnableAnalytics(store analytics.Store, opts analytics.RecorderOptions) {
+	l.stats = store
+	l.recorder = analytics.NewRecorder(store, opts)
+}
+
+// RecordHit records a hit of a followed entry when analytics are enabled
+func (l *Logic) RecordHit(hit analytics.Hit) {
+	if l.recorder == nil {
+		return
+	}
+	l.recorder.Record(hit)
+}
+
+// Stats returns the json encoded statistics of an entry
+func (l *Logic) Stats(id string) ([]byte, error) {
+	if l.stats == nil {
+		return nil, ErrAnalyticsDisabled
+	}
+	_, err := l.backend.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := l.stats.Stats(id)
+	if err != nil {
+		log.Error(err)
+		return nil, fmt.Errorf("Failed to get tiny URL statistics")
+	}
+	data, err := formatStats(stats, l.prettyJSON)
+	if err != nil {
+		log.Error(err)
+		return nil, fmt.Errorf("Failed to get tiny URL statistics")
+	}
+
+	return data, nil
+}
+
+// removeStats removes the statistics of a removed entry when analytics are enabled
+func (l *Logic) removeStats(id string) {
+	if l.stats == nil {
+		return
+	}
+	err := l.stats.Remove(id)
+	if err != nil {
+		log.Errorf("Failed to remove statistics of %s: %s", id, err)
+	}
+}
+
+// Close stops the reaper and the analytics recorder and gracefully closes the backend
 func (l *Logic) Close() error {
+	l.stopReaper()
+	if l.recorder != nil {
+		err := l.recorder.Close()
+		if err != nil {
+			log.Errorf("Failed to close analytics store: %s", err)
+		}
+	}
 	return l.backend.Close()
 }
 
+// sameExpiry returns true if both expiry times are unset or equal
+func sameExpiry(a *backend.JSONTime, b *backend.JSONTime) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Unix() == b.Unix()
+}
+
 func formatList(entries []backend.TinyURL, pretty bool) ([]byte, error) {
 	if pretty {
 		return json.MarshalIndent(entries, "", "\t")
@@ -221,3 +586,11 @@ func formatEntry(entry backend.TinyURL, pretty bool) ([]byte, error) {
 
 	return json.Marshal(entry)
 }
+
+func formatStats(stats analytics.Stats, pretty bool) ([]byte, error) {
+	if pretty {
+		return json.MarshalIndent(stats, "", "\t")
+	}
+
+	return json.Marshal(stats)
+}
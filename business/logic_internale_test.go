@@ -2,6 +2,7 @@ package business
 
 import (
 	"testing"
+	"time"
 
 	"github.com/chrisvdg/gotiny/backend"
 	"github.com/stretchr/testify/assert"
@@ -62,3 +63,27 @@ func Test_FormatEntry(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal(expectedPrettyOutput, prettyOut)
 }
+
+func Test_AttemptLimiter(t *testing.T) {
+	assert := assert.New(t)
+	a := newAttemptLimiter(2, time.Minute)
+	now := time.Now()
+
+	assert.Zero(a.wait("foo", now))
+	a.fail("foo", now)
+	assert.Zero(a.wait("foo", now))
+	a.fail("foo", now.Add(10*time.Second))
+	assert.Equal(50*time.Second, a.wait("foo", now.Add(10*time.Second)))
+	assert.Zero(a.wait("bar", now), "Other keys should not be limited")
+	assert.Zero(a.wait("foo", now.Add(time.Minute)), "Attempts should be forgotten after the window")
+
+	a.fail("foo", now)
+	a.fail("foo", now)
+	a.reset("foo")
+	assert.Zero(a.wait("foo", now))
+
+	// Pruning removes the attempts of which the window passed
+	a.fail("foo", now)
+	a.fail("bar", now.Add(2*time.Minute))
+	assert.Len(a.attempts, 1)
+}
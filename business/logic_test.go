@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"path"
+	"sync"
 	"testing"
 
 	"github.com/chrisvdg/gotiny/backend"
@@ -86,8 +87,7 @@ func Test_List(t *testing.T) {
 
 func Test_Create(t *testing.T) {
 	assert := assert.New(t)
-	l, err := business.NewFileBackedLogic(getFilePath(), false, 5)
-	assert.NoError(err)
+	l := newMemoryLogic()
 	var tResult backend.TinyURL
 
 	result, err := l.Create("foo", "http://foo.bar")
@@ -115,8 +115,7 @@ func Test_Create(t *testing.T) {
 
 func Test_CreateNoID(t *testing.T) {
 	assert := assert.New(t)
-	l, err := business.NewFileBackedLogic(getFilePath(), false, 5)
-	assert.NoError(err)
+	l := newMemoryLogic()
 	var tResult backend.TinyURL
 
 	result, err := l.Create("", "http://foo.bar")
@@ -129,18 +128,21 @@ func Test_CreateNoID(t *testing.T) {
 
 func Test_CreateInvalidID(t *testing.T) {
 	assert := assert.New(t)
-	l, err := business.NewFileBackedLogic(getFilePath(), false, 5)
-	assert.NoError(err)
+	l := newMemoryLogic()
 
 	result, err := l.Create("foo bar", "http://foo.bar")
 	assert.Error(err)
+	assert.True(business.IsValidationError(err), "The validation error should be returned")
+	assert.Nil(result)
+
+	result, err = l.CreateWithOptions("foo bar", "http://foo.bar", business.EntryOptions{TTL: "1h"})
+	assert.True(business.IsValidationError(err), "The validation error should be returned")
 	assert.Nil(result)
 }
 
 func Test_CreateInvalidURL(t *testing.T) {
 	assert := assert.New(t)
-	l, err := business.NewFileBackedLogic(getFilePath(), false, 5)
-	assert.NoError(err)
+	l := newMemoryLogic()
 
 	result, err := l.Create("foo", "http://foo bar")
 	assert.Error(err)
@@ -149,14 +151,13 @@ func Test_CreateInvalidURL(t *testing.T) {
 
 func Test_CreateAlreadyExists(t *testing.T) {
 	assert := assert.New(t)
-	l, err := business.NewFileBackedLogic(getFilePath(), false, 5)
-	assert.NoError(err)
+	l := newMemoryLogic()
 
 	id := "foo"
 	url := "http://foo.bar"
 	url2 := "http://hello.world"
 
-	_, err = l.Create(id, url)
+	_, err := l.Create(id, url)
 	assert.NoError(err)
 
 	// Creating with same ID and URL should not return an error
@@ -172,14 +173,13 @@ func Test_CreateAlreadyExists(t *testing.T) {
 // but there is already an entry using this url, it will use the ID that already exists in the backend
 func Test_CreateURLExists(t *testing.T) {
 	assert := assert.New(t)
-	l, err := business.NewFileBackedLogic(getFilePath(), false, 5)
-	assert.NoError(err)
+	l := newMemoryLogic()
 	var tResult backend.TinyURL
 
 	id := "foo"
 	url := "http://foo.bar"
 
-	_, err = l.Create(id, url)
+	_, err := l.Create(id, url)
 	assert.NoError(err)
 
 	result, err := l.Create("", url)
@@ -190,14 +190,94 @@ func Test_CreateURLExists(t *testing.T) {
 	assert.Equal(url, tResult.URL)
 }
 
-func Test_GetURL(t *testing.T) {
+// Test_CreateURLExistsIndexed tests the generated ID deduplication
+// on a backend that looks up entries by URL itself
+func Test_CreateURLExistsIndexed(t *testing.T) {
 	assert := assert.New(t)
-	l, err := business.NewFileBackedLogic(getFilePath(), false, 5)
+	b, err := backend.NewBolt(getFilePath())
+	assert.NoError(err)
+	defer b.Close()
+	l := business.NewLogic(b, false, 5)
+	var tResult backend.TinyURL
+
+	_, err = l.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+	result, err := l.Create("", "http://foo.bar")
 	assert.NoError(err)
+	err = json.Unmarshal(result, &tResult)
+	assert.NoError(err)
+	assert.Equal("foo", tResult.ID)
+
+	result, err = l.Create("", "http://lorem.ipsum")
+	assert.NoError(err)
+	err = json.Unmarshal(result, &tResult)
+	assert.NoError(err)
+	assert.NotEqual("foo", tResult.ID)
+}
+
+// Test_CreateLegacyBackend tests creating entries on a backend without backend.EntryCreator
+func Test_CreateLegacyBackend(t *testing.T) {
+	assert := assert.New(t)
+	l := business.NewLogic(entriesOnly{backend.NewMemory()}, false, 5)
+	var tResult backend.TinyURL
+	assert.False(l.SupportsEntryOptions())
+
+	result, err := l.CreateWithOptions("foo", "http://foo.bar", business.EntryOptions{Caller: &business.Identity{Name: "foo"}})
+	assert.NoError(err)
+	err = json.Unmarshal(result, &tResult)
+	assert.NoError(err)
+	assert.Equal("http://foo.bar", tResult.URL)
+	assert.Empty(tResult.Owner, "The owner can not be recorded")
+
+	for _, opts := range []business.EntryOptions{
+		{TTL: "1h"},
+		{Redirect: "307"},
+		{Password: "secret"},
+	} {
+		_, err = l.CreateWithOptions("", "http://lorem.ipsum", opts)
+		assert.Equal(business.ErrOptionsUnsupported, err)
+	}
+	_, err = l.Get("lorem")
+	assert.Equal(business.ErrTinyURLNotFound, err)
+}
+
+func Test_ListPrefix(t *testing.T) {
+	assert := assert.New(t)
+	bolt, err := backend.NewBolt(getFilePath())
+	assert.NoError(err)
+	defer bolt.Close()
+
+	// The memory backend has no prefix scan and the bolt backend does
+	for _, b := range []backend.Backend{backend.NewMemory(), bolt} {
+		l := business.NewLogic(b, false, 5)
+		for _, id := range []string{"foo", "foobar", "bar"} {
+			_, err := l.Create(id, "http://"+id+".bar")
+			assert.NoError(err)
+		}
+
+		var tResult []backend.TinyURL
+		result, err := l.ListPrefix("foo")
+		assert.NoError(err)
+		err = json.Unmarshal(result, &tResult)
+		assert.NoError(err)
+		assert.Len(tResult, 2)
+		for _, entry := range tResult {
+			assert.Contains([]string{"foo", "foobar"}, entry.ID)
+		}
+
+		result, err = l.ListPrefix("none")
+		assert.NoError(err)
+		assert.Equal([]byte("[]"), result)
+	}
+}
+
+func Test_GetURL(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
 
 	id := "foo"
 	url := "http://foo.bar"
-	_, err = l.Create(id, url)
+	_, err := l.Create(id, url)
 	assert.NoError(err)
 
 	result, err := l.GetURL(id)
@@ -207,14 +287,13 @@ func Test_GetURL(t *testing.T) {
 
 func Test_Get(t *testing.T) {
 	assert := assert.New(t)
-	l, err := business.NewFileBackedLogic(getFilePath(), false, 5)
-	assert.NoError(err)
+	l := newMemoryLogic()
 	var tResult backend.TinyURL
 
 	id := "foo"
 	url := "http://foo.bar"
 
-	_, err = l.Create(id, url)
+	_, err := l.Create(id, url)
 	assert.NoError(err)
 	result, err := l.Get(id)
 	err = json.Unmarshal(result, &tResult)
@@ -226,15 +305,14 @@ func Test_Get(t *testing.T) {
 
 func Test_Update(t *testing.T) {
 	assert := assert.New(t)
-	l, err := business.NewFileBackedLogic(getFilePath(), false, 5)
-	assert.NoError(err)
+	l := newMemoryLogic()
 	var tResult backend.TinyURL
 
 	id := "foo"
 	url := "http://foo.bar"
 	url2 := "http://hello.world"
 
-	_, err = l.Create(id, url)
+	_, err := l.Create(id, url)
 	assert.NoError(err)
 
 	err = l.Update(id, url2)
@@ -251,14 +329,13 @@ func Test_Update(t *testing.T) {
 
 func Test_Delete(t *testing.T) {
 	assert := assert.New(t)
-	l, err := business.NewFileBackedLogic(getFilePath(), false, 5)
-	assert.NoError(err)
+	l := newMemoryLogic()
 	var tResult backend.TinyURL
 
 	id := "foo"
 	url := "http://foo.bar"
 
-	_, err = l.Create(id, url)
+	_, err := l.Create(id, url)
 	assert.NoError(err)
 
 	result, err := l.Get(id)
@@ -277,6 +354,82 @@ func Test_Delete(t *testing.T) {
 	assert.NoError(err)
 }
 
+// Test_ConcurrentCreateSameURL tests that concurrent requests for a generated ID
+// of the same URL all resolve to a single entry
+func Test_ConcurrentCreateSameURL(t *testing.T) {
+	assert := assert.New(t)
+	l, err := business.NewFileBackedLogic(getFilePath(), false, 5)
+	assert.NoError(err)
+
+	url := "http://foo.bar"
+	const workers = 16
+	ids := make([]string, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			var tResult backend.TinyURL
+			result, err := l.Create("", url)
+			assert.NoError(err)
+			assert.NoError(json.Unmarshal(result, &tResult))
+			ids[w] = tResult.ID
+		}(w)
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		assert.Equal(ids[0], id)
+	}
+	result, err := l.List()
+	assert.NoError(err)
+	var tResult []backend.TinyURL
+	assert.NoError(json.Unmarshal(result, &tResult))
+	assert.Len(tResult, 1)
+}
+
+// Test_ConcurrentAccess calls every logic method in parallel
+// Run with the race detector enabled to detect unsynchronized access
+func Test_ConcurrentAccess(t *testing.T) {
+	assert := assert.New(t)
+	l, err := business.NewFileBackedLogic(getFilePath(), false, 5)
+	assert.NoError(err)
+
+	const workers = 8
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			id := fmt.Sprintf("id%d", w)
+			_, err := l.Create(id, fmt.Sprintf("http://foo%d.bar", w))
+			assert.NoError(err)
+			_, err = l.Create("", fmt.Sprintf("http://generated%d.bar", w))
+			assert.NoError(err)
+			_, err = l.Get(id)
+			assert.NoError(err)
+			_, err = l.GetURL(id)
+			assert.NoError(err)
+			_, err = l.List()
+			assert.NoError(err)
+			assert.NoError(l.Update(id, fmt.Sprintf("http://updated%d.bar", w)))
+			assert.NoError(l.Delete(id))
+		}(w)
+	}
+	wg.Wait()
+
+	result, err := l.List()
+	assert.NoError(err)
+	var tResult []backend.TinyURL
+	assert.NoError(json.Unmarshal(result, &tResult))
+	assert.Len(tResult, workers)
+}
+
+// newMemoryLogic returns a logic instance on top of a memory backend
+func newMemoryLogic() *business.Logic {
+	return business.NewLogic(backend.NewMemory(), false, 5)
+}
+
 func getFilePath() string {
 	return path.Join(testDir, fmt.Sprintf("backend%s.json", utils.GenerateID(5)))
 }
@@ -0,0 +1,156 @@
+package business
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/chrisvdg/gotiny/backend"
+)
+
+// MigrateConflict represents an entry that was not migrated
+// because its ID points to a different URL in the target backend
+type MigrateConflict struct {
+	Source backend.TinyURL
+	Target backend.TinyURL
+}
+
+// MigrateTokenConflict represents a token that was not migrated
+// because its ID has a different hash in the target backend
+type MigrateTokenConflict struct {
+	Source backend.Token
+	Target backend.Token
+}
+
+// MigrateResult represents the outcome of a migration
+type MigrateResult struct {
+	// Migrated is the amount of entries created in the target backend
+	Migrated int
+	// Skipped is the amount of entries that already existed in the target backend
+	Skipped   int
+	Conflicts []MigrateConflict
+
+	// TokensMigrated is the amount of tokens created in the target backend
+	TokensMigrated int
+	// TokensSkipped is the amount of tokens that already existed in the target backend
+	TokensSkipped int
+	// TokensUnsupported is the amount of tokens not migrated because the target backend can not store tokens
+	TokensUnsupported int
+	TokenConflicts    []MigrateTokenConflict
+}
+
+// Migrate copies every entry of the from backend into the to backend,
+// keeping the creation time stamps of the entries
+// Source backends implementing backend.EntryWalker are read in batches instead of at once
+// Entries that already exist in the target backend are skipped,
+// entries of which the ID points to a different URL in the target backend are reported as conflicts
+// and left untouched
+// Tokens are migrated the same way when the source backend stores them,
+// they are counted as unsupported when the target backend can not store them
+// The target backend has to implement backend.EntryCreator to keep the time stamps and options of the entries
+// When dryRun is set, the result is reported without writing to the target backend
+func Migrate(from backend.Backend, to backend.Backend, dryRun bool) (MigrateResult, error) {
+	var result MigrateResult
+
+	creator, ok := to.(backend.EntryCreator)
+	if !ok {
+		return result, fmt.Errorf("the target backend can not create entries with their creation time stamp and options")
+	}
+
+	err := walkEntries(from, func(entry backend.TinyURL) error {
+		existing, err := to.Get(entry.ID)
+		if err == nil {
+			if existing.URL == entry.URL {
+				result.Skipped++
+			} else {
+				result.Conflicts = append(result.Conflicts, MigrateConflict{Source: entry, Target: existing})
+			}
+			return nil
+		}
+		if err != backend.ErrNotFound {
+			return fmt.Errorf("failed to look up %s in target backend: %s", entry.ID, err)
+		}
+
+		if !dryRun {
+			_, err = creator.CreateEntry(entry)
+			if err != nil {
+				return fmt.Errorf("failed to migrate %s: %s", entry.ID, err)
+			}
+		}
+		result.Migrated++
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	sort.Slice(result.Conflicts, func(i, j int) bool {
+		return result.Conflicts[i].Source.ID < result.Conflicts[j].Source.ID
+	})
+
+	return result, migrateTokens(from, to, dryRun, &result)
+}
+
+// walkEntries calls fn for every entry of the backend using the backend's walk if it has one
+// Other backends are listed at once and visited in ID order
+func walkEntries(b backend.Backend, fn func(backend.TinyURL) error) error {
+	if walker, ok := b.(backend.EntryWalker); ok {
+		return walker.Walk(fn)
+	}
+
+	entries, err := b.List()
+	if err != nil {
+		return fmt.Errorf("failed to list source entries: %s", err)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	for _, entry := range entries {
+		err = fn(entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateTokens copies the tokens of the from backend into the to backend, see Migrate
+func migrateTokens(from backend.Backend, to backend.Backend, dryRun bool, result *MigrateResult) error {
+	source, ok := from.(backend.TokenStore)
+	if !ok {
+		return nil
+	}
+	tokens, err := source.ListTokens()
+	if err != nil {
+		return fmt.Errorf("failed to list source tokens: %s", err)
+	}
+	target, ok := to.(backend.TokenStore)
+	if !ok {
+		result.TokensUnsupported = len(tokens)
+		return nil
+	}
+
+	for _, token := range tokens {
+		existing, err := target.GetToken(token.ID)
+		if err == nil {
+			if existing.Hash == token.Hash {
+				result.TokensSkipped++
+			} else {
+				result.TokenConflicts = append(result.TokenConflicts, MigrateTokenConflict{Source: token, Target: existing})
+			}
+			continue
+		}
+		if err != backend.ErrNotFound {
+			return fmt.Errorf("failed to look up token %s in target backend: %s", token.ID, err)
+		}
+
+		if !dryRun {
+			err = target.CreateToken(token)
+			if err != nil {
+				return fmt.Errorf("failed to migrate token %s: %s", token.ID, err)
+			}
+		}
+		result.TokensMigrated++
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package business_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/business"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Migrate(t *testing.T) {
+	assert := assert.New(t)
+	created := backend.JSONTime(time.Date(2019, time.March, 14, 15, 9, 26, 0, time.UTC))
+
+	from := backend.NewMemory()
+	to := backend.NewMemory()
+	for _, e := range []backend.TinyURL{
+		{ID: "foo", URL: "http://foo.bar", Created: created},
+		{ID: "lorem", URL: "http://lorem.ipsum", Created: created},
+		{ID: "same", URL: "http://same.url", Created: created},
+		{ID: "taken", URL: "http://source.url", Created: created},
+	} {
+		_, err := from.CreateEntry(e)
+		assert.NoError(err)
+	}
+	_, err := to.Create("same", "http://same.url")
+	assert.NoError(err)
+	target, err := to.Create("taken", "http://target.url")
+	assert.NoError(err)
+
+	res, err := business.Migrate(from, to, false)
+	assert.NoError(err)
+	assert.Equal(2, res.Migrated)
+	assert.Equal(1, res.Skipped)
+	if assert.Len(res.Conflicts, 1) {
+		assert.Equal("http://source.url", res.Conflicts[0].Source.URL)
+		assert.Equal(target, res.Conflicts[0].Target)
+	}
+
+	for _, id := range []string{"foo", "lorem"} {
+		entry, err := to.Get(id)
+		assert.NoError(err)
+		assert.Equal(created.Unix(), entry.Created.Unix(), "Migrated entries should keep their creation time stamp")
+	}
+	entry, err := to.Get("taken")
+	assert.NoError(err)
+	assert.Equal("http://target.url", entry.URL, "Conflicting entries should not be overwritten")
+
+	// Migrating again only skips
+	res, err = business.Migrate(from, to, false)
+	assert.NoError(err)
+	assert.Equal(0, res.Migrated)
+	assert.Equal(3, res.Skipped)
+	assert.Len(res.Conflicts, 1)
+}
+
+// Test_MigrateWalk tests migrating from a backend that walks its entries in batches
+func Test_MigrateWalk(t *testing.T) {
+	assert := assert.New(t)
+	from, err := backend.NewBolt(getFilePath())
+	assert.NoError(err)
+	defer from.Close()
+	to := backend.NewMemory()
+
+	ids := []string{}
+	for i := 0; i < 1234; i++ {
+		ids = append(ids, fmt.Sprintf("walk%d", i))
+	}
+	for _, id := range ids {
+		_, err := from.Create(id, "http://"+id+".bar")
+		assert.NoError(err)
+	}
+	for _, id := range []string{"walk2", "walk1"} {
+		_, err := to.Create(id, "http://other.url")
+		assert.NoError(err)
+	}
+
+	res, err := business.Migrate(from, to, false)
+	assert.NoError(err)
+	assert.Equal(len(ids)-2, res.Migrated)
+	if assert.Len(res.Conflicts, 2) {
+		assert.Equal("walk1", res.Conflicts[0].Source.ID, "Conflicts should be ordered by ID")
+		assert.Equal("walk2", res.Conflicts[1].Source.ID)
+	}
+	list, err := to.List()
+	assert.NoError(err)
+	assert.Len(list, len(ids))
+}
+
+func Test_MigrateDryRun(t *testing.T) {
+	assert := assert.New(t)
+
+	from := backend.NewMemory()
+	to := backend.NewMemory()
+	_, err := from.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+
+	res, err := business.Migrate(from, to, true)
+	assert.NoError(err)
+	assert.Equal(1, res.Migrated)
+
+	list, err := to.List()
+	assert.NoError(err)
+	assert.Len(list, 0, "A dry run should not write to the target backend")
+}
+
+func Test_MigrateLegacyTarget(t *testing.T) {
+	assert := assert.New(t)
+
+	from := backend.NewMemory()
+	to := entriesOnly{backend.NewMemory()}
+	_, err := from.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+
+	_, err = business.Migrate(from, to, false)
+	assert.Error(err, "A target that can not keep the creation time stamps should be refused")
+	list, err := to.List()
+	assert.NoError(err)
+	assert.Len(list, 0)
+}
+
+func Test_MigrateTokens(t *testing.T) {
+	assert := assert.New(t)
+	created := backend.JSONTime(time.Date(2019, time.March, 14, 15, 9, 26, 0, time.UTC))
+
+	from := backend.NewMemory()
+	to := backend.NewMemory()
+	for _, token := range []backend.Token{
+		{ID: "ci", Name: "CI", Hash: "hash1", Scopes: []string{"read"}, Created: created},
+		{ID: "same", Name: "Same", Hash: "hash2", Scopes: []string{"write"}, Created: created},
+		{ID: "taken", Name: "Taken", Hash: "hash3", Scopes: []string{"read"}, Created: created},
+	} {
+		assert.NoError(from.CreateToken(token))
+	}
+	assert.NoError(to.CreateToken(backend.Token{ID: "same", Hash: "hash2"}))
+	assert.NoError(to.CreateToken(backend.Token{ID: "taken", Hash: "other"}))
+
+	res, err := business.Migrate(from, to, true)
+	assert.NoError(err)
+	assert.Equal(1, res.TokensMigrated)
+	_, err = to.GetToken("ci")
+	assert.Equal(backend.ErrNotFound, err, "A dry run should not write tokens to the target backend")
+
+	res, err = business.Migrate(from, to, false)
+	assert.NoError(err)
+	assert.Equal(1, res.TokensMigrated)
+	assert.Equal(1, res.TokensSkipped)
+	if assert.Len(res.TokenConflicts, 1) {
+		assert.Equal("taken", res.TokenConflicts[0].Source.ID)
+		assert.Equal("other", res.TokenConflicts[0].Target.Hash)
+	}
+	token, err := to.GetToken("ci")
+	assert.NoError(err)
+	assert.Equal("hash1", token.Hash)
+	assert.Equal([]string{"read"}, token.Scopes)
+	assert.Equal(created.Unix(), token.Created.Unix())
+}
+
+func Test_MigrateTokensUnsupported(t *testing.T) {
+	assert := assert.New(t)
+
+	from := backend.NewMemory()
+	assert.NoError(from.CreateToken(backend.Token{ID: "ci", Hash: "hash"}))
+	m := backend.NewMemory()
+	to := withoutTokens{m, m}
+
+	res, err := business.Migrate(from, to, false)
+	assert.NoError(err)
+	assert.Equal(0, res.TokensMigrated)
+	assert.Equal(1, res.TokensUnsupported, "Tokens should be reported when the target backend can not store them")
+}
+
+// withoutTokens hides the backend.TokenStore implementation of the wrapped backend
+type withoutTokens struct {
+	backend.Backend
+	backend.EntryCreator
+}
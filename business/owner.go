@@ -0,0 +1,59 @@
+package business
+
+import "github.com/chrisvdg/gotiny/backend"
+
+// PublicOwner is the owner of entries created without a token
+const PublicOwner = "public"
+
+// tokenOwnerPrefix prefixes the token ID in the owner of entries created with a stored token
+const tokenOwnerPrefix = "token:"
+
+// Owner returns the owner recorded on entries created by the identity
+// Stored tokens are identified by their ID, tokens set at startup by their name
+// and identities without a token by PublicOwner
+func (i Identity) Owner() string {
+	if i.TokenID != "" {
+		return tokenOwnerPrefix + i.TokenID
+	}
+	if i.Name != "" {
+		return i.Name
+	}
+
+	return PublicOwner
+}
+
+// SetEnforceOwnership restricts updating and deleting entries to their owner
+// Identities with the admin scope can access every entry,
+// identities with the read scope list every entry while others only list their own
+// It should be called before the logic instance is used
+func (l *Logic) SetEnforceOwnership(enforce bool) {
+	l.enforceOwners = enforce
+}
+
+// ownerOf returns the owner recorded on entries created by the caller
+func ownerOf(caller *Identity) string {
+	if caller == nil {
+		return ""
+	}
+
+	return caller.Owner()
+}
+
+// canAccess returns true if the caller may update or delete the entry
+// A nil caller is not restricted
+func (l *Logic) canAccess(caller *Identity, entry backend.TinyURL) bool {
+	if !l.enforceOwners || caller == nil || caller.HasScope(ScopeAdmin) {
+		return true
+	}
+
+	return entry.Owner == caller.Owner()
+}
+
+// canList returns true if the caller may list the entry
+func (l *Logic) canList(caller *Identity, entry backend.TinyURL) bool {
+	if caller != nil && caller.HasScope(ScopeRead) {
+		return true
+	}
+
+	return l.canAccess(caller, entry)
+}
@@ -0,0 +1,105 @@
+package business_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/business"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_IdentityOwner(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("token:abc", business.Identity{TokenID: "abc", Name: "ci"}.Owner())
+	assert.Equal("write", business.Identity{Name: "write"}.Owner())
+	assert.Equal(business.PublicOwner, business.Identity{}.Owner())
+}
+
+func Test_CreateRecordsOwner(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	data, err := l.CreateWithOptions("foo", "http://foo.bar", business.EntryOptions{Caller: &business.Identity{TokenID: "abc"}})
+	assert.NoError(err)
+	var entry backend.TinyURL
+	assert.NoError(json.Unmarshal(data, &entry))
+	assert.Equal("token:abc", entry.Owner)
+
+	data, err = l.CreateWithOptions("bar", "http://foo.bar", business.EntryOptions{Caller: &business.Identity{}})
+	assert.NoError(err)
+	assert.NoError(json.Unmarshal(data, &entry))
+	assert.Equal(business.PublicOwner, entry.Owner)
+}
+
+func Test_OwnershipEnforced(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+	l.SetEnforceOwnership(true)
+	alice := &business.Identity{TokenID: "alice", Scopes: []string{business.ScopeUpdate, business.ScopeDelete}}
+	bob := &business.Identity{TokenID: "bob", Scopes: []string{business.ScopeUpdate, business.ScopeDelete}}
+	admin := &business.Identity{Name: "admin", Scopes: []string{business.ScopeAdmin}}
+	reader := &business.Identity{Name: "read", Scopes: []string{business.ScopeRead}}
+
+	_, err := l.CreateWithOptions("foo", "http://foo.bar", business.EntryOptions{Caller: alice})
+	assert.NoError(err)
+	_, err = l.CreateWithOptions("bar", "http://bar.foo", business.EntryOptions{Caller: bob})
+	assert.NoError(err)
+
+	data, err := l.ListPrefixAs(alice, "")
+	assert.NoError(err)
+	var list []backend.TinyURL
+	assert.NoError(json.Unmarshal(data, &list))
+	if assert.Len(list, 1) {
+		assert.Equal("foo", list[0].ID)
+	}
+	data, err = l.ListPrefixAs(admin, "")
+	assert.NoError(err)
+	assert.NoError(json.Unmarshal(data, &list))
+	assert.Len(list, 2)
+	data, err = l.ListPrefixAs(reader, "")
+	assert.NoError(err)
+	assert.NoError(json.Unmarshal(data, &list))
+	assert.Len(list, 2, "The read scope should list every entry")
+
+	err = l.UpdateWithOptions("foo", "http://other.bar", business.EntryOptions{Caller: bob})
+	assert.Equal(business.ErrNotOwner, err)
+	assert.Equal(business.ErrNotOwner, l.DeleteAs(bob, "foo"))
+
+	assert.NoError(l.UpdateWithOptions("foo", "http://other.bar", business.EntryOptions{Caller: alice}))
+	assert.NoError(l.UpdateWithOptions("foo", "http://admin.bar", business.EntryOptions{Caller: admin}))
+	data, err = l.Get("foo")
+	assert.NoError(err)
+	var entry backend.TinyURL
+	assert.NoError(json.Unmarshal(data, &entry))
+	assert.Equal("http://admin.bar", entry.URL)
+	assert.Equal("token:alice", entry.Owner, "Owner should not change on update")
+
+	assert.NoError(l.DeleteAs(admin, "bar"))
+	_, err = l.Get("bar")
+	assert.Equal(business.ErrTinyURLNotFound, err)
+}
+
+func Test_OwnershipNotEnforced(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+	bob := &business.Identity{Name: "write", Scopes: []string{business.ScopeUpdate, business.ScopeDelete}}
+
+	_, err := l.CreateWithOptions("foo", "http://foo.bar", business.EntryOptions{Caller: &business.Identity{}})
+	assert.NoError(err)
+	assert.NoError(l.UpdateWithOptions("foo", "http://other.bar", business.EntryOptions{Caller: bob}))
+	assert.NoError(l.DeleteAs(bob, "foo"))
+}
+
+func Test_CreateNotSharedBetweenOwners(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+	l.SetEnforceOwnership(true)
+
+	first, err := l.CreateWithOptions("", "http://foo.bar", business.EntryOptions{Caller: &business.Identity{TokenID: "alice"}})
+	assert.NoError(err)
+	second, err := l.CreateWithOptions("", "http://foo.bar", business.EntryOptions{Caller: &business.Identity{TokenID: "bob"}})
+	assert.NoError(err)
+	assert.NotEqual(first, second)
+}
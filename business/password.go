@@ -0,0 +1,189 @@
+package business
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chrisvdg/gotiny/backend"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UnlockAttempts is the amount of failed unlock attempts allowed per entry and client within UnlockWindow
+const UnlockAttempts = 5
+
+// UnlockWindow is the period after the first failed unlock attempt in which failed attempts are counted
+const UnlockWindow = 15 * time.Minute
+
+// maxPasswordLen is the maximum password length bcrypt supports
+const maxPasswordLen = 72
+
+// TooManyAttemptsError represents an error where an entry is unlocked too often with a wrong password
+type TooManyAttemptsError struct {
+	// RetryAfter is the time after which unlocking is allowed again
+	RetryAfter time.Duration
+}
+
+// Error implements error.Error
+func (e *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("too many failed attempts, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// hashPassword returns the hash of a password to store in an entry
+func hashPassword(password string) (string, error) {
+	if len(password) > maxPasswordLen {
+		return "", ErrInvalidPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %s", err)
+	}
+
+	return string(hash), nil
+}
+
+// Unlock returns the URL of a password protected entry when the password matches
+// Failed attempts are limited per entry and client,
+// a *TooManyAttemptsError is returned while the limit is exceeded, even for the right password
+// returns ErrWrongPassword when the password does not match
+func (l *Logic) Unlock(id string, password string, client string) (string, error) {
+	entry, err := l.getUnexpired(id)
+	if err != nil {
+		return "", err
+	}
+	if entry.PasswordHash == "" {
+		return entry.URL, nil
+	}
+
+	key := id + "\x00" + client
+	now := time.Now()
+	if wait := l.unlockLimiter.wait(key, now); wait > 0 {
+		return "", &TooManyAttemptsError{RetryAfter: wait}
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(entry.PasswordHash), []byte(password))
+	if err != nil {
+		if err != bcrypt.ErrMismatchedHashAndPassword {
+			log.Errorf("Failed to check password of %s: %s", id, err)
+		}
+		l.unlockLimiter.fail(key, now)
+		return "", ErrWrongPassword
+	}
+	l.unlockLimiter.reset(key)
+
+	return entry.URL, nil
+}
+
+// passwordOption returns the password hash set by the options and whether the options change it
+func passwordOption(opts EntryOptions) (string, bool, error) {
+	if opts.RemovePassword {
+		if opts.Password != "" {
+			return "", false, ErrInvalidPassword
+		}
+		return "", true, nil
+	}
+	if opts.Password == "" {
+		return "", false, nil
+	}
+	hash, err := hashPassword(opts.Password)
+	if err != nil {
+		return "", false, err
+	}
+
+	return hash, true, nil
+}
+
+// protected returns true if following the entry requires a password
+func protected(entry backend.TinyURL) bool {
+	return entry.PasswordHash != ""
+}
+
+// withoutProtectedURL returns the entry without its URL when it is password protected,
+// so only Unlock reveals the URL
+func withoutProtectedURL(entry backend.TinyURL) backend.TinyURL {
+	if protected(entry) {
+		entry.URL = ""
+	}
+
+	return entry
+}
+
+// newAttemptLimiter returns a new limiter that allows max failed attempts per key within window
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		max:      max,
+		window:   window,
+		attempts: map[string]*attempts{},
+	}
+}
+
+// attemptLimiter counts failed attempts per key
+// It is safe for concurrent use
+type attemptLimiter struct {
+	max    int
+	window time.Duration
+
+	mu        sync.Mutex
+	attempts  map[string]*attempts
+	lastPrune time.Time
+}
+
+// attempts represents the failed attempts of a key since start
+type attempts struct {
+	start time.Time
+	count int
+}
+
+// wait returns how long the key has to wait before attempting again, 0 when it is allowed now
+func (a *attemptLimiter) wait(key string, now time.Time) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	at, ok := a.attempts[key]
+	if !ok || at.count < a.max {
+		return 0
+	}
+	end := at.start.Add(a.window)
+	if !now.Before(end) {
+		delete(a.attempts, key)
+		return 0
+	}
+
+	return end.Sub(now)
+}
+
+// fail counts a failed attempt of the key
+func (a *attemptLimiter) fail(key string, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.prune(now)
+	at, ok := a.attempts[key]
+	if !ok || !now.Before(at.start.Add(a.window)) {
+		at = &attempts{start: now}
+		a.attempts[key] = at
+	}
+	at.count++
+}
+
+// reset forgets the failed attempts of the key
+func (a *attemptLimiter) reset(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.attempts, key)
+}
+
+// prune removes the attempts of which the window passed, at most once per window
+// the caller is expected to hold the lock
+func (a *attemptLimiter) prune(now time.Time) {
+	if now.Sub(a.lastPrune) < a.window {
+		return
+	}
+	a.lastPrune = now
+	for key, at := range a.attempts {
+		if !now.Before(at.start.Add(a.window)) {
+			delete(a.attempts, key)
+		}
+	}
+}
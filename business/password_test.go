@@ -0,0 +1,121 @@
+package business_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chrisvdg/gotiny/business"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CreateWithPassword(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	result, err := l.CreateWithOptions("foo", "http://foo.bar", business.EntryOptions{Password: "secret"})
+	assert.NoError(err)
+	assert.NotContains(string(result), "password")
+	assert.NotContains(string(result), "$2a$")
+
+	_, _, err = l.GetRedirect("foo")
+	assert.Equal(business.ErrPasswordRequired, err)
+	_, err = l.GetURL("foo")
+	assert.Equal(business.ErrPasswordRequired, err)
+
+	result, err = l.Get("foo")
+	assert.NoError(err)
+	assert.NotContains(string(result), "$2a$", "Expanding should not reveal the password hash")
+	assert.NotContains(string(result), "http://foo.bar", "Expanding should not reveal the URL")
+	result, err = l.List()
+	assert.NoError(err)
+	assert.NotContains(string(result), "$2a$", "Listing should not reveal the password hash")
+	assert.NotContains(string(result), "http://foo.bar", "Listing should not reveal the URL")
+
+	_, err = l.CreateWithOptions("bar", "http://foo.bar", business.EntryOptions{Password: strings.Repeat("x", 73)})
+	assert.Equal(business.ErrInvalidPassword, err)
+}
+
+func Test_CreateWithPasswordNotShared(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	open, err := l.Create("", "http://foo.bar")
+	assert.NoError(err)
+	protected, err := l.CreateWithOptions("", "http://foo.bar", business.EntryOptions{Password: "secret"})
+	assert.NoError(err)
+	assert.NotEqual(open, protected)
+	again, err := l.Create("", "http://foo.bar")
+	assert.NoError(err)
+	assert.Equal(open, again)
+}
+
+func Test_Unlock(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	_, err := l.CreateWithOptions("foo", "http://foo.bar", business.EntryOptions{Password: "secret"})
+	assert.NoError(err)
+
+	_, err = l.Unlock("foo", "wrong", "1.2.3.4")
+	assert.Equal(business.ErrWrongPassword, err)
+	url, err := l.Unlock("foo", "secret", "1.2.3.4")
+	assert.NoError(err)
+	assert.Equal("http://foo.bar", url)
+
+	_, err = l.Unlock("bar", "secret", "1.2.3.4")
+	assert.Equal(business.ErrTinyURLNotFound, err)
+}
+
+func Test_UnlockAttemptsLimited(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	_, err := l.CreateWithOptions("foo", "http://foo.bar", business.EntryOptions{Password: "secret"})
+	assert.NoError(err)
+
+	for i := 0; i < business.UnlockAttempts; i++ {
+		_, err = l.Unlock("foo", "wrong", "1.2.3.4")
+		assert.Equal(business.ErrWrongPassword, err)
+	}
+	_, err = l.Unlock("foo", "secret", "1.2.3.4")
+	if assert.IsType(&business.TooManyAttemptsError{}, err) {
+		retryAfter := err.(*business.TooManyAttemptsError).RetryAfter
+		assert.True(retryAfter > 0 && retryAfter <= business.UnlockWindow)
+	}
+
+	// Other clients are not limited
+	url, err := l.Unlock("foo", "secret", "5.6.7.8")
+	assert.NoError(err)
+	assert.Equal("http://foo.bar", url)
+}
+
+func Test_UpdatePassword(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	_, err := l.CreateWithOptions("foo", "http://foo.bar", business.EntryOptions{Password: "secret"})
+	assert.NoError(err)
+
+	// An empty password keeps the current one
+	err = l.UpdateWithOptions("foo", "http://bar.foo", business.EntryOptions{})
+	assert.NoError(err)
+	url, err := l.Unlock("foo", "secret", "1.2.3.4")
+	assert.NoError(err)
+	assert.Equal("http://bar.foo", url)
+
+	err = l.UpdateWithOptions("foo", "http://bar.foo", business.EntryOptions{Password: "other"})
+	assert.NoError(err)
+	_, err = l.Unlock("foo", "secret", "1.2.3.4")
+	assert.Equal(business.ErrWrongPassword, err)
+	_, err = l.Unlock("foo", "other", "1.2.3.4")
+	assert.NoError(err)
+
+	err = l.UpdateWithOptions("foo", "http://bar.foo", business.EntryOptions{Password: "other", RemovePassword: true})
+	assert.Equal(business.ErrInvalidPassword, err)
+
+	err = l.UpdateWithOptions("foo", "http://bar.foo", business.EntryOptions{RemovePassword: true})
+	assert.NoError(err)
+	url, err = l.GetURL("foo")
+	assert.NoError(err)
+	assert.Equal("http://bar.foo", url)
+}
@@ -0,0 +1,86 @@
+package business_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/business"
+	"github.com/chrisvdg/gotiny/policy"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CreateAndUpdateWithPolicy(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+	p, err := policy.Parse([]byte(`{"urls": {"deny": {"domains": ["localhost"], "networks": ["10.0.0.0/8"]}}}`))
+	assert.NoError(err)
+	l.SetPolicy(p)
+
+	_, err = l.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+	for _, url := range []string{"http://localhost:8080", "http://10.0.0.1/admin"} {
+		_, err = l.Create("", url)
+		assert.Equal(business.ErrURLNotAllowed, err, url)
+		assert.Equal(business.ErrURLNotAllowed, l.Update("foo", url), url)
+	}
+	assert.True(business.IsValidationError(business.ErrURLNotAllowed))
+}
+
+func Test_CreateReservedID(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	for _, id := range []string{"api", "admin", "sh1t"} {
+		_, err := l.Create(id, "http://foo.bar")
+		assert.Equal(business.ErrIDNotAllowed, err, id)
+	}
+	p, err := policy.Parse([]byte(`{"ids": {"reserved": ["foo"]}}`))
+	assert.NoError(err)
+	l.SetPolicy(p)
+	_, err = l.Create("foo", "http://foo.bar")
+	assert.Equal(business.ErrIDNotAllowed, err)
+	_, err = l.Create("api", "http://foo.bar")
+	assert.NoError(err)
+}
+
+// lowerOnly only allows lower case IDs to test that generated IDs are checked
+type lowerOnly struct {
+	*policy.Policy
+}
+
+func (lowerOnly) CheckID(id string) error {
+	if strings.ToLower(id) != id || strings.ContainsAny(id, "0123456789-_") {
+		return business.ErrIDNotAllowed
+	}
+	return nil
+}
+
+func Test_CreateGeneratedIDChecked(t *testing.T) {
+	assert := assert.New(t)
+	l := business.NewLogic(backend.NewMemory(), false, 1)
+	l.SetPolicy(lowerOnly{policy.Default()})
+
+	for i := 0; i < 5; i++ {
+		data, err := l.Create("", fmt.Sprintf("http://foo%d.bar", i))
+		assert.NoError(err)
+		var entry backend.TinyURL
+		assert.NoError(json.Unmarshal(data, &entry))
+		assert.NoError(lowerOnly{}.CheckID(entry.ID), entry.ID)
+	}
+}
+
+func Test_CreateGeneratedIDExhausted(t *testing.T) {
+	assert := assert.New(t)
+	l := business.NewLogic(backend.NewMemory(), false, 1)
+
+	charset := "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	for _, c := range charset {
+		_, err := l.Create(string(c), "http://foo.bar/"+string(c))
+		assert.NoError(err)
+	}
+	_, err := l.Create("", "http://bar.foo")
+	assert.Error(err, "Should fail instead of retrying forever when every ID is in use")
+}
@@ -0,0 +1,68 @@
+package business
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// DefaultRedirect is the redirect status code of entries without one when no other default is set
+// A temporary redirect is not cached by browsers, so updates of an entry reach every user
+const DefaultRedirect = http.StatusFound
+
+// redirectCodes contains the supported redirect status codes
+var redirectCodes = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+}
+
+// ValidRedirect returns true if code is a supported redirect status code (301, 302, 307 or 308)
+func ValidRedirect(code int) bool {
+	return redirectCodes[code]
+}
+
+// parseRedirect returns the redirect status code of an entry option
+// 0 is returned for an empty value or "0", which selects the server default
+func parseRedirect(redirect string) (int, error) {
+	if redirect == "" {
+		return 0, nil
+	}
+	code, err := strconv.Atoi(redirect)
+	if err != nil || (code != 0 && !ValidRedirect(code)) {
+		return 0, ErrInvalidRedirect
+	}
+
+	return code, nil
+}
+
+// SetDefaultRedirect sets the redirect status code of entries without one
+// It should be called before the logic instance is used
+func (l *Logic) SetDefaultRedirect(code int) error {
+	if !ValidRedirect(code) {
+		return ErrInvalidRedirect
+	}
+	l.defaultRedirect = code
+
+	return nil
+}
+
+// GetRedirect returns the URL and redirect status code for the given ID
+// returns ErrTinyURLExpired when the entry expired
+// and ErrPasswordRequired when the entry is password protected, see Unlock
+func (l *Logic) GetRedirect(id string) (string, int, error) {
+	entry, err := l.getUnexpired(id)
+	if err != nil {
+		return "", 0, err
+	}
+	if protected(entry) {
+		return "", 0, ErrPasswordRequired
+	}
+
+	code := entry.Redirect
+	if code == 0 {
+		code = l.defaultRedirect
+	}
+
+	return entry.URL, code, nil
+}
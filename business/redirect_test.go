@@ -0,0 +1,87 @@
+package business_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/chrisvdg/gotiny/business"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_GetRedirectDefault(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	_, err := l.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+
+	url, code, err := l.GetRedirect("foo")
+	assert.NoError(err)
+	assert.Equal("http://foo.bar", url)
+	assert.Equal(http.StatusFound, code)
+
+	assert.NoError(l.SetDefaultRedirect(http.StatusPermanentRedirect))
+	_, code, err = l.GetRedirect("foo")
+	assert.NoError(err)
+	assert.Equal(http.StatusPermanentRedirect, code)
+
+	assert.Equal(business.ErrInvalidRedirect, l.SetDefaultRedirect(http.StatusOK))
+}
+
+func Test_CreateWithRedirect(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	_, err := l.CreateWithOptions("foo", "http://foo.bar", business.EntryOptions{Redirect: "301"})
+	assert.NoError(err)
+	_, code, err := l.GetRedirect("foo")
+	assert.NoError(err)
+	assert.Equal(http.StatusMovedPermanently, code)
+
+	for _, redirect := range []string{"200", "303", "foo"} {
+		_, err = l.CreateWithOptions("", "http://foo.bar", business.EntryOptions{Redirect: redirect})
+		assert.Equal(business.ErrInvalidRedirect, err, redirect)
+	}
+}
+
+func Test_CreateWithRedirectNotShared(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	first, err := l.CreateWithOptions("", "http://foo.bar", business.EntryOptions{})
+	assert.NoError(err)
+	second, err := l.CreateWithOptions("", "http://foo.bar", business.EntryOptions{Redirect: "307"})
+	assert.NoError(err)
+	assert.NotEqual(first, second)
+}
+
+func Test_UpdateRedirect(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	_, err := l.CreateWithOptions("foo", "http://foo.bar", business.EntryOptions{Redirect: "307"})
+	assert.NoError(err)
+
+	// An empty redirect keeps the current one
+	err = l.UpdateWithOptions("foo", "http://bar.foo", business.EntryOptions{})
+	assert.NoError(err)
+	_, code, err := l.GetRedirect("foo")
+	assert.NoError(err)
+	assert.Equal(http.StatusTemporaryRedirect, code)
+
+	err = l.UpdateWithOptions("foo", "http://bar.foo", business.EntryOptions{Redirect: "308"})
+	assert.NoError(err)
+	_, code, err = l.GetRedirect("foo")
+	assert.NoError(err)
+	assert.Equal(http.StatusPermanentRedirect, code)
+
+	// 0 resets to the server default
+	err = l.UpdateWithOptions("foo", "http://bar.foo", business.EntryOptions{Redirect: "0"})
+	assert.NoError(err)
+	_, code, err = l.GetRedirect("foo")
+	assert.NoError(err)
+	assert.Equal(business.DefaultRedirect, code)
+
+	err = l.UpdateWithOptions("foo", "http://bar.foo", business.EntryOptions{Redirect: "200"})
+	assert.Equal(business.ErrInvalidRedirect, err)
+}
@@ -0,0 +1,57 @@
+package business_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/chrisvdg/gotiny/analytics"
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/business"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Stats(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+	store := analytics.NewMemoryStore()
+	l.EnableAnalytics(store, analytics.RecorderOptions{FlushInterval: 10 * time.Millisecond})
+	defer l.Close()
+
+	_, err := l.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+	l.RecordHit(analytics.Hit{ID: "foo", Time: time.Now(), UserAgent: browserUA, UAClass: analytics.UAClassDesktop, ClientIP: "192.0.2.1"})
+	l.RecordHit(analytics.Hit{ID: "foo", Time: time.Now(), UserAgent: browserUA, UAClass: analytics.UAClassMobile, ClientIP: "192.0.2.2"})
+
+	var stats analytics.Stats
+	assert.Eventually(func() bool {
+		data, err := l.Stats("foo")
+		return err == nil && json.Unmarshal(data, &stats) == nil && stats.Total == 2
+	}, time.Second, 10*time.Millisecond)
+	if assert.Len(stats.Days, 1) {
+		assert.Equal(2, stats.Days[0].Visitors)
+	}
+
+	_, err = l.Stats("missing")
+	assert.Equal(backend.ErrNotFound, err)
+
+	// Statistics are removed along with the entry
+	assert.NoError(l.Delete("foo"))
+	s, err := store.Stats("foo")
+	assert.NoError(err)
+	assert.Equal(0, s.Total)
+}
+
+// browserUA is the user agent of a desktop browser
+const browserUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:76.0) Gecko/20100101 Firefox/76.0"
+
+func Test_StatsDisabled(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	_, err := l.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+	l.RecordHit(analytics.Hit{ID: "foo", Time: time.Now(), UserAgent: browserUA})
+	_, err = l.Stats("foo")
+	assert.Equal(business.ErrAnalyticsDisabled, err)
+}
@@ -0,0 +1,299 @@
+package business
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// Token scopes
+const (
+	ScopeRead            = "read"
+	ScopeCreateGenerated = "create-generated"
+	ScopeCreateCustom    = "create-custom"
+	ScopeUpdate          = "update"
+	ScopeDelete          = "delete"
+	// ScopeAdmin grants every other scope and managing tokens
+	ScopeAdmin = "admin"
+)
+
+// Scopes contains the supported token scopes
+var Scopes = []string{ScopeRead, ScopeCreateGenerated, ScopeCreateCustom, ScopeUpdate, ScopeDelete, ScopeAdmin}
+
+// tokenIDLen is the length of generated token IDs
+const tokenIDLen = 8
+
+// tokenSecretLen is the amount of random bytes in a token secret
+const tokenSecretLen = 32
+
+// tokenSep separates the token ID from the secret in a token
+const tokenSep = "."
+
+// maxTokenNameLen is the maximum length of a token name
+const maxTokenNameLen = 100
+
+// Identity represents the holder of an authenticated token
+type Identity struct {
+	// TokenID is the ID of the stored token, empty for tokens set at startup
+	TokenID string
+	Name    string
+	Scopes  []string
+}
+
+// HasScope returns true if the identity was granted the scope, the admin scope grants every scope
+func (i Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tokenInfo represents the API representation of a stored token
+// Token is only set in the response of CreateToken, it can not be retrieved afterwards
+type tokenInfo struct {
+	ID      string           `json:"id"`
+	Name    string           `json:"name"`
+	Scopes  []string         `json:"scopes"`
+	Created backend.JSONTime `json:"created"`
+	Token   string           `json:"token,omitempty"`
+}
+
+// CreateToken stores a new token with the provided name and scopes in the backend
+// and returns the json encoded token, the only time the token itself is returned
+func (l *Logic) CreateToken(name string, scopes []string) ([]byte, error) {
+	store, err := l.tokenStore()
+	if err != nil {
+		return nil, err
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxTokenNameLen {
+		return nil, ErrInvalidTokenName
+	}
+	scopes, err = normalizeScopes(scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, tokenSecretLen)
+	_, err = rand.Read(secret)
+	if err != nil {
+		log.Error(err)
+		return nil, fmt.Errorf("Failed to create token")
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+
+	var token backend.Token
+	for {
+		token = backend.Token{
+			ID:      utils.GenerateID(tokenIDLen),
+			Name:    name,
+			Hash:    hashTokenSecret(encoded),
+			Scopes:  scopes,
+			Created: backend.JSONTime(time.Unix(time.Now().Unix(), 0)),
+		}
+		err = store.CreateToken(token)
+		if err == backend.ErrIDInUse {
+			continue
+		}
+		if err != nil {
+			log.Error(err)
+			return nil, fmt.Errorf("Failed to create token")
+		}
+		break
+	}
+
+	info := newTokenInfo(token)
+	info.Token = token.ID + tokenSep + encoded
+	return l.formatJSON(info)
+}
+
+// ListTokens returns the json encoded list of stored tokens, without the tokens themselves
+func (l *Logic) ListTokens() ([]byte, error) {
+	store, err := l.tokenStore()
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := store.ListTokens()
+	if err != nil {
+		log.Error(err)
+		return nil, fmt.Errorf("Failed to list tokens")
+	}
+
+	result := []tokenInfo{}
+	for _, t := range tokens {
+		result = append(result, newTokenInfo(t))
+	}
+	return l.formatJSON(result)
+}
+
+// RevokeToken removes a stored token so it can no longer be used
+// returns ErrTokenNotFound when there is no token with the provided ID
+func (l *Logic) RevokeToken(id string) error {
+	store, err := l.tokenStore()
+	if err != nil {
+		return err
+	}
+	err = store.RemoveToken(id)
+	if err == backend.ErrNotFound {
+		return ErrTokenNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return fmt.Errorf("Failed to revoke token")
+	}
+
+	return nil
+}
+
+// AuthenticateToken returns the identity of a stored token
+// returns ErrInvalidToken when the token is not a stored token
+func (l *Logic) AuthenticateToken(token string) (Identity, error) {
+	store, ok := l.backend.(backend.TokenStore)
+	if !ok {
+		return Identity{}, ErrInvalidToken
+	}
+	sep := strings.Index(token, tokenSep)
+	if sep <= 0 {
+		return Identity{}, ErrInvalidToken
+	}
+
+	stored, err := store.GetToken(token[:sep])
+	if err != nil {
+		if err != backend.ErrNotFound {
+			log.Errorf("Failed to get token: %s", err)
+		}
+		return Identity{}, ErrInvalidToken
+	}
+	hash := hashTokenSecret(token[sep+len(tokenSep):])
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(stored.Hash)) != 1 {
+		return Identity{}, ErrInvalidToken
+	}
+
+	return Identity{
+		TokenID: stored.ID,
+		Name:    stored.Name,
+		Scopes:  stored.Scopes,
+	}, nil
+}
+
+// IsStoredToken returns true if the token has the format of tokens created by CreateToken,
+// an ID and a base64url encoded secret
+func IsStoredToken(token string) bool {
+	secretLen := base64.RawURLEncoding.EncodedLen(tokenSecretLen)
+	if len(token) != tokenIDLen+len(tokenSep)+secretLen || token[tokenIDLen:tokenIDLen+len(tokenSep)] != tokenSep {
+		return false
+	}
+	for _, part := range []string{token[:tokenIDLen], token[tokenIDLen+len(tokenSep):]} {
+		for _, c := range part {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// SupportsTokens returns true if the backend can store tokens
+func (l *Logic) SupportsTokens() bool {
+	_, ok := l.backend.(backend.TokenStore)
+	return ok
+}
+
+// tokenStore returns the backend as token store or ErrTokensUnsupported
+func (l *Logic) tokenStore() (backend.TokenStore, error) {
+	store, ok := l.backend.(backend.TokenStore)
+	if !ok {
+		return nil, ErrTokensUnsupported
+	}
+
+	return store, nil
+}
+
+// formatJSON returns the json encoding of v
+func (l *Logic) formatJSON(v interface{}) ([]byte, error) {
+	var data []byte
+	var err error
+	if l.prettyJSON {
+		data, err = json.MarshalIndent(v, "", "\t")
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		log.Error(err)
+		return nil, fmt.Errorf("Failed to encode response")
+	}
+
+	return data, nil
+}
+
+// newTokenInfo returns the API representation of a stored token
+func newTokenInfo(t backend.Token) tokenInfo {
+	return tokenInfo{
+		ID:      t.ID,
+		Name:    t.Name,
+		Scopes:  t.Scopes,
+		Created: t.Created,
+	}
+}
+
+// normalizeScopes returns the sorted unique scopes or ErrInvalidScope when one is not supported
+// Comma separated scopes are split
+func normalizeScopes(scopes []string) ([]string, error) {
+	set := map[string]bool{}
+	for _, s := range scopes {
+		for _, scope := range strings.Split(s, ",") {
+			scope = strings.ToLower(strings.TrimSpace(scope))
+			if scope == "" {
+				continue
+			}
+			if !validScope(scope) {
+				return nil, ErrInvalidScope
+			}
+			set[scope] = true
+		}
+	}
+	if len(set) == 0 {
+		return nil, ErrInvalidScope
+	}
+
+	result := []string{}
+	for scope := range set {
+		result = append(result, scope)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// validScope returns true if the scope is supported
+func validScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hashTokenSecret returns the stored hash of a token secret
+// Secrets are random, so a fast hash is sufficient and keeps authenticating cheap
+func hashTokenSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,131 @@
+package business_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/business"
+	"github.com/stretchr/testify/assert"
+)
+
+// createdToken represents the response of CreateToken
+type createdToken struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Token  string   `json:"token"`
+}
+
+func Test_CreateToken(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	data, err := l.CreateToken("ci", []string{"update,read", "read"})
+	assert.NoError(err)
+	var token createdToken
+	assert.NoError(json.Unmarshal(data, &token))
+	assert.Equal("ci", token.Name)
+	assert.Equal([]string{business.ScopeRead, business.ScopeUpdate}, token.Scopes)
+	assert.True(strings.HasPrefix(token.Token, token.ID+"."))
+	assert.True(business.IsStoredToken(token.Token))
+
+	identity, err := l.AuthenticateToken(token.Token)
+	assert.NoError(err)
+	assert.Equal(token.ID, identity.TokenID)
+	assert.True(identity.HasScope(business.ScopeRead))
+	assert.True(identity.HasScope(business.ScopeUpdate))
+	assert.False(identity.HasScope(business.ScopeDelete))
+
+	_, err = l.CreateToken("ci", []string{"everything"})
+	assert.Equal(business.ErrInvalidScope, err)
+	_, err = l.CreateToken("ci", nil)
+	assert.Equal(business.ErrInvalidScope, err)
+	_, err = l.CreateToken(" ", []string{business.ScopeRead})
+	assert.Equal(business.ErrInvalidTokenName, err)
+}
+
+func Test_AuthenticateInvalidToken(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	data, err := l.CreateToken("ci", []string{business.ScopeRead})
+	assert.NoError(err)
+	var token createdToken
+	assert.NoError(json.Unmarshal(data, &token))
+
+	for _, value := range []string{"", token.ID, token.ID + ".", token.ID + ".wrong", "other." + strings.SplitN(token.Token, ".", 2)[1]} {
+		_, err = l.AuthenticateToken(value)
+		assert.Equal(business.ErrInvalidToken, err, value)
+	}
+}
+
+func Test_IsStoredToken(t *testing.T) {
+	assert := assert.New(t)
+	secret := strings.Repeat("a", 42) + "_"
+
+	assert.True(business.IsStoredToken("AbCd-_12." + secret))
+	for _, value := range []string{"", "secret", "AbCd-_12", "AbCd-_12." + secret[1:], "AbCd-_1." + secret + "a", "AbCd-_12:" + secret, "AbCd-_1?." + secret} {
+		assert.False(business.IsStoredToken(value), value)
+	}
+}
+
+func Test_ListTokens(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	data, err := l.ListTokens()
+	assert.NoError(err)
+	assert.Equal("[]", string(data))
+
+	_, err = l.CreateToken("ci", []string{business.ScopeRead})
+	assert.NoError(err)
+	data, err = l.ListTokens()
+	assert.NoError(err)
+	var tokens []createdToken
+	assert.NoError(json.Unmarshal(data, &tokens))
+	if assert.Len(tokens, 1) {
+		assert.Equal("ci", tokens[0].Name)
+		assert.Empty(tokens[0].Token, "Listing should not return the token")
+	}
+	assert.NotContains(string(data), "hash")
+}
+
+func Test_RevokeToken(t *testing.T) {
+	assert := assert.New(t)
+	l := newMemoryLogic()
+
+	data, err := l.CreateToken("ci", []string{business.ScopeRead})
+	assert.NoError(err)
+	var token createdToken
+	assert.NoError(json.Unmarshal(data, &token))
+
+	assert.NoError(l.RevokeToken(token.ID))
+	_, err = l.AuthenticateToken(token.Token)
+	assert.Equal(business.ErrInvalidToken, err)
+	assert.Equal(business.ErrTokenNotFound, l.RevokeToken(token.ID))
+}
+
+func Test_TokensUnsupported(t *testing.T) {
+	assert := assert.New(t)
+	l := business.NewLogic(entriesOnly{backend.NewMemory()}, false, 0)
+
+	assert.False(l.SupportsTokens())
+	_, err := l.CreateToken("ci", []string{business.ScopeRead})
+	assert.Equal(business.ErrTokensUnsupported, err)
+	_, err = l.AuthenticateToken("foo.bar")
+	assert.Equal(business.ErrInvalidToken, err)
+}
+
+func Test_IdentityAdminScope(t *testing.T) {
+	identity := business.Identity{Scopes: []string{business.ScopeAdmin}}
+	for _, scope := range business.Scopes {
+		assert.True(t, identity.HasScope(scope), scope)
+	}
+}
+
+// entriesOnly hides every optional interface of the wrapped backend
+type entriesOnly struct {
+	backend.Backend
+}
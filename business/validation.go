@@ -1,7 +1,10 @@
 package business
 
 import (
+	"errors"
+
 	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/policy"
 	"github.com/chrisvdg/gotiny/utils"
 )
 
@@ -9,9 +12,48 @@ var (
 	//ValidationErrors contains a list of possible validation error
 	ValidationErrors = []error{
 		backend.ErrIDInUse,
+		ErrInvalidExpiry,
+		ErrInvalidRedirect,
+		ErrInvalidPassword,
+		ErrInvalidScope,
+		ErrInvalidTokenName,
+		ErrURLNotAllowed,
+		ErrIDNotAllowed,
 	}
 	// ErrTinyURLNotFound represents an error where a Tiny URL could not be found in the backend
 	ErrTinyURLNotFound = backend.ErrNotFound
+	// ErrURLNotAllowed represents a destination URL that is denied or not allowed by the policy
+	ErrURLNotAllowed = policy.ErrURLNotAllowed
+	// ErrIDNotAllowed represents an ID that is reserved or contains a blocked word
+	ErrIDNotAllowed = policy.ErrIDNotAllowed
+	// ErrTinyURLExpired represents an error where a Tiny URL entry expired
+	ErrTinyURLExpired = errors.New("tiny URL entry expired")
+	// ErrAnalyticsDisabled represents an error where statistics are requested while analytics are disabled
+	ErrAnalyticsDisabled = errors.New("analytics are disabled")
+	// ErrInvalidExpiry represents an invalid ttl or expires value
+	ErrInvalidExpiry = errors.New("invalid expiry, provide either a positive ttl duration or an expires time in the future")
+	// ErrInvalidRedirect represents an unsupported redirect status code
+	ErrInvalidRedirect = errors.New("invalid redirect, supported status codes are 301, 302, 307 and 308")
+	// ErrInvalidPassword represents a password that is too long or is set while removing the password
+	ErrInvalidPassword = errors.New("invalid password, passwords can be at most 72 bytes long")
+	// ErrPasswordRequired represents an error where a password protected entry is followed without unlocking it
+	ErrPasswordRequired = errors.New("tiny URL entry is password protected")
+	// ErrWrongPassword represents an error where a password protected entry is unlocked with a wrong password
+	ErrWrongPassword = errors.New("wrong password")
+	// ErrInvalidScope represents an unsupported or missing token scope
+	ErrInvalidScope = errors.New("invalid scope, supported scopes are read, create-generated, create-custom, update, delete and admin")
+	// ErrInvalidTokenName represents an empty or too long token name
+	ErrInvalidTokenName = errors.New("invalid token name, provide a name of at most 100 characters")
+	// ErrInvalidToken represents a token that is not stored in the backend or does not match
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenNotFound represents an error where a token could not be found in the backend
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrNotOwner represents an error where an entry is modified by a token that does not own it
+	ErrNotOwner = errors.New("tiny URL entry is owned by another token")
+	// ErrTokensUnsupported represents an error where tokens are managed while the backend can not store them
+	ErrTokensUnsupported = errors.New("backend does not support storing tokens")
+	// ErrOptionsUnsupported represents an error where an entry is created with options the backend can not store
+	ErrOptionsUnsupported = errors.New("backend does not support creating entries with an expiry, redirect or password")
 )
 
 func init() {
@@ -0,0 +1,179 @@
+// Package config sets command line flags from a config file and environment variables
+package config
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is the prefix of the environment variables that set flags
+const EnvPrefix = "GOTINY_"
+
+// sources of a flag value, in order of precedence
+const (
+	sourceDefault = iota
+	sourceFile
+	sourceEnv
+	sourceFlag
+)
+
+// EnvName returns the name of the environment variable that sets the flag, e.g. GOTINY_LISTENADDR
+// Dashes are replaced by underscores, e.g. GOTINY_ACME_DOMAIN
+func EnvName(flag string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+}
+
+// Load reads the flag values of a YAML, TOML or JSON config file, selected by its extension
+// The keys are flag names, lists are joined into comma separated values
+func Load(filePath string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %s", err)
+	}
+
+	raw := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		_, err = toml.Decode(string(data), &raw)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&raw)
+	default:
+		return nil, fmt.Errorf("unsupported config file %s, expected a .yaml, .yml, .toml or .json file", filePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode config file %s: %s", filePath, err)
+	}
+
+	values := map[string]string{}
+	for key, v := range raw {
+		value, err := formatValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s in config file %s: %s", key, filePath, err)
+		}
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+// formatValue returns the flag value of a decoded config value
+func formatValue(v interface{}) (string, error) {
+	switch value := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case []interface{}:
+		list := make([]string, len(value))
+		for i, item := range value {
+			s, err := formatValue(item)
+			if err != nil {
+				return "", err
+			}
+			if _, ok := item.([]interface{}); ok {
+				return "", fmt.Errorf("nested lists are not supported")
+			}
+			list[i] = s
+		}
+		return joinCSV(list)
+	case map[string]interface{}, map[interface{}]interface{}:
+		return "", fmt.Errorf("nested tables are not supported")
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
+
+// joinCSV joins the values the way list flags split them
+func joinCSV(values []string) (string, error) {
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	err := w.Write(values)
+	if err != nil {
+		return "", err
+	}
+	w.Flush()
+
+	return strings.TrimSuffix(buf.String(), "\n"), w.Error()
+}
+
+// Apply sets the flags that are not set on the command line
+// from their environment variable, named by envName, or else from the config file values
+// So flags take precedence over environment variables, which take precedence over the config file
+// The flags of a group are one setting, e.g. a token and its file, so only the flags of the group
+// set from the source with the highest precedence are applied
+// An error is returned for config file keys that are not a flag and for invalid values
+func Apply(flags *pflag.FlagSet, values map[string]string, envName func(flag string) string, groups ...[]string) error {
+	var unknown []string
+	for key := range values {
+		if flags.Lookup(key) == nil {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown config file keys: %s", strings.Join(unknown, ", "))
+	}
+
+	source := func(name string) int {
+		if flags.Changed(name) {
+			return sourceFlag
+		}
+		if _, ok := os.LookupEnv(envName(name)); ok {
+			return sourceEnv
+		}
+		if _, ok := values[name]; ok {
+			return sourceFile
+		}
+		return sourceDefault
+	}
+	skip := map[string]bool{}
+	for _, group := range groups {
+		highest := sourceDefault
+		for _, name := range group {
+			if s := source(name); s > highest {
+				highest = s
+			}
+		}
+		for _, name := range group {
+			if source(name) < highest {
+				skip[name] = true
+			}
+		}
+	}
+
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || skip[f.Name] {
+			return
+		}
+		env := envName(f.Name)
+		if value, ok := os.LookupEnv(env); ok {
+			if setErr := flags.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q of %s: %s", value, env, setErr)
+			}
+			return
+		}
+		if value, ok := values[f.Name]; ok {
+			if setErr := flags.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value %q of %s in the config file: %s", value, f.Name, setErr)
+			}
+		}
+	})
+
+	return err
+}
@@ -0,0 +1,176 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chrisvdg/gotiny/config"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, dir string, name string, data string) string {
+	filePath := path.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(filePath, []byte(data), 0666))
+	return filePath
+}
+
+func Test_Load(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "config_test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	expected := map[string]string{
+		"listenaddr":     ":9090",
+		"idlen":          "7",
+		"prettyjson":     "true",
+		"reapinterval":   "1m0s",
+		"trustedproxies": `10.0.0.0/8,"a,b"`,
+	}
+	files := map[string]string{
+		"config.yaml": "listenaddr: \":9090\"\nidlen: 7\nprettyjson: true\nreapinterval: 1m0s\ntrustedproxies: [10.0.0.0/8, \"a,b\"]\n",
+		"config.toml": "listenaddr = \":9090\"\nidlen = 7\nprettyjson = true\nreapinterval = \"1m0s\"\ntrustedproxies = [\"10.0.0.0/8\", \"a,b\"]\n",
+		"config.json": `{"listenaddr": ":9090", "idlen": 7, "prettyjson": true, "reapinterval": "1m0s", "trustedproxies": ["10.0.0.0/8", "a,b"]}`,
+	}
+	for name, data := range files {
+		values, err := config.Load(writeConfig(t, dir, name, data))
+		assert.NoError(err, name)
+		assert.Equal(expected, values, name)
+	}
+
+	_, err = config.Load(writeConfig(t, dir, "config.ini", "listenaddr=:9090"))
+	assert.Error(err)
+	_, err = config.Load(writeConfig(t, dir, "nested.yaml", "tls:\n  cert: foo\n"))
+	assert.Error(err)
+	_, err = config.Load(path.Join(dir, "missing.yaml"))
+	assert.Error(err)
+}
+
+func Test_Apply(t *testing.T) {
+	assert := assert.New(t)
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	listenAddr := flags.String("listenaddr", ":8080", "")
+	tlsAddr := flags.String("tlsaddr", ":8443", "")
+	backend := flags.String("backend", "file", "")
+	interval := flags.Duration("reapinterval", time.Minute, "")
+	idLen := flags.Int("idlen", 5, "")
+	assert.NoError(flags.Parse([]string{"--listenaddr", ":1111"}))
+
+	os.Setenv("GOTINY_TEST_TLSADDR", ":2222")
+	os.Setenv("GOTINY_TEST_BACKEND", "memory")
+	defer os.Unsetenv("GOTINY_TEST_TLSADDR")
+	defer os.Unsetenv("GOTINY_TEST_BACKEND")
+	envName := func(flag string) string {
+		return "GOTINY_TEST_" + strings.ToUpper(flag)
+	}
+
+	err := config.Apply(flags, map[string]string{
+		"listenaddr":   ":3333",
+		"tlsaddr":      ":4444",
+		"reapinterval": "5m",
+	}, envName)
+	assert.NoError(err)
+	assert.Equal(":1111", *listenAddr, "Flags should take precedence")
+	assert.Equal(":2222", *tlsAddr, "Environment variables should take precedence over the config file")
+	assert.Equal("memory", *backend)
+	assert.Equal(5*time.Minute, *interval, "Config file should take precedence over defaults")
+	assert.Equal(5, *idLen, "Defaults should be kept")
+
+	err = config.Apply(flags, map[string]string{"foo": "bar", "bar": "foo"}, config.EnvName)
+	assert.EqualError(err, "unknown config file keys: bar, foo")
+	err = config.Apply(flags, map[string]string{"idlen": "five"}, config.EnvName)
+	assert.Error(err)
+}
+
+func Test_ApplyGroups(t *testing.T) {
+	assert := assert.New(t)
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	readToken := flags.String("readtoken", "", "")
+	readTokenFile := flags.String("readtokenfile", "", "")
+	writeToken := flags.String("writetoken", "", "")
+	writeTokenFile := flags.String("writetokenfile", "", "")
+	adminToken := flags.String("admintoken", "", "")
+	adminTokenFile := flags.String("admintokenfile", "", "")
+	assert.NoError(flags.Parse([]string{"--readtoken", "flag"}))
+
+	os.Setenv("GOTINY_TEST_READTOKENFILE", "/env/read")
+	os.Setenv("GOTINY_TEST_WRITETOKENFILE", "/env/write")
+	defer os.Unsetenv("GOTINY_TEST_READTOKENFILE")
+	defer os.Unsetenv("GOTINY_TEST_WRITETOKENFILE")
+	envName := func(flag string) string {
+		return "GOTINY_TEST_" + strings.ToUpper(flag)
+	}
+
+	err := config.Apply(flags, map[string]string{
+		"writetoken":     "file",
+		"admintoken":     "file",
+		"admintokenfile": "/file/admin",
+	}, envName,
+		[]string{"readtoken", "readtokenfile"},
+		[]string{"writetoken", "writetokenfile"},
+		[]string{"admintoken", "admintokenfile"},
+	)
+	assert.NoError(err)
+	assert.Equal("flag", *readToken)
+	assert.Empty(*readTokenFile, "The flag should take precedence over the environment variable of the group")
+	assert.Empty(*writeToken, "The environment variable should take precedence over the config file of the group")
+	assert.Equal("/env/write", *writeTokenFile)
+	assert.Equal("file", *adminToken, "Flags of the group set from the same source should both be applied")
+	assert.Equal("/file/admin", *adminTokenFile)
+}
+
+func Test_EnvName(t *testing.T) {
+	assert.Equal(t, "GOTINY_LISTENADDR", config.EnvName("listenaddr"))
+	assert.Equal(t, "GOTINY_ACME_CACHE_DIR", config.EnvName("acme-cache-dir"))
+}
+
+func Test_Watcher(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "config_test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	filePath := writeConfig(t, dir, "config.yaml", "idlen: 5\n")
+	missingPath := path.Join(dir, "token")
+
+	changes := make(chan struct{}, 10)
+	w := config.NewWatcher(10*time.Millisecond, func() {
+		changes <- struct{}{}
+	}, filePath, missingPath)
+	defer w.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(changes, 0, "Unchanged files should not be reported")
+
+	writeConfig(t, dir, "config.yaml", "idlen: 10\n")
+	assert.True(waitForChanges(changes), "Changed file should be reported")
+
+	writeConfig(t, dir, "token", "secret")
+	assert.True(waitForChanges(changes), "Created file should be reported")
+
+	assert.NoError(w.Close())
+	writeConfig(t, dir, "config.yaml", "idlen: 15\n")
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(changes, 0, "Closed watcher should not report changes")
+}
+
+// waitForChanges returns true once changes reported a change, the following changes are discarded
+// A write can be reported twice, when the file is seen after it was truncated but before its data was written
+func waitForChanges(changes chan struct{}) bool {
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		return false
+	}
+	for {
+		select {
+		case <-changes:
+		case <-time.After(50 * time.Millisecond):
+			return true
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// NewWatcher calls onChange in the background every interval one of the files changed, until the watcher is closed
+// Files that do not exist are ignored until they are created
+func NewWatcher(interval time.Duration, onChange func(), files ...string) *Watcher {
+	w := &Watcher{
+		files:    files,
+		onChange: onChange,
+		stats:    make([]fileStat, len(files)),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	w.changed()
+	go w.watch(interval)
+
+	return w
+}
+
+// Watcher watches files for changes, see NewWatcher
+type Watcher struct {
+	files    []string
+	onChange func()
+	stats    []fileStat
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// fileStat represents the state of a file used to detect changes
+type fileStat struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+}
+
+// Close stops watching the files
+// It waits for a call to onChange in progress to return
+func (w *Watcher) Close() error {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	<-w.done
+
+	return nil
+}
+
+// watch calls onChange every interval the files changed until the watcher is closed
+func (w *Watcher) watch(interval time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if w.changed() {
+				w.onChange()
+			}
+		}
+	}
+}
+
+// changed returns true if one of the files was created or its modification time or size changed since the last check
+// A file that was removed is not a change, so replacing a file is only noticed once it is written
+func (w *Watcher) changed() bool {
+	changed := false
+	for i, file := range w.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		stat := fileStat{exists: true, modTime: info.ModTime(), size: info.Size()}
+		if stat != w.stats[i] {
+			changed = true
+			w.stats[i] = stat
+		}
+	}
+
+	return changed
+}
@@ -1,11 +1,26 @@
 module github.com/chrisvdg/gotiny
 
-go 1.13
+go 1.17
 
 require (
+	github.com/BurntSushi/toml v1.2.0
+	github.com/gomodule/redigo v1.8.4
 	github.com/gorilla/mux v1.7.4
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.5.1
-	golang.org/x/sys v0.0.0-20220408201424-a24fb2fb8a0f // indirect
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/crypto v0.11.0
+	gopkg.in/yaml.v2 v2.2.2
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.3 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/net v0.10.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
+	golang.org/x/text v0.11.0 // indirect
 )
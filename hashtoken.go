@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/chrisvdg/gotiny/server"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+)
+
+// hashTokenCommand is the name of the subcommand that hashes a token read from stdin
+const hashTokenCommand = "hashtoken"
+
+// runHashToken runs the hashtoken subcommand with the provided arguments and returns the exit code
+// The token is read from stdin so it does not show up in the process list or shell history
+func runHashToken(args []string) int {
+	flags := pflag.NewFlagSet(hashTokenCommand, pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gotiny %s [--algorithm bcrypt|argon2] < token-file\n\n", hashTokenCommand)
+		fmt.Fprint(os.Stderr, "Reads a token from stdin and prints its hash, which can be configured instead of the token\n\n")
+		flags.PrintDefaults()
+	}
+	algorithm := flags.String("algorithm", server.HashBcrypt, "Hash algorithm (bcrypt or argon2)")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	token, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	token = strings.TrimSpace(token)
+	if token == "" {
+		log.Errorf("Failed to read token from stdin: %v", err)
+		return 1
+	}
+	hash, err := server.HashToken(token, *algorithm)
+	if err != nil {
+		log.Errorf("Failed to hash token: %s", err)
+		return 1
+	}
+	fmt.Println(hash)
+
+	return 0
+}
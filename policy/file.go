@@ -0,0 +1,147 @@
+package policy
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// NewFile loads the policy of the JSON file at filePath
+func NewFile(filePath string) (*File, error) {
+	f := &File{path: filePath}
+	err := f.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// File is a policy loaded from a JSON file that can be reloaded while it is in use
+// It is safe for concurrent use
+type File struct {
+	path string
+
+	mu      sync.RWMutex
+	policy  *Policy
+	modTime time.Time
+	size    int64
+
+	watchMu   sync.Mutex
+	watchStop chan struct{}
+	watchDone chan struct{}
+}
+
+// Policy returns the current policy
+func (f *File) Policy() *Policy {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.policy
+}
+
+// CheckURL checks the destination URL with the current policy, see Policy.CheckURL
+func (f *File) CheckURL(rawURL string) error {
+	return f.Policy().CheckURL(rawURL)
+}
+
+// CheckID checks the ID with the current policy, see Policy.CheckID
+func (f *File) CheckID(id string) error {
+	return f.Policy().CheckID(id)
+}
+
+// Reload loads the policy from the file again
+// The current policy is kept when the file can not be loaded
+func (f *File) Reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read policy file: %s", err)
+	}
+	p, err := Load(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.policy = p
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+
+	return nil
+}
+
+// Watch reloads the policy in the background when the file changed, checked every interval
+// Watching is stopped by Close
+func (f *File) Watch(interval time.Duration) {
+	f.watchMu.Lock()
+	defer f.watchMu.Unlock()
+	if f.watchStop != nil {
+		return
+	}
+
+	f.watchStop = make(chan struct{})
+	f.watchDone = make(chan struct{})
+	go f.watch(interval, f.watchStop, f.watchDone)
+}
+
+// Close stops watching the file, if it was watched
+func (f *File) Close() error {
+	f.watchMu.Lock()
+	defer f.watchMu.Unlock()
+	if f.watchStop == nil {
+		return nil
+	}
+
+	close(f.watchStop)
+	<-f.watchDone
+	f.watchStop = nil
+	f.watchDone = nil
+
+	return nil
+}
+
+// watch reloads the policy every interval the file changed until stop is closed
+func (f *File) watch(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			info, changed := f.changed()
+			if !changed {
+				continue
+			}
+			err := f.Reload()
+			if err != nil {
+				log.Errorf("Failed to reload policy, keeping the current policy: %s", err)
+				// Only retry once the file changes again
+				f.mu.Lock()
+				f.modTime = info.ModTime()
+				f.size = info.Size()
+				f.mu.Unlock()
+				continue
+			}
+			log.Infof("Reloaded policy from %s", f.path)
+		}
+	}
+}
+
+// changed returns true if the modification time or size of the file changed since it was loaded
+func (f *File) changed() (os.FileInfo, bool) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return info, !info.ModTime().Equal(f.modTime) || info.Size() != f.size
+}
@@ -0,0 +1,45 @@
+package policy_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/chrisvdg/gotiny/policy"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FileWatch(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "policy_test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	filePath := path.Join(dir, "policy.json")
+
+	assert.NoError(ioutil.WriteFile(filePath, []byte(`{"urls": {"deny": {"domains": ["foo.bar"]}}}`), 0666))
+	f, err := policy.NewFile(filePath)
+	assert.NoError(err)
+	defer f.Close()
+	assert.Equal(policy.ErrURLNotAllowed, f.CheckURL("http://foo.bar"))
+	assert.NoError(f.CheckURL("http://bar.foo"))
+
+	f.Watch(10 * time.Millisecond)
+	assert.NoError(ioutil.WriteFile(filePath, []byte(`{"urls": {"deny": {"domains": ["bar.foo"]}}}`), 0666))
+	assert.Eventually(func() bool {
+		return f.CheckURL("http://bar.foo") == policy.ErrURLNotAllowed
+	}, time.Second, 10*time.Millisecond, "Policy should be reloaded")
+	assert.NoError(f.CheckURL("http://foo.bar"))
+
+	// An invalid policy keeps the current policy
+	assert.NoError(ioutil.WriteFile(filePath, []byte(`{"urls": {"deny": {"networks": ["foo"]}}}`), 0666))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(policy.ErrURLNotAllowed, f.CheckURL("http://bar.foo"))
+	assert.Error(f.Reload())
+}
+
+func Test_NewFileMissing(t *testing.T) {
+	_, err := policy.NewFile(path.Join(os.TempDir(), "policy_test_missing.json"))
+	assert.Error(t, err)
+}
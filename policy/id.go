@@ -0,0 +1,87 @@
+package policy
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrIDNotAllowed represents an ID that is reserved or contains a blocked word
+var ErrIDNotAllowed = errors.New("ID is reserved or not allowed")
+
+// DefaultReservedIDs contains IDs that are reserved for routes of the server
+var DefaultReservedIDs = []string{
+	"api", "admin", "login", "logout", "signin", "signup", "register", "account", "settings", "dashboard",
+	"static", "assets", "public", "docs", "help", "about", "health", "healthz", "metrics", "status",
+	"stats", "expand", "unlock", "tiny", "favicon.ico", "robots.txt", ".well-known",
+}
+
+// DefaultBlockedWords contains offensive words IDs can not contain
+var DefaultBlockedWords = []string{
+	"fuck", "shit", "cunt", "bitch", "whore", "slut", "twat", "wank", "porn", "nigger", "nigga", "faggot",
+}
+
+// IDRules represents the rules of entry IDs
+// A list that is not set uses its default list, an empty list disables it
+type IDRules struct {
+	// Reserved IDs can not be used, compared case insensitive
+	Reserved []string `json:"reserved"`
+	// Blocked words can not be part of an ID, compared case insensitive
+	// and after replacing digits that look like letters (e.g. 5h1t) and removing separators
+	Blocked []string `json:"blocked"`
+
+	reserved map[string]bool
+	blocked  []string
+}
+
+// idReplacer normalizes IDs before they are compared to blocked words
+var idReplacer = strings.NewReplacer(
+	"0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "8", "b", "9", "g",
+	"-", "", "_", "", ".", "", "~", "",
+)
+
+// compile validates and normalizes the rules
+func (r *IDRules) compile() error {
+	reserved := r.Reserved
+	if reserved == nil {
+		reserved = DefaultReservedIDs
+	}
+	r.reserved = map[string]bool{}
+	for _, id := range reserved {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if id == "" {
+			return errors.New("invalid reserved ID: empty ID")
+		}
+		r.reserved[id] = true
+	}
+
+	blocked := r.Blocked
+	if blocked == nil {
+		blocked = DefaultBlockedWords
+	}
+	r.blocked = nil
+	for _, word := range blocked {
+		word = idReplacer.Replace(strings.ToLower(strings.TrimSpace(word)))
+		if word == "" {
+			return errors.New("invalid blocked word: empty word")
+		}
+		r.blocked = append(r.blocked, word)
+	}
+
+	return nil
+}
+
+// check returns ErrIDNotAllowed if the ID is reserved or contains a blocked word
+func (r *IDRules) check(id string) error {
+	lower := strings.ToLower(id)
+	if r.reserved[lower] {
+		return ErrIDNotAllowed
+	}
+	normalized := idReplacer.Replace(lower)
+	for _, word := range r.blocked {
+		if strings.Contains(normalized, word) {
+			return ErrIDNotAllowed
+		}
+	}
+
+	return nil
+}
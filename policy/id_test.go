@@ -0,0 +1,38 @@
+package policy_test
+
+import (
+	"testing"
+
+	"github.com/chrisvdg/gotiny/policy"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CheckIDDefault(t *testing.T) {
+	assert := assert.New(t)
+	p := policy.Default()
+
+	for _, id := range []string{"foo", "bar-baz", "essex", "assets2"} {
+		assert.NoError(p.CheckID(id), id)
+	}
+	for _, id := range []string{"api", "Admin", "robots.txt", "shit", "bullShit", "5h1t", "f-u-c-k"} {
+		assert.Equal(policy.ErrIDNotAllowed, p.CheckID(id), id)
+	}
+}
+
+func Test_CheckIDConfigured(t *testing.T) {
+	assert := assert.New(t)
+	p, err := policy.Parse([]byte(`{"ids": {"reserved": ["go"], "blocked": ["spam"]}}`))
+	assert.NoError(err)
+
+	assert.NoError(p.CheckID("api"), "Configured lists should replace the defaults")
+	assert.NoError(p.CheckID("shit"))
+	assert.Equal(policy.ErrIDNotAllowed, p.CheckID("GO"))
+	assert.Equal(policy.ErrIDNotAllowed, p.CheckID("my-5p4m"))
+
+	p, err = policy.Parse([]byte(`{"ids": {"reserved": [], "blocked": []}}`))
+	assert.NoError(err)
+	assert.NoError(p.CheckID("api"), "Empty lists should disable the rules")
+
+	_, err = policy.Parse([]byte(`{"ids": {"blocked": [" "]}}`))
+	assert.Error(err)
+}
@@ -0,0 +1,73 @@
+// Package policy restricts the destination URLs and IDs of tiny URL entries
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Policy represents the rules entries have to satisfy
+// The zero Policy allows every entry until it is compiled, after which the default ID rules apply
+type Policy struct {
+	URLs URLRules `json:"urls"`
+	IDs  IDRules  `json:"ids"`
+}
+
+// Default returns the policy without URL rules and with the default ID rules
+func Default() *Policy {
+	p := &Policy{}
+	// The default rules are valid
+	p.Compile()
+
+	return p
+}
+
+// Load reads and compiles a JSON encoded policy from a file
+func Load(filePath string) (*Policy, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %s", err)
+	}
+
+	return Parse(data)
+}
+
+// Parse decodes and compiles a JSON encoded policy, unknown fields are rejected
+func Parse(data []byte) (*Policy, error) {
+	p := &Policy{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode policy: %s", err)
+	}
+	err = p.Compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Compile validates the rules and prepares them for matching
+// It should be called after changing the rules of a policy
+func (p *Policy) Compile() error {
+	err := p.URLs.compile()
+	if err != nil {
+		return err
+	}
+
+	return p.IDs.compile()
+}
+
+// CheckURL returns ErrURLNotAllowed when the destination URL is denied or not allowed
+func (p *Policy) CheckURL(rawURL string) error {
+	return p.URLs.check(rawURL)
+}
+
+// CheckID returns ErrIDNotAllowed when the ID is reserved or contains a blocked word
+func (p *Policy) CheckID(id string) error {
+	return p.IDs.check(id)
+}
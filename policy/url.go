@@ -0,0 +1,224 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrURLNotAllowed represents a destination URL that is denied or not allowed by the policy
+var ErrURLNotAllowed = errors.New("URL destination is not allowed")
+
+// URLRules represents the allow and deny rules of destination URLs
+// A URL is allowed when it matches none of the deny rules
+// and, for every kind of allow rule that is set, at least one of the allow rules
+type URLRules struct {
+	Allow URLRuleSet `json:"allow"`
+	Deny  URLRuleSet `json:"deny"`
+}
+
+// URLRuleSet represents a set of rules of destination URLs
+type URLRuleSet struct {
+	// Schemes match the URL scheme (e.g. https)
+	Schemes []string `json:"schemes"`
+	// Domains match the host and its subdomains (e.g. example.com matches www.example.com)
+	Domains []string `json:"domains"`
+	// Networks match hosts that are an IP address within the IP or CIDR range (e.g. 10.0.0.0/8)
+	Networks []string `json:"networks"`
+
+	schemes  []string
+	domains  []string
+	networks []*net.IPNet
+}
+
+// compile validates and normalizes the rules of both sets
+func (r *URLRules) compile() error {
+	err := r.Allow.compile()
+	if err != nil {
+		return fmt.Errorf("invalid allow rule: %s", err)
+	}
+	err = r.Deny.compile()
+	if err != nil {
+		return fmt.Errorf("invalid deny rule: %s", err)
+	}
+
+	return nil
+}
+
+// check returns ErrURLNotAllowed if the URL does not satisfy the rules
+func (r *URLRules) check(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ErrURLNotAllowed
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := normalizeDomain(u.Hostname())
+	ip := parseHostIP(host)
+
+	if r.Deny.matchScheme(scheme) || r.Deny.matchHost(host, ip) {
+		return ErrURLNotAllowed
+	}
+	if len(r.Allow.schemes) > 0 && !r.Allow.matchScheme(scheme) {
+		return ErrURLNotAllowed
+	}
+	if (len(r.Allow.domains) > 0 || len(r.Allow.networks) > 0) && !r.Allow.matchHost(host, ip) {
+		return ErrURLNotAllowed
+	}
+
+	return nil
+}
+
+// compile validates and normalizes the rules
+func (s *URLRuleSet) compile() error {
+	s.schemes = nil
+	for _, scheme := range s.Schemes {
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if scheme == "" {
+			return errors.New("empty scheme")
+		}
+		s.schemes = append(s.schemes, scheme)
+	}
+
+	s.domains = nil
+	for _, domain := range s.Domains {
+		domain = normalizeDomain(strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(domain), "*"), "."))
+		if domain == "" {
+			return errors.New("empty domain")
+		}
+		s.domains = append(s.domains, domain)
+	}
+
+	s.networks = nil
+	for _, network := range s.Networks {
+		n, err := parseNetwork(network)
+		if err != nil {
+			return err
+		}
+		s.networks = append(s.networks, n)
+	}
+
+	return nil
+}
+
+// matchScheme returns true if the scheme matches one of the scheme rules
+func (s *URLRuleSet) matchScheme(scheme string) bool {
+	for _, rule := range s.schemes {
+		if scheme == rule {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchHost returns true if the host matches one of the domain rules
+// or its IP, nil when the host is a name, matches one of the network rules
+func (s *URLRuleSet) matchHost(host string, ip net.IP) bool {
+	if ip != nil {
+		for _, network := range s.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, domain := range s.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeDomain lower cases the domain and removes the trailing dot of fully qualified names
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(domain), ".")
+}
+
+// parseNetwork parses an IP or CIDR range
+func parseNetwork(value string) (*net.IPNet, error) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid network %q", value)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}, nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network %q: %s", value, err)
+	}
+
+	return network, nil
+}
+
+// parseHostIP returns the IP of a host that is an IP address or nil
+// Browsers also accept IPv4 addresses with less than four parts and octal or hexadecimal parts
+// (e.g. 127.1, 0x7f.0.0.1 or 2130706433), these are parsed as well so they can not bypass network rules
+func parseHostIP(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+
+	parts := strings.Split(host, ".")
+	if len(parts) > 4 {
+		return nil
+	}
+	values := make([]uint64, len(parts))
+	for i, part := range parts {
+		v, ok := parseIPv4Part(part)
+		if !ok {
+			return nil
+		}
+		values[i] = v
+	}
+	// Every part but the last is a single byte, the last part fills the remaining bytes
+	var addr uint64
+	for _, v := range values[:len(values)-1] {
+		if v > 0xff {
+			return nil
+		}
+		addr = addr<<8 | v
+	}
+	last := values[len(values)-1]
+	remaining := uint(8 * (5 - len(values)))
+	if last >= 1<<remaining {
+		return nil
+	}
+	addr = addr<<remaining | last
+
+	return net.IPv4(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr))
+}
+
+// parseIPv4Part parses a decimal, octal (leading 0) or hexadecimal (leading 0x) part of an IPv4 address
+func parseIPv4Part(part string) (uint64, bool) {
+	base := 10
+	switch {
+	case strings.HasPrefix(part, "0x") || strings.HasPrefix(part, "0X"):
+		base = 16
+		part = part[2:]
+		if part == "" {
+			return 0, true
+		}
+	case len(part) > 1 && part[0] == '0':
+		base = 8
+		part = part[1:]
+	}
+	if part == "" || strings.ContainsAny(part, "+-_") {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(part, base, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	return v, true
+}
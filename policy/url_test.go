@@ -0,0 +1,82 @@
+package policy_test
+
+import (
+	"testing"
+
+	"github.com/chrisvdg/gotiny/policy"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CheckURLDeny(t *testing.T) {
+	assert := assert.New(t)
+	p, err := policy.Parse([]byte(`{"urls": {"deny": {
+		"schemes": ["ftp"],
+		"domains": ["localhost", "*.evil.com"],
+		"networks": ["10.0.0.0/8", "127.0.0.0/8", "::1"]
+	}}}`))
+	assert.NoError(err)
+
+	for _, u := range []string{"http://example.com", "https://notevil.com", "http://11.0.0.1", "http://[::2]/"} {
+		assert.NoError(p.CheckURL(u), u)
+	}
+	for _, u := range []string{
+		"ftp://example.com",
+		"http://localhost:8080/admin",
+		"http://LOCALHOST./",
+		"http://evil.com",
+		"https://www.Evil.com/login",
+		"http://10.1.2.3",
+		"http://[::1]:8080",
+		"http://127.1",
+		"http://0x7f.0.0.1",
+		"http://2130706433",
+		"http://0177.0.0.1",
+	} {
+		assert.Equal(policy.ErrURLNotAllowed, p.CheckURL(u), u)
+	}
+}
+
+func Test_CheckURLAllow(t *testing.T) {
+	assert := assert.New(t)
+	p, err := policy.Parse([]byte(`{"urls": {
+		"allow": {"schemes": ["https"], "domains": ["example.com"], "networks": ["192.0.2.0/24"]},
+		"deny": {"domains": ["private.example.com"]}
+	}}`))
+	assert.NoError(err)
+
+	for _, u := range []string{"https://example.com", "https://www.example.com/foo", "https://192.0.2.10"} {
+		assert.NoError(p.CheckURL(u), u)
+	}
+	for _, u := range []string{
+		"http://example.com",
+		"https://example.org",
+		"https://notexample.com",
+		"https://198.51.100.1",
+		"https://private.example.com",
+		"https://a.private.example.com",
+	} {
+		assert.Equal(policy.ErrURLNotAllowed, p.CheckURL(u), u)
+	}
+}
+
+func Test_ParseInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, data := range []string{
+		`{"urls": {"deny": {"networks": ["10.0.0.0/33"]}}}`,
+		`{"urls": {"deny": {"networks": ["foo"]}}}`,
+		`{"urls": {"allow": {"domains": [""]}}}`,
+		`{"urls": {"deny": {"hosts": ["localhost"]}}}`,
+		`{"urls": `,
+	} {
+		_, err := policy.Parse([]byte(data))
+		assert.Error(err, data)
+	}
+}
+
+func Test_ZeroPolicy(t *testing.T) {
+	assert := assert.New(t)
+	p := &policy.Policy{}
+
+	assert.NoError(p.CheckURL("http://localhost"))
+}
@@ -0,0 +1,155 @@
+// Package ratelimit limits the rate of requests per key with token buckets
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidLimit represents a limit that can not be parsed
+var ErrInvalidLimit = errors.New("invalid rate limit, expected requests/period (e.g. 20/m, 5/s or 100/10m)")
+
+// Limit represents the amount of requests allowed per period
+// The zero Limit does not limit requests
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseLimit parses a limit in the requests/period format
+// where the period is s, m, h or a duration (e.g. 20/m, 5/s or 100/10m)
+// An empty string returns the zero Limit
+func ParseLimit(value string) (Limit, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return Limit{}, nil
+	}
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return Limit{}, ErrInvalidLimit
+	}
+	requests, err := strconv.Atoi(parts[0])
+	if err != nil || requests <= 0 {
+		return Limit{}, ErrInvalidLimit
+	}
+
+	var period time.Duration
+	switch parts[1] {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		period, err = time.ParseDuration(parts[1])
+		if err != nil || period <= 0 {
+			return Limit{}, ErrInvalidLimit
+		}
+	}
+
+	return Limit{Requests: requests, Period: period}, nil
+}
+
+// IsZero returns true if the limit does not limit requests
+func (l Limit) IsZero() bool {
+	return l.Requests <= 0 || l.Period <= 0
+}
+
+// String implements fmt.Stringer
+func (l Limit) String() string {
+	if l.IsZero() {
+		return ""
+	}
+
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// NewLimiter returns a new limiter that allows limit.Requests requests per key within limit.Period
+func NewLimiter(limit Limit) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Limiter is a token bucket rate limiter per key
+// Every key starts with a full bucket of limit.Requests tokens,
+// a request takes a token and the bucket refills evenly over limit.Period
+// It is safe for concurrent use
+type Limiter struct {
+	limit Limit
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+// bucket represents the tokens left for a key at the last request
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Allow takes a token of the key
+// returns false and how long to wait for the next token when the bucket is empty
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	return l.allow(key, time.Now())
+}
+
+// allow implements Allow at the provided time
+func (l *Limiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if l.limit.IsZero() {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Requests), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(l.interval()))
+	}
+	b.tokens--
+
+	return true, 0
+}
+
+// interval returns the time it takes to refill a single token
+func (l *Limiter) interval() time.Duration {
+	return l.limit.Period / time.Duration(l.limit.Requests)
+}
+
+// refill returns the tokens in the bucket at the provided time
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + float64(now.Sub(b.last))/float64(l.interval())
+	if max := float64(l.limit.Requests); tokens > max {
+		return max
+	}
+
+	return tokens
+}
+
+// prune removes the buckets that refilled completely, at most once per period
+// the caller is expected to hold the lock
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.limit.Period {
+		return
+	}
+	l.lastPrune = now
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.limit.Requests) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]Limit{
+		"":       {},
+		"20/m":   {Requests: 20, Period: time.Minute},
+		"5/s":    {Requests: 5, Period: time.Second},
+		"100/h":  {Requests: 100, Period: time.Hour},
+		"30/10m": {Requests: 30, Period: 10 * time.Minute},
+	}
+	for value, expected := range tests {
+		limit, err := ParseLimit(value)
+		assert.NoError(err, value)
+		assert.Equal(expected, limit, value)
+	}
+
+	for _, value := range []string{"20", "0/m", "-1/s", "foo/m", "20/foo", "20/-1m"} {
+		_, err := ParseLimit(value)
+		assert.Equal(ErrInvalidLimit, err, value)
+	}
+}
+
+func Test_LimiterAllow(t *testing.T) {
+	assert := assert.New(t)
+	l := NewLimiter(Limit{Requests: 2, Period: 10 * time.Second})
+	now := time.Now()
+
+	ok, _ := l.allow("foo", now)
+	assert.True(ok)
+	ok, _ = l.allow("foo", now)
+	assert.True(ok)
+	ok, wait := l.allow("foo", now)
+	assert.False(ok, "Bucket should be empty")
+	assert.Equal(5*time.Second, wait)
+
+	ok, _ = l.allow("bar", now)
+	assert.True(ok, "Keys should have separate buckets")
+
+	ok, wait = l.allow("foo", now.Add(3*time.Second))
+	assert.False(ok)
+	assert.Equal(2*time.Second, wait)
+	ok, _ = l.allow("foo", now.Add(5*time.Second))
+	assert.True(ok, "A token should be refilled after the interval")
+}
+
+func Test_LimiterPrune(t *testing.T) {
+	assert := assert.New(t)
+	l := NewLimiter(Limit{Requests: 2, Period: time.Second})
+	now := time.Now()
+
+	l.allow("foo", now)
+	l.allow("bar", now)
+	assert.Len(l.buckets, 2)
+
+	l.allow("bar", now.Add(2*time.Second))
+	assert.Len(l.buckets, 1, "Refilled buckets should be removed")
+}
+
+func Test_LimiterZero(t *testing.T) {
+	assert := assert.New(t)
+	l := NewLimiter(Limit{})
+
+	for i := 0; i < 100; i++ {
+		ok, _ := l.Allow("foo")
+		assert.True(ok)
+	}
+}
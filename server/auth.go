@@ -1,9 +1,13 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"strings"
+	"sync/atomic"
 
+	"github.com/chrisvdg/gotiny/business"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -12,91 +16,224 @@ const bearer = "bearer"
 // Authorizer defines a type that can be used to authorize to the API
 type Authorizer interface {
 	AuthenticateRead(http.Handler) http.Handler
+	// AuthenticateWrite authenticates updating entries
 	AuthenticateWrite(http.Handler) http.Handler
 	AuthenticateCreate(http.Handler) http.Handler
+	AuthenticateDelete(http.Handler) http.Handler
+	// AuthenticateAdmin authenticates managing tokens
+	AuthenticateAdmin(http.Handler) http.Handler
+}
+
+// TokenAuthenticator authenticates tokens stored in the backend
+type TokenAuthenticator interface {
+	AuthenticateToken(token string) (business.Identity, error)
+}
+
+// AuthorizerOptions represents optional settings for the default authorizer
+type AuthorizerOptions struct {
+	// AdminToken grants every scope,
+	// when it is set every request requires a token with the matching scope
+	AdminToken string
+	// Tokens authenticates tokens stored in the backend, stored tokens are not accepted when nil
+	Tokens TokenAuthenticator
 }
 
 // NewAuthorizer creates a new instance of a default authorizer
-func NewAuthorizer(readToken string, writeToken string, allowPublicCreateGenerated bool) *DefaultAuthorizer {
-	return &DefaultAuthorizer{
-		readToken:                  readToken,
-		writeToken:                 writeToken,
-		allowPublicCreateGenerated: allowPublicCreateGenerated,
+// Tokens are either the token itself or a bcrypt or argon2 hash of it, see HashToken
+func NewAuthorizer(readToken string, writeToken string, allowPublicCreateGenerated bool) (*DefaultAuthorizer, error) {
+	return NewAuthorizerWithOptions(readToken, writeToken, allowPublicCreateGenerated, AuthorizerOptions{})
+}
+
+// NewAuthorizerWithOptions creates a new instance of a default authorizer with the provided options
+// Tokens are either the token itself or a bcrypt or argon2 hash of it, see HashToken
+func NewAuthorizerWithOptions(readToken string, writeToken string, allowPublicCreateGenerated bool, opts AuthorizerOptions) (*DefaultAuthorizer, error) {
+	h := &DefaultAuthorizer{
+		tokens: opts.Tokens,
 	}
+	err := h.SetTokens(readToken, writeToken, opts.AdminToken, allowPublicCreateGenerated)
+	if err != nil {
+		return nil, err
+	}
+
+	return h, nil
 }
 
 // DefaultAuthorizer is the default authorizing middleware
+// The read token grants the read scope and the write token the create, update and delete scopes
+// Without admin token, operations are public when the token of their scope is not set
 type DefaultAuthorizer struct {
-	readToken                  string
-	writeToken                 string
+	// config holds the current *authConfig, see SetTokens
+	config atomic.Value
+	tokens TokenAuthenticator
+}
+
+// authConfig represents the configured tokens of the default authorizer
+type authConfig struct {
+	readToken                  *configToken
+	writeToken                 *configToken
+	adminToken                 *configToken
 	allowPublicCreateGenerated bool
 }
 
-// AuthenticateRead Authenticates for read permissions
-func (h *DefaultAuthorizer) AuthenticateRead(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		if h.readToken != "" {
-			token := h.getToken(req)
-			if token != h.readToken {
-				log.Debugf("Failed to authorize read %s", req.RemoteAddr)
-				res.WriteHeader(http.StatusUnauthorized)
-				return
-			}
+// SetTokens replaces the read, write and admin tokens and whether generated entries can be created without token
+// The settings are swapped at once, so every request is authorized with either the old or the new settings
+// The current settings are kept when one of the tokens is invalid
+func (h *DefaultAuthorizer) SetTokens(readToken string, writeToken string, adminToken string, allowPublicCreateGenerated bool) error {
+	c, err := newAuthConfig(readToken, writeToken, adminToken, allowPublicCreateGenerated)
+	if err != nil {
+		return err
+	}
+	h.config.Store(c)
+
+	return nil
+}
+
+// newAuthConfig creates the settings of the default authorizer
+func newAuthConfig(readToken string, writeToken string, adminToken string, allowPublicCreateGenerated bool) (*authConfig, error) {
+	c := &authConfig{allowPublicCreateGenerated: allowPublicCreateGenerated}
+	for _, t := range []struct {
+		name  string
+		value string
+		dst   **configToken
+	}{
+		{"read", readToken, &c.readToken},
+		{"write", writeToken, &c.writeToken},
+		{"admin", adminToken, &c.adminToken},
+	} {
+		token, err := newConfigToken(t.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s token: %s", t.name, err)
 		}
+		*t.dst = token
+	}
 
-		next.ServeHTTP(res, req)
-	})
+	return c, nil
+}
+
+// current returns the current settings of the authorizer
+func (h *DefaultAuthorizer) current() *authConfig {
+	c, _ := h.config.Load().(*authConfig)
+	if c == nil {
+		return &authConfig{}
+	}
+
+	return c
 }
 
-// AuthenticateWrite Authenticates for write permissions
+// AuthenticateRead Authenticates for read permissions
+func (h *DefaultAuthorizer) AuthenticateRead(next http.Handler) http.Handler {
+	return h.authenticate(business.ScopeRead, next)
+}
+
+// AuthenticateWrite Authenticates for update permissions
 func (h *DefaultAuthorizer) AuthenticateWrite(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		if h.writeToken != "" {
-			token := h.getToken(req)
+	return h.authenticate(business.ScopeUpdate, next)
+}
 
-			if token != h.writeToken {
-				log.Debugf("Failed to authorize write %s", req.RemoteAddr)
-				res.WriteHeader(http.StatusUnauthorized)
-				return
-			}
-		}
+// AuthenticateDelete Authenticates for delete permissions
+func (h *DefaultAuthorizer) AuthenticateDelete(next http.Handler) http.Handler {
+	return h.authenticate(business.ScopeDelete, next)
+}
 
-		next.ServeHTTP(res, req)
-	})
+// AuthenticateAdmin Authenticates for managing tokens
+func (h *DefaultAuthorizer) AuthenticateAdmin(next http.Handler) http.Handler {
+	return h.authenticate(business.ScopeAdmin, next)
 }
 
 // AuthenticateCreate Authenticates for creating a new entry
-// where it can be possible to only authenticate when creating custom tiny URLs
+// where creating custom tiny URLs and generated tiny URLs require different scopes
 func (h *DefaultAuthorizer) AuthenticateCreate(next http.Handler) http.Handler {
+	custom := h.authenticate(business.ScopeCreateCustom, next)
+	generated := h.authenticate(business.ScopeCreateGenerated, next)
+
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		if h.writeToken != "" {
-			if !h.allowPublicCreateGenerated {
-				h.AuthenticateWrite(next).ServeHTTP(res, req)
-				return
-			}
+		err := req.ParseForm()
+		if err != nil {
+			log.Errorf("Failed to parse form data: %s", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if req.Form.Get("id") != "" {
+			custom.ServeHTTP(res, req)
+			return
+		}
+		generated.ServeHTTP(res, req)
+	})
+}
 
-			// If id form field has value check the token
-			token := h.getToken(req)
-			err := req.ParseForm()
-			if err != nil {
-				log.Error("Failed to parse form data: %s", err)
-				res.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			reqID := req.Form.Get("id")
-			if reqID != "" {
-				if token != h.writeToken {
-					log.Debugf("Failed to authorize create %s", req.RemoteAddr)
-					res.WriteHeader(http.StatusUnauthorized)
-					return
-				}
+// authenticate only passes requests to next when the scope is public
+// or the request has a token with the scope
+// The identity of the token is added to the request, see requestIdentity
+func (h *DefaultAuthorizer) authenticate(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		c := h.current()
+		identity, ok := h.identity(c, req)
+		if c.public(scope) {
+			if !ok {
+				identity = business.Identity{}
 			}
+			next.ServeHTTP(res, withIdentity(req, identity))
+			return
+		}
+
+		if !ok {
+			log.Debugf("Failed to authorize %s %s", scope, req.RemoteAddr)
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !identity.HasScope(scope) {
+			log.Debugf("Token %q of %s lacks scope %s", identity.Name, req.RemoteAddr, scope)
+			res.WriteHeader(http.StatusForbidden)
+			return
 		}
 
-		next.ServeHTTP(res, req)
+		next.ServeHTTP(res, withIdentity(req, identity))
 	})
 }
 
+// public returns true if the scope does not require a token
+func (c *authConfig) public(scope string) bool {
+	if scope == business.ScopeAdmin {
+		return false
+	}
+	if scope == business.ScopeCreateGenerated && c.allowPublicCreateGenerated {
+		return true
+	}
+	if c.adminToken.isSet() {
+		return false
+	}
+	if scope == business.ScopeRead {
+		return !c.readToken.isSet()
+	}
+
+	return !c.writeToken.isSet()
+}
+
+// identity returns the identity of the token in the request
+// returns false when the request has no valid token
+// Tokens with the format of stored tokens are only looked up in the backend,
+// so they never cost a slow hash check of the configured tokens
+func (h *DefaultAuthorizer) identity(c *authConfig, req *http.Request) (business.Identity, bool) {
+	token := h.getToken(req)
+	switch {
+	case token == "":
+		return business.Identity{}, false
+	case h.tokens != nil && business.IsStoredToken(token):
+		identity, err := h.tokens.AuthenticateToken(token)
+		return identity, err == nil
+	case c.adminToken.matches(token):
+		return business.Identity{Name: "admin", Scopes: []string{business.ScopeAdmin}}, true
+	case c.readToken.matches(token):
+		return business.Identity{Name: "read", Scopes: []string{business.ScopeRead}}, true
+	case c.writeToken.matches(token):
+		return business.Identity{Name: "write", Scopes: []string{
+			business.ScopeCreateGenerated, business.ScopeCreateCustom, business.ScopeUpdate, business.ScopeDelete,
+		}}, true
+	default:
+		return business.Identity{}, false
+	}
+}
+
 // getToken fetches the Bearer token in the Authorization header
 func (h *DefaultAuthorizer) getToken(req *http.Request) string {
 	token := ""
@@ -107,3 +244,22 @@ func (h *DefaultAuthorizer) getToken(req *http.Request) string {
 
 	return token
 }
+
+// identityKey is the request context key of the authenticated identity
+type identityKey struct{}
+
+// withIdentity returns the request with the authenticated identity
+func withIdentity(req *http.Request, identity business.Identity) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), identityKey{}, identity))
+}
+
+// requestIdentity returns the identity the request was authenticated with
+// Requests without a token, or authorized by another Authorizer, have an identity without token
+func requestIdentity(req *http.Request) *business.Identity {
+	identity, ok := req.Context().Value(identityKey{}).(business.Identity)
+	if !ok {
+		return &business.Identity{}
+	}
+
+	return &identity
+}
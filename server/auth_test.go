@@ -0,0 +1,161 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/chrisvdg/gotiny/business"
+	"github.com/stretchr/testify/assert"
+)
+
+// authRoutes are the scopes checked by the authorizer, create is split in custom and generated IDs
+var authRoutes = []string{"read", "update", "delete", "custom", "generated", "admin"}
+
+func Test_AuthorizerScopes(t *testing.T) {
+	const none, ok, unauthorized, forbidden = 0, http.StatusOK, http.StatusUnauthorized, http.StatusForbidden
+	for _, c := range []struct {
+		name                         string
+		readToken, writeToken, admin string
+		allowPublicCreate            bool
+		// codes maps the token of a request to the status codes of authRoutes
+		codes map[string][6]int
+	}{
+		{
+			name: "no tokens",
+			codes: map[string][6]int{
+				"":      {ok, ok, ok, ok, ok, unauthorized},
+				"wrong": {ok, ok, ok, ok, ok, unauthorized},
+			},
+		},
+		{
+			name:      "read and write tokens",
+			readToken: "read", writeToken: "write",
+			codes: map[string][6]int{
+				"":      {unauthorized, unauthorized, unauthorized, unauthorized, unauthorized, unauthorized},
+				"wrong": {unauthorized, unauthorized, unauthorized, unauthorized, unauthorized, unauthorized},
+				"read":  {ok, forbidden, forbidden, forbidden, forbidden, forbidden},
+				"write": {forbidden, ok, ok, ok, ok, forbidden},
+			},
+		},
+		{
+			name:       "write token with public create",
+			writeToken: "write", allowPublicCreate: true,
+			codes: map[string][6]int{
+				"":      {ok, unauthorized, unauthorized, unauthorized, ok, unauthorized},
+				"write": {ok, ok, ok, ok, ok, forbidden},
+			},
+		},
+		{
+			name:      "admin token",
+			readToken: "read", writeToken: "write", admin: "admin",
+			codes: map[string][6]int{
+				"":          {unauthorized, unauthorized, unauthorized, unauthorized, unauthorized, unauthorized},
+				"admin":     {ok, ok, ok, ok, ok, ok},
+				"read":      {ok, forbidden, forbidden, forbidden, forbidden, forbidden},
+				"write":     {forbidden, ok, ok, ok, ok, forbidden},
+				storedToken: {ok, forbidden, forbidden, forbidden, forbidden, forbidden},
+			},
+		},
+		{
+			name:  "only admin token",
+			admin: "admin",
+			codes: map[string][6]int{
+				"":      {unauthorized, unauthorized, unauthorized, unauthorized, unauthorized, unauthorized},
+				"admin": {ok, ok, ok, ok, ok, ok},
+			},
+		},
+		{
+			name:  "admin token with public create",
+			admin: "admin", allowPublicCreate: true,
+			codes: map[string][6]int{
+				"":          {unauthorized, unauthorized, unauthorized, unauthorized, ok, unauthorized},
+				storedToken: {ok, forbidden, forbidden, forbidden, ok, forbidden},
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			tokens := &fakeTokens{identity: business.Identity{TokenID: "AbCd-_12", Scopes: []string{business.ScopeRead}}}
+			auth, err := NewAuthorizerWithOptions(c.readToken, c.writeToken, c.allowPublicCreate, AuthorizerOptions{
+				AdminToken: c.admin,
+				Tokens:     tokens,
+			})
+			assert.NoError(t, err)
+			handlers := authorizedHandlers(auth)
+
+			for token, codes := range c.codes {
+				for i, route := range authRoutes {
+					if codes[i] == none {
+						continue
+					}
+					res := serve(handlers[route], authRequest(route, token))
+					assert.Equal(t, codes[i], res.Code, "%s with token %q", route, token)
+				}
+			}
+		})
+	}
+}
+
+func Test_AuthorizerIdentity(t *testing.T) {
+	assert := assert.New(t)
+	tokens := &fakeTokens{identity: business.Identity{TokenID: "AbCd-_12", Name: "ci", Scopes: []string{business.ScopeRead}}}
+	auth, err := NewAuthorizerWithOptions("read", "", false, AuthorizerOptions{AdminToken: "admin", Tokens: tokens})
+	assert.NoError(err)
+
+	var identity *business.Identity
+	handler := auth.AuthenticateRead(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		identity = requestIdentity(req)
+	}))
+	for token, expected := range map[string]business.Identity{
+		"admin":     {Name: "admin", Scopes: []string{business.ScopeAdmin}},
+		"read":      {Name: "read", Scopes: []string{business.ScopeRead}},
+		storedToken: tokens.identity,
+	} {
+		res := serve(handler, authRequest("read", token))
+		assert.Equal(http.StatusOK, res.Code)
+		if assert.NotNil(identity) {
+			assert.Equal(expected, *identity, token)
+		}
+	}
+}
+
+func Test_AuthorizerSetTokens(t *testing.T) {
+	assert := assert.New(t)
+	auth, err := NewAuthorizer("read", "write", false)
+	assert.NoError(err)
+	handler := auth.AuthenticateRead(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {}))
+
+	assert.NoError(auth.SetTokens("new", "write", "", false))
+	assert.Equal(http.StatusUnauthorized, serve(handler, authRequest("read", "read")).Code)
+	assert.Equal(http.StatusOK, serve(handler, authRequest("read", "new")).Code)
+
+	assert.Error(auth.SetTokens("$2a$10$invalid", "write", "", false))
+	assert.Equal(http.StatusOK, serve(handler, authRequest("read", "new")).Code, "Invalid tokens should keep the current tokens")
+}
+
+// authorizedHandlers returns a handler per route of authRoutes that responds with 200 when authorized
+func authorizedHandlers(auth Authorizer) map[string]http.Handler {
+	ok := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {})
+	create := auth.AuthenticateCreate(ok)
+
+	return map[string]http.Handler{
+		"read":      auth.AuthenticateRead(ok),
+		"update":    auth.AuthenticateWrite(ok),
+		"delete":    auth.AuthenticateDelete(ok),
+		"custom":    create,
+		"generated": create,
+		"admin":     auth.AuthenticateAdmin(ok),
+	}
+}
+
+// authRequest returns a request of the route of authRoutes with the token
+func authRequest(route string, token string) *http.Request {
+	switch route {
+	case "custom":
+		return testRequest("POST", "/api/tiny", token, url.Values{"id": {"foo"}, "url": {"http://foo.bar"}})
+	case "generated":
+		return testRequest("POST", "/api/tiny", token, url.Values{"url": {"http://foo.bar"}})
+	default:
+		return testRequest("GET", "/", token, nil)
+	}
+}
@@ -1,22 +1,83 @@
 package server
 
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chrisvdg/gotiny/business"
+	"github.com/chrisvdg/gotiny/ratelimit"
+)
+
 // Config represents a server config
 type Config struct {
 	ListenAddr                 string
 	TLSListenAddr              string
 	TLS                        *TLSConfig
-	TLSOnly                    bool
+	ACME                       *ACMEConfig   // Obtains the TLS certificate through ACME instead of TLS files when it has domains
+	TLSOnly                    bool          // Only serves on TLSListenAddr
+	HTTPSRedirect              bool          // The http listener only redirects to the https listener, apart from ACME challenges
+	HSTS                       string        // Strict-Transport-Security header of https responses (e.g. "max-age=31536000"), empty disables it
+	ShutdownTimeout            time.Duration // Duration requests in progress are drained for on shutdown, DefaultShutdownTimeout is used when 0
 	ReadAuthToken              string
 	WriteAuthToken             string
-	AllowPublicCreateGenerated bool // If true, WriteAuthToken is NOT required when creating an entry that does not contain a custom ID
+	AdminAuthToken             string // Grants every scope and managing stored tokens, when set every operation requires a token
+	AllowPublicCreateGenerated bool   // If true, WriteAuthToken is NOT required when creating an entry that does not contain a custom ID
 	GeneratedIDLen             int
+	DefaultRedirect            int // Redirect status code of entries without one, business.DefaultRedirect is used when 0
 	Verbose                    bool
 
+	// Rate limit settings, requests with a token are limited per token and other requests per client IP
+	// The zero limit does not limit the routes
+	CreateRateLimit   ratelimit.Limit
+	WriteRateLimit    ratelimit.Limit // Updating and removing entries and managing tokens
+	ReadRateLimit     ratelimit.Limit // Listing, expanding and statistics of entries
+	RedirectRateLimit ratelimit.Limit // Following and unlocking entries
+	AuthRateLimit     ratelimit.Limit // Routes that require a token per client IP, before the token is checked
+	TrustedProxies    []string        // IPs or CIDR ranges of proxies of which the X-Forwarded-For and X-Real-IP headers are used
+
+	// Policy settings
+	PolicyFile           string        // JSON file with the rules of destination URLs and IDs, see policy.Policy
+	PolicyReloadInterval time.Duration // Interval at which the policy file is checked for changes, 0 disables reloading
+
 	// General backend settings
+	Backend    string // Backend implementation to use, defaults to FileBackend
 	PrettyJSON bool
 
+	// Expiry settings
+	ExpiryReapInterval time.Duration // Interval at which expired entries are removed, 0 disables removal
+	ExpiryGracePeriod  time.Duration // Duration expired entries are kept before they are removed
+
+	// Analytics settings
+	DisableStats bool   // Disables recording hits of followed entries
+	StatsFile    string // File to store the statistics in, they are kept in memory only when empty
+	StatsSalt    string // Salt of the client IP hashes, a random salt is used when empty
+	// Hits of bots and prefetches are counted separately, or not at all when StatsSkipBots is set
+	StatsSkipBots      bool
+	StatsBotUserAgents []string // User agent substrings of bots, analytics.DefaultBotUserAgents is used when empty
+
 	// File backend settings
-	FileBackendPath string
+	FileBackendPath   string
+	FileBackendBackup bool // Keep the previous generation of the backend file to recover from corruption
+
+	// Journal backend settings
+	JournalBackendPath string
+	JournalCompactSize int64 // Journal size in bytes after which it is compacted into a snapshot
+
+	// Bolt backend settings
+	BoltBackendPath string
+
+	// SQL backend settings
+	SQLDriver string // Name of the registered database/sql driver
+	SQLDSN    string
+
+	// Redis backend settings
+	RedisURL       string // redis://[:password@]host[:port][/database]
+	RedisKeyPrefix string
 }
 
 // TLSConfig represents a TLS configuration
@@ -24,3 +85,130 @@ type TLSConfig struct {
 	KeyFile  string
 	CertFile string
 }
+
+// ACMEConfig represents the settings of obtaining and renewing TLS certificates through ACME
+type ACMEConfig struct {
+	Domains      []string
+	CacheDir     string // Directory to store the account key and certificates in
+	DirectoryURL string // Directory URL of the ACME CA, acmecert.DefaultDirectoryURL is used when empty
+	Email        string // Contact address of the ACME account, optional
+}
+
+// tlsFiles returns true if TLS is configured with certificate and key files
+func (c *Config) tlsFiles() bool {
+	return c.TLS != nil && (c.TLS.CertFile != "" || c.TLS.KeyFile != "")
+}
+
+// acme returns true if TLS is configured through ACME
+func (c *Config) acme() bool {
+	return c.ACME != nil && len(c.ACME.Domains) > 0
+}
+
+// Validate returns an error describing the first invalid setting of the config
+func (c *Config) Validate() error {
+	if c.tlsFiles() && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+		return fmt.Errorf("TLS requires both a certificate and a key file")
+	}
+	if c.tlsFiles() && c.acme() {
+		return fmt.Errorf("TLS certificate files can not be combined with ACME")
+	}
+	if c.acme() && c.ACME.DirectoryURL != "" {
+		u, err := url.Parse(c.ACME.DirectoryURL)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			return fmt.Errorf("invalid ACME directory URL %q", c.ACME.DirectoryURL)
+		}
+	}
+	tlsEnabled := c.tlsFiles() || c.acme()
+	if c.TLSOnly && !tlsEnabled {
+		return fmt.Errorf("TLS only requires a TLS certificate and key file or ACME")
+	}
+	if c.HTTPSRedirect && (!tlsEnabled || c.TLSOnly) {
+		return fmt.Errorf("redirecting to HTTPS requires TLS and the http listener")
+	}
+	if c.HSTS != "" {
+		if !tlsEnabled {
+			return fmt.Errorf("HSTS requires TLS")
+		}
+		if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(c.HSTS)), "max-age=") {
+			return fmt.Errorf("invalid HSTS header %q, expected max-age=<seconds> with optional directives such as includeSubDomains", c.HSTS)
+		}
+	}
+	if !c.TLSOnly && c.ListenAddr != "" {
+		err := validateAddr(c.ListenAddr)
+		if err != nil {
+			return fmt.Errorf("invalid listen address: %s", err)
+		}
+	}
+	if tlsEnabled {
+		err := validateAddr(c.TLSListenAddr)
+		if err != nil {
+			return fmt.Errorf("invalid TLS listen address: %s", err)
+		}
+	}
+
+	switch c.Backend {
+	case "", FileBackend, JournalBackend, BoltBackend, MemoryBackend:
+	case SQLBackend:
+		if c.SQLDSN == "" {
+			return fmt.Errorf("the sql backend requires a data source name")
+		}
+		if !registeredDriver(c.SQLDriver) {
+			return fmt.Errorf("sql driver %q is not registered, expected one of %s", c.SQLDriver, strings.Join(sql.Drivers(), ", "))
+		}
+	case RedisBackend:
+		if c.RedisURL == "" {
+			return fmt.Errorf("the redis backend requires a server URL")
+		}
+	default:
+		return fmt.Errorf("unknown backend %q, expected one of file, journal, memory, bolt, sql or redis", c.Backend)
+	}
+
+	if c.GeneratedIDLen <= 0 {
+		return fmt.Errorf("invalid generated ID length %d, it should be positive", c.GeneratedIDLen)
+	}
+	if c.DefaultRedirect != 0 && !business.ValidRedirect(c.DefaultRedirect) {
+		return fmt.Errorf("invalid default redirect %d, expected 301, 302, 307 or 308", c.DefaultRedirect)
+	}
+	for name, d := range map[string]time.Duration{
+		"shutdown timeout":       c.ShutdownTimeout,
+		"expiry reap interval":   c.ExpiryReapInterval,
+		"expiry grace period":    c.ExpiryGracePeriod,
+		"policy reload interval": c.PolicyReloadInterval,
+	} {
+		if d < 0 {
+			return fmt.Errorf("invalid %s %s, it can not be negative", name, d)
+		}
+	}
+	_, err := parseTrustedProxies(c.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateAddr returns an error if the address is not a host:port listen address
+func validateAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%q should be host:port or :port (e.g. \":8080\"): %s", addr, err)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		if _, err := net.LookupPort("tcp", port); err != nil {
+			return fmt.Errorf("%q has an invalid port %q", addr, port)
+		}
+	}
+
+	return nil
+}
+
+// registeredDriver returns true if the database/sql driver is registered, only the drivers imported by the binary are
+func registeredDriver(driver string) bool {
+	for _, d := range sql.Drivers() {
+		if d == driver {
+			return true
+		}
+	}
+
+	return false
+}
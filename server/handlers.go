@@ -2,8 +2,10 @@ package server
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 
+	"github.com/chrisvdg/gotiny/analytics"
 	"github.com/chrisvdg/gotiny/business"
 	"github.com/gorilla/mux"
 )
@@ -16,9 +18,14 @@ type Handlers interface {
 	List(http.ResponseWriter, *http.Request)
 	CreateTinyURL(http.ResponseWriter, *http.Request)
 	FollowURL(http.ResponseWriter, *http.Request)
+	UnlockURL(http.ResponseWriter, *http.Request)
 	UpdateTinyURL(http.ResponseWriter, *http.Request)
 	ExpandURL(http.ResponseWriter, *http.Request)
 	RemoveTinyURL(http.ResponseWriter, *http.Request)
+	Stats(http.ResponseWriter, *http.Request)
+	ListTokens(http.ResponseWriter, *http.Request)
+	CreateToken(http.ResponseWriter, *http.Request)
+	RevokeToken(http.ResponseWriter, *http.Request)
 }
 
 // NewDefaultHandlers creates a new Default handlers instance with provided logic instance
@@ -39,9 +46,10 @@ func (h *DefaultHandlers) APISpec(res http.ResponseWriter, req *http.Request) {
 	http.ServeFile(res, req, apiSpecFile)
 }
 
-// List Lists all tiny URL entries
+// List Lists all tiny URL entries the token owns
+// optionally only the entries of which the ID starts with the prefix query parameter
 func (h *DefaultHandlers) List(res http.ResponseWriter, req *http.Request) {
-	data, err := h.b.List()
+	data, err := h.b.ListPrefixAs(requestIdentity(req), req.URL.Query().Get("prefix"))
 	if err != nil {
 		writeError(res, req, err)
 		return
@@ -59,7 +67,7 @@ func (h *DefaultHandlers) CreateTinyURL(res http.ResponseWriter, req *http.Reque
 	}
 	id := req.Form.Get("id")
 	url := req.Form.Get("url")
-	data, err := h.b.Create(id, url)
+	data, err := h.b.CreateWithOptions(id, url, entryOptions(req))
 	if err != nil {
 		writeErrorWithValidationCheck(res, req, err)
 		return
@@ -71,13 +79,47 @@ func (h *DefaultHandlers) CreateTinyURL(res http.ResponseWriter, req *http.Reque
 // FollowURL Get redirected to full URL
 func (h *DefaultHandlers) FollowURL(res http.ResponseWriter, req *http.Request) {
 	id := mux.Vars(req)["id"]
-	url, err := h.b.GetURL(id)
+	url, code, err := h.b.GetRedirect(id)
+	if err == business.ErrPasswordRequired {
+		writeUnlockPage(res, http.StatusUnauthorized, unlockPage{ID: id})
+		return
+	}
+	if err != nil {
+		writeError(res, req, err)
+		return
+	}
+	h.b.RecordHit(analytics.NewHit(id, req))
+
+	http.Redirect(res, req, url, code)
+}
+
+// UnlockURL Get redirected to the full URL of a password protected entry
+func (h *DefaultHandlers) UnlockURL(res http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	err := req.ParseForm()
+	if err != nil {
+		writeError(res, req, fmt.Errorf("Failed to parse form data: %s", err))
+		return
+	}
+
+	url, err := h.b.Unlock(id, req.PostForm.Get("password"), clientIP(req))
+	if err == business.ErrWrongPassword {
+		writeUnlockPage(res, http.StatusUnauthorized, unlockPage{ID: id, Error: "Wrong password, try again."})
+		return
+	}
+	if tooMany, ok := err.(*business.TooManyAttemptsError); ok {
+		res.Header().Set("Retry-After", retryAfter(tooMany.RetryAfter))
+		writeUnlockPage(res, http.StatusTooManyRequests, unlockPage{ID: id, Error: "Too many failed attempts, try again later."})
+		return
+	}
 	if err != nil {
 		writeError(res, req, err)
 		return
 	}
+	h.b.RecordHit(analytics.NewHit(id, req))
 
-	http.Redirect(res, req, url, 301)
+	// See Other makes the browser follow with a GET, so the password is not posted to the URL
+	http.Redirect(res, req, url, http.StatusSeeOther)
 }
 
 // UpdateTinyURL Update a tiny URL entry
@@ -90,7 +132,7 @@ func (h *DefaultHandlers) UpdateTinyURL(res http.ResponseWriter, req *http.Reque
 	}
 	url := req.Form.Get("url")
 
-	err = h.b.Update(id, url)
+	err = h.b.UpdateWithOptions(id, url, entryOptions(req))
 	if err != nil {
 		writeErrorWithValidationCheck(res, req, err)
 		return
@@ -112,11 +154,24 @@ func (h *DefaultHandlers) ExpandURL(res http.ResponseWriter, req *http.Request)
 	writeJSONResp(res, data)
 }
 
+// Stats Get the statistics of the tiny URL ID entry
+func (h *DefaultHandlers) Stats(res http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	data, err := h.b.Stats(id)
+	if err != nil {
+		writeError(res, req, err)
+		return
+	}
+
+	writeJSONResp(res, data)
+}
+
 // RemoveTinyURL Remove a tiny URL entry
 func (h *DefaultHandlers) RemoveTinyURL(res http.ResponseWriter, req *http.Request) {
 	id := mux.Vars(req)["id"]
 
-	err := h.b.Delete(id)
+	err := h.b.DeleteAs(requestIdentity(req), id)
 	if err != nil {
 		writeError(res, req, err)
 		return
@@ -125,6 +180,70 @@ func (h *DefaultHandlers) RemoveTinyURL(res http.ResponseWriter, req *http.Reque
 	res.WriteHeader(http.StatusNoContent)
 }
 
+// ListTokens Lists the stored tokens
+func (h *DefaultHandlers) ListTokens(res http.ResponseWriter, req *http.Request) {
+	data, err := h.b.ListTokens()
+	if err != nil {
+		writeError(res, req, err)
+		return
+	}
+
+	writeJSONResp(res, data)
+}
+
+// CreateToken Create a new token with the name and scopes of the form
+// Scopes are provided as repeated scope fields or comma separated
+func (h *DefaultHandlers) CreateToken(res http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		writeError(res, req, fmt.Errorf("Failed to parse form data: %s", err))
+		return
+	}
+
+	data, err := h.b.CreateToken(req.Form.Get("name"), req.Form["scope"])
+	if err != nil {
+		writeErrorWithValidationCheck(res, req, err)
+		return
+	}
+
+	writeJSONResp(res, data)
+}
+
+// RevokeToken Revoke a stored token
+func (h *DefaultHandlers) RevokeToken(res http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+
+	err := h.b.RevokeToken(id)
+	if err != nil {
+		writeError(res, req, err)
+		return
+	}
+
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// entryOptions returns the entry options of a parsed create or update request
+func entryOptions(req *http.Request) business.EntryOptions {
+	return business.EntryOptions{
+		TTL:            req.Form.Get("ttl"),
+		Expires:        req.Form.Get("expires"),
+		Redirect:       req.Form.Get("redirect"),
+		Password:       req.Form.Get("password"),
+		RemovePassword: req.Form.Get("removepassword") == "true",
+		Caller:         requestIdentity(req),
+	}
+}
+
+// clientIP returns the IP of the client that sent the request
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
 func writeJSONResp(res http.ResponseWriter, data []byte) {
 	res.WriteHeader(http.StatusOK)
 	res.Header().Set("Content-Type", "application/json")
@@ -136,6 +255,21 @@ func writeJSONResp(res http.ResponseWriter, data []byte) {
 func writeError(res http.ResponseWriter, req *http.Request, err error) {
 	if err == business.ErrTinyURLNotFound {
 		http.NotFound(res, req)
+	} else if err == business.ErrTokenNotFound {
+		res.WriteHeader(http.StatusNotFound)
+		res.Write([]byte(err.Error()))
+	} else if err == business.ErrNotOwner {
+		res.WriteHeader(http.StatusForbidden)
+		res.Write([]byte(err.Error()))
+	} else if err == business.ErrTokensUnsupported || err == business.ErrOptionsUnsupported {
+		res.WriteHeader(http.StatusNotImplemented)
+		res.Write([]byte(err.Error()))
+	} else if err == business.ErrAnalyticsDisabled {
+		res.WriteHeader(http.StatusNotFound)
+		res.Write([]byte(err.Error()))
+	} else if err == business.ErrTinyURLExpired {
+		res.WriteHeader(http.StatusGone)
+		res.Write([]byte(err.Error()))
 	} else {
 		res.WriteHeader(http.StatusInternalServerError)
 		res.Write([]byte(err.Error()))
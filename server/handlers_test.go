@@ -0,0 +1,243 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chrisvdg/gotiny/analytics"
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/chrisvdg/gotiny/business"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_PublicRoutes(t *testing.T) {
+	assert := assert.New(t)
+	l := newTestLogic()
+	r := newTestRouter(t, &Server{cfg: &Config{ReadAuthToken: "read", WriteAuthToken: "write", AdminAuthToken: "admin"}}, l)
+	_, err := l.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+
+	res := serve(r, testRequest("GET", "/api/tiny/foo", "", nil))
+	assert.Equal(http.StatusFound, res.Code, "Following should not require a token")
+	res = serve(r, testRequest("POST", "/api/tiny/foo/unlock", "", url.Values{"password": {"secret"}}))
+	assert.NotEqual(http.StatusUnauthorized, res.Code, "Unlocking should not require a token")
+	for _, req := range []*http.Request{
+		testRequest("GET", "/api/tiny", "", nil),
+		testRequest("POST", "/api/tiny", "", url.Values{"url": {"http://foo.bar"}}),
+		testRequest("POST", "/api/tiny/foo", "", url.Values{"url": {"http://lorem.ipsum"}}),
+		testRequest("DELETE", "/api/tiny/foo", "", nil),
+		testRequest("GET", "/api/tiny/foo/expand", "", nil),
+		testRequest("GET", "/api/tiny/foo/stats", "", nil),
+		testRequest("GET", "/api/admin/tokens", "", nil),
+		testRequest("POST", "/api/admin/tokens", "", url.Values{"name": {"ci"}, "scope": {"read"}}),
+		testRequest("DELETE", "/api/admin/tokens/foo", "", nil),
+	} {
+		res = serve(r, req)
+		assert.Equal(http.StatusUnauthorized, res.Code, "%s %s", req.Method, req.URL.Path)
+	}
+}
+
+// Test_Stats tests that following records hits, which are returned by the statistics route
+func Test_Stats(t *testing.T) {
+	assert := assert.New(t)
+	l := newTestLogic()
+	l.EnableAnalytics(analytics.NewMemoryStore(), analytics.RecorderOptions{FlushInterval: 10 * time.Millisecond})
+	defer l.Close()
+	r := newTestRouter(t, &Server{cfg: &Config{ReadAuthToken: "read", WriteAuthToken: "write"}}, l)
+	_, err := l.Create("foo", "http://foo.bar")
+	assert.NoError(err)
+
+	req := testRequest("GET", "/api/tiny/foo", "", nil)
+	req.Header.Set("Referer", "https://Example.com/page")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64)")
+	assert.Equal(http.StatusFound, serve(r, req).Code)
+
+	res := serve(r, testRequest("GET", "/api/tiny/foo/stats", "write", nil))
+	assert.Equal(http.StatusForbidden, res.Code, "Statistics should require the read scope")
+	var stats analytics.Stats
+	assert.Eventually(func() bool {
+		res = serve(r, testRequest("GET", "/api/tiny/foo/stats", "read", nil))
+		return res.Code == http.StatusOK && json.Unmarshal(res.Body.Bytes(), &stats) == nil && stats.Total == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(map[string]int{"example.com": 1}, stats.Referrers)
+	assert.Equal(map[string]int{analytics.UAClassDesktop: 1}, stats.UserAgents)
+	if assert.Len(stats.Days, 1) {
+		assert.Equal(1, stats.Days[0].Visitors)
+	}
+
+	res = serve(r, testRequest("GET", "/api/tiny/missing/stats", "read", nil))
+	assert.Equal(http.StatusNotFound, res.Code)
+	r = newTestRouter(t, &Server{cfg: &Config{}}, newTestLogic())
+	res = serve(r, testRequest("GET", "/api/tiny/foo/stats", "", nil))
+	assert.Equal(http.StatusNotFound, res.Code, "Statistics should not be found when analytics are disabled")
+}
+
+func Test_RedirectStatus(t *testing.T) {
+	assert := assert.New(t)
+	r := newTestRouter(t, &Server{cfg: &Config{}}, newTestLogic())
+
+	res := serve(r, testRequest("POST", "/api/tiny", "", url.Values{"id": {"default"}, "url": {"http://foo.bar"}}))
+	assert.Equal(http.StatusOK, res.Code)
+	res = serve(r, testRequest("POST", "/api/tiny", "", url.Values{"id": {"permanent"}, "url": {"http://foo.bar"}, "redirect": {"308"}}))
+	assert.Equal(http.StatusOK, res.Code)
+	res = serve(r, testRequest("POST", "/api/tiny", "", url.Values{"id": {"invalid"}, "url": {"http://foo.bar"}, "redirect": {"200"}}))
+	assert.Equal(http.StatusBadRequest, res.Code)
+
+	res = serve(r, testRequest("GET", "/api/tiny/default", "", nil))
+	assert.Equal(business.DefaultRedirect, res.Code, "New entries should redirect temporarily by default")
+	assert.Equal("http://foo.bar", res.Header().Get("Location"))
+	res = serve(r, testRequest("GET", "/api/tiny/permanent", "", nil))
+	assert.Equal(http.StatusPermanentRedirect, res.Code)
+
+	res = serve(r, testRequest("POST", "/api/tiny/permanent", "", url.Values{"redirect": {"307"}}))
+	assert.Equal(http.StatusNoContent, res.Code)
+	res = serve(r, testRequest("GET", "/api/tiny/permanent", "", nil))
+	assert.Equal(http.StatusTemporaryRedirect, res.Code)
+	assert.Equal("http://foo.bar", res.Header().Get("Location"), "Updating the redirect should keep the URL")
+}
+
+func Test_PasswordProtected(t *testing.T) {
+	assert := assert.New(t)
+	r := newTestRouter(t, &Server{cfg: &Config{}}, newTestLogic())
+
+	res := serve(r, testRequest("POST", "/api/tiny", "", url.Values{"id": {"doc"}, "url": {"http://foo.bar"}, "password": {"secret"}}))
+	assert.Equal(http.StatusOK, res.Code)
+
+	res = serve(r, testRequest("GET", "/api/tiny/doc", "", nil))
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Empty(res.Header().Get("Location"))
+	assert.Contains(res.Body.String(), `action="/api/tiny/doc/unlock"`)
+
+	res = serve(r, testRequest("POST", "/api/tiny/doc/unlock", "", url.Values{"password": {"wrong"}}))
+	assert.Equal(http.StatusUnauthorized, res.Code)
+	assert.Empty(res.Header().Get("Location"))
+	res = serve(r, testRequest("POST", "/api/tiny/doc/unlock", "", url.Values{"password": {"secret"}}))
+	assert.Equal(http.StatusSeeOther, res.Code)
+	assert.Equal("http://foo.bar", res.Header().Get("Location"))
+
+	// Neither expand nor list reveal the URL or the hash
+	res = serve(r, testRequest("GET", "/api/tiny/doc/expand", "", nil))
+	assert.Equal(http.StatusOK, res.Code)
+	assert.NotContains(res.Body.String(), "http://foo.bar")
+	assert.NotContains(strings.ToLower(res.Body.String()), "password")
+	assert.NotContains(res.Body.String(), "$2")
+	res = serve(r, testRequest("GET", "/api/tiny", "", nil))
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Contains(res.Body.String(), `"doc"`)
+	assert.NotContains(res.Body.String(), "http://foo.bar")
+	assert.NotContains(res.Body.String(), "$2")
+
+	// Failed attempts are limited per client
+	for i := 0; i < business.UnlockAttempts; i++ {
+		serve(r, testRequest("POST", "/api/tiny/doc/unlock", "", url.Values{"password": {"wrong"}}))
+	}
+	res = serve(r, testRequest("POST", "/api/tiny/doc/unlock", "", url.Values{"password": {"secret"}}))
+	assert.Equal(http.StatusTooManyRequests, res.Code)
+	assert.NotEmpty(res.Header().Get("Retry-After"))
+	req := testRequest("POST", "/api/tiny/doc/unlock", "", url.Values{"password": {"secret"}})
+	req.RemoteAddr = "192.0.2.2:1234"
+	assert.Equal(http.StatusSeeOther, serve(r, req).Code, "Other clients should not be limited")
+}
+
+func Test_Ownership(t *testing.T) {
+	assert := assert.New(t)
+	l := newTestLogic()
+	l.SetEnforceOwnership(true)
+	r := newTestRouter(t, &Server{cfg: &Config{ReadAuthToken: "read", AdminAuthToken: "admin"}}, l)
+	scopes := []string{business.ScopeRead, business.ScopeCreateCustom, business.ScopeUpdate, business.ScopeDelete}
+	alice, bob := createTestToken(t, l, "alice", scopes), createTestToken(t, l, "bob", scopes)
+
+	res := serve(r, testRequest("POST", "/api/tiny", alice, url.Values{"id": {"foo"}, "url": {"http://foo.bar"}}))
+	assert.Equal(http.StatusOK, res.Code)
+
+	res = serve(r, testRequest("POST", "/api/tiny/foo", bob, url.Values{"url": {"http://lorem.ipsum"}}))
+	assert.Equal(http.StatusForbidden, res.Code, "Only the owner should update an entry")
+	res = serve(r, testRequest("DELETE", "/api/tiny/foo", bob, nil))
+	assert.Equal(http.StatusForbidden, res.Code, "Only the owner should remove an entry")
+	for _, token := range []string{bob, "read", "admin"} {
+		res = serve(r, testRequest("GET", "/api/tiny", token, nil))
+		assert.Equal(http.StatusOK, res.Code)
+		assert.Contains(res.Body.String(), `"foo"`, "The read scope should list entries of every owner")
+	}
+
+	res = serve(r, testRequest("POST", "/api/tiny/foo", alice, url.Values{"url": {"http://lorem.ipsum"}}))
+	assert.Equal(http.StatusNoContent, res.Code)
+	res = serve(r, testRequest("DELETE", "/api/tiny/foo", "admin", nil))
+	assert.Equal(http.StatusNoContent, res.Code, "The admin token should manage every entry")
+}
+
+// newTestLogic returns the business logic of a memory backend
+func newTestLogic() *business.Logic {
+	return business.NewLogic(backend.NewMemory(), false, 5)
+}
+
+// newTestRouter returns a router with the API routes of the server on top of the logic,
+// the logic authenticates stored tokens
+func newTestRouter(t *testing.T, s *Server, l *business.Logic) *mux.Router {
+	h, err := NewDefaultHandlers(l)
+	if err != nil {
+		t.Fatalf("Failed to create handlers: %s", err)
+	}
+	cfg := s.config()
+	auth, err := NewAuthorizerWithOptions(cfg.ReadAuthToken, cfg.WriteAuthToken, cfg.AllowPublicCreateGenerated, AuthorizerOptions{
+		AdminToken: cfg.AdminAuthToken,
+		Tokens:     l,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create authorizer: %s", err)
+	}
+	r := mux.NewRouter()
+	err = s.AddAPIRoutesAndHandlers(r, h, auth)
+	if err != nil {
+		t.Fatalf("Failed to add routes: %s", err)
+	}
+
+	return r
+}
+
+// createTestToken stores a token with the scopes and returns it
+func createTestToken(t *testing.T, l *business.Logic, name string, scopes []string) string {
+	data, err := l.CreateToken(name, scopes)
+	if err != nil {
+		t.Fatalf("Failed to create token: %s", err)
+	}
+	var created struct {
+		Token string `json:"token"`
+	}
+	err = json.Unmarshal(data, &created)
+	if err != nil {
+		t.Fatalf("Failed to parse token: %s", err)
+	}
+
+	return created.Token
+}
+
+// testRequest returns a request with the bearer token and the form as body, when they are set
+func testRequest(method string, target string, token string, form url.Values) *http.Request {
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, target, nil)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req
+}
+
+// serve serves the request with the handler and returns the recorded response
+func serve(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	return res
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chrisvdg/gotiny/business"
+	"github.com/chrisvdg/gotiny/ratelimit"
+	log "github.com/sirupsen/logrus"
+)
+
+// rateLimiters contains the rate limiters of the API routes, nil for routes that are not limited
+type rateLimiters struct {
+	create   *ratelimit.Limiter
+	write    *ratelimit.Limiter
+	read     *ratelimit.Limiter
+	redirect *ratelimit.Limiter
+	// auth limits the routes that require a token per client IP before the token is checked
+	auth *ratelimit.Limiter
+}
+
+// newRateLimiters creates the rate limiters of the API routes from the config
+func (s *Server) newRateLimiters() rateLimiters {
+	cfg := s.config()
+	if cfg == nil {
+		return rateLimiters{}
+	}
+
+	return rateLimiters{
+		create:   newRateLimiter(cfg.CreateRateLimit),
+		write:    newRateLimiter(cfg.WriteRateLimit),
+		read:     newRateLimiter(cfg.ReadRateLimit),
+		redirect: newRateLimiter(cfg.RedirectRateLimit),
+		auth:     newRateLimiter(cfg.AuthRateLimit),
+	}
+}
+
+// newRateLimiter returns a limiter of the provided limit or nil when it does not limit requests
+func newRateLimiter(limit ratelimit.Limit) *ratelimit.Limiter {
+	if limit.IsZero() {
+		return nil
+	}
+
+	return ratelimit.NewLimiter(limit)
+}
+
+// rateLimit only passes requests to next while the limiter allows the token or client of the request,
+// other requests get a 429 response with a Retry-After header
+// It should be wrapped by the authorizer so requests are limited per token
+func rateLimit(limiter *ratelimit.Limiter, next http.Handler) http.Handler {
+	return rateLimitBy(limiter, rateLimitKey, next)
+}
+
+// clientRateLimit is rateLimit per client IP, so it can wrap the authorizer
+// and limit requests before their token is checked
+func clientRateLimit(limiter *ratelimit.Limiter, next http.Handler) http.Handler {
+	return rateLimitBy(limiter, func(req *http.Request) string {
+		return "ip:" + clientIP(req)
+	}, next)
+}
+
+// rateLimitBy only passes requests to next while the limiter allows the key of the request
+func rateLimitBy(limiter *ratelimit.Limiter, key func(req *http.Request) string, next http.Handler) http.Handler {
+	if limiter == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		key := key(req)
+		ok, wait := limiter.Allow(key)
+		if !ok {
+			log.Debugf("Rate limited %s %s", key, req.URL.Path)
+			res.Header().Set("Retry-After", retryAfter(wait))
+			res.WriteHeader(http.StatusTooManyRequests)
+			res.Write([]byte("too many requests"))
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+// rateLimitKey returns the key requests are limited by,
+// the owner of the token when the request was authenticated and the client IP otherwise
+func rateLimitKey(req *http.Request) string {
+	owner := requestIdentity(req).Owner()
+	if owner != business.PublicOwner {
+		return owner
+	}
+
+	return "ip:" + clientIP(req)
+}
+
+// retryAfter returns the Retry-After header value of the provided wait time in whole seconds
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// parseTrustedProxies parses the IPs and CIDR ranges of trusted proxies
+func parseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", value)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			value = fmt.Sprintf("%s/%d", value, bits)
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %s", value, err)
+		}
+		result = append(result, network)
+	}
+
+	return result, nil
+}
+
+// realIP replaces the remote address of requests sent by a trusted proxy with the client address
+// of the X-Forwarded-For header, skipping trusted proxies from the right, or the X-Real-IP header
+func realIP(trusted []*net.IPNet, next http.Handler) http.Handler {
+	if len(trusted) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if isTrusted(trusted, clientIP(req)) {
+			if ip := forwardedIP(trusted, req); ip != "" {
+				req.RemoteAddr = net.JoinHostPort(ip, "0")
+			}
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+// forwardedIP returns the client address in the proxy headers of the request, empty when there is none
+func forwardedIP(trusted []*net.IPNet, req *http.Request) string {
+	var forwarded []string
+	for _, header := range req.Header["X-Forwarded-For"] {
+		for _, ip := range strings.Split(header, ",") {
+			ip = strings.TrimSpace(ip)
+			if net.ParseIP(ip) != nil {
+				forwarded = append(forwarded, ip)
+			}
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if !isTrusted(trusted, forwarded[i]) || i == 0 {
+			return forwarded[i]
+		}
+	}
+
+	ip := strings.TrimSpace(req.Header.Get("X-Real-IP"))
+	if net.ParseIP(ip) != nil {
+		return ip
+	}
+
+	return ""
+}
+
+// isTrusted returns true if the ip is in one of the trusted networks
+func isTrusted(trusted []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chrisvdg/gotiny/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AuthRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	s := &Server{cfg: &Config{
+		WriteAuthToken: "write",
+		ReadAuthToken:  "read",
+		AuthRateLimit:  ratelimit.Limit{Requests: 2, Period: time.Minute},
+	}}
+	r := newTestRouter(t, s, newTestLogic())
+
+	// Requests with invalid tokens are limited before the authorizer rejects them
+	for i := 0; i < 2; i++ {
+		res := serveFrom(r, testRequest("GET", "/api/tiny", "wrong", nil), "192.0.2.1:1234")
+		assert.Equal(http.StatusUnauthorized, res.Code)
+	}
+	res := serveFrom(r, testRequest("GET", "/api/tiny", "wrong", nil), "192.0.2.1:1234")
+	assert.Equal(http.StatusTooManyRequests, res.Code)
+	assert.Equal("30", res.Header().Get("Retry-After"))
+	res = serveFrom(r, testRequest("GET", "/api/tiny", "read", nil), "192.0.2.1:1234")
+	assert.Equal(http.StatusTooManyRequests, res.Code, "Valid tokens of the same client IP should be limited as well")
+
+	res = serveFrom(r, testRequest("GET", "/api/tiny", "read", nil), "192.0.2.2:1234")
+	assert.Equal(http.StatusOK, res.Code, "Other client IPs should not be limited")
+	for i := 0; i < 5; i++ {
+		res = serveFrom(r, testRequest("GET", "/api/tiny/foo", "", nil), "192.0.2.1:1234")
+		assert.Equal(http.StatusNotFound, res.Code, "Routes without token should not be limited")
+	}
+}
+
+func Test_RateLimitPerToken(t *testing.T) {
+	assert := assert.New(t)
+	s := &Server{cfg: &Config{
+		ReadAuthToken: "read",
+		ReadRateLimit: ratelimit.Limit{Requests: 1, Period: 10 * time.Second},
+	}}
+	r := newTestRouter(t, s, newTestLogic())
+
+	res := serveFrom(r, testRequest("GET", "/api/tiny", "read", nil), "192.0.2.1:1234")
+	assert.Equal(http.StatusOK, res.Code)
+	res = serveFrom(r, testRequest("GET", "/api/tiny", "read", nil), "192.0.2.2:1234")
+	assert.Equal(http.StatusTooManyRequests, res.Code, "Requests with a token should be limited per token")
+	assert.Equal("10", res.Header().Get("Retry-After"))
+	res = serveFrom(r, testRequest("GET", "/api/tiny", "wrong", nil), "192.0.2.3:1234")
+	assert.Equal(http.StatusUnauthorized, res.Code)
+}
+
+// serveFrom serves the request from the remote address
+func serveFrom(h http.Handler, req *http.Request, remoteAddr string) *httptest.ResponseRecorder {
+	req.RemoteAddr = remoteAddr
+
+	return serve(h, req)
+}
@@ -2,34 +2,86 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/chrisvdg/gotiny/acmecert"
+	"github.com/chrisvdg/gotiny/analytics"
+	"github.com/chrisvdg/gotiny/backend"
 	"github.com/chrisvdg/gotiny/business"
+	"github.com/chrisvdg/gotiny/policy"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 )
 
 const defaultBackendFile string = "./backend.json"
+const defaultJournalFile string = "./backend.journal"
+const defaultBoltFile string = "./backend.db"
+const defaultACMECacheDir string = "./acme"
+
+// DefaultShutdownTimeout is the duration requests in progress are drained for when the server stops
+const DefaultShutdownTimeout = 5 * time.Second
+
+const (
+	// FileBackend selects the backend that stores all entries in a single JSON file
+	FileBackend = "file"
+	// JournalBackend selects the backend that appends every change to a journal file
+	JournalBackend = "journal"
+	// MemoryBackend selects the backend that only keeps entries in memory
+	MemoryBackend = "memory"
+	// BoltBackend selects the backend that stores entries in an embedded key/value file
+	BoltBackend = "bolt"
+	// SQLBackend selects the backend that stores entries in an SQL database
+	SQLBackend = "sql"
+	// RedisBackend selects the backend that stores entries in a Redis compatible server
+	RedisBackend = "redis"
+)
 
 // New creates a new server instance
+// returns an error when the config is invalid, see Config.Validate
 func New(c *Config) (*Server, error) {
+	err := c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
 	return &Server{cfg: c}, nil
 }
 
 // Server represents a server instance
 type Server struct {
-	cfg *Config
+	// mu guards the config and the settings that can be replaced by Reload while serving
+	mu   sync.Mutex
+	cfg  *Config
+	auth *DefaultAuthorizer
+	cert *certificate
+}
+
+// config returns the current config
+func (s *Server) config() *Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cfg
 }
 
 // AddAPIRoutes adds the API routes with default handlers
 func (s *Server) AddAPIRoutes(r *mux.Router, b *business.Logic) error {
 	h := &DefaultHandlers{b: b}
 
-	auth := &DefaultAuthorizer{}
-	s.AddAPIRoutesAndHandlers(r, h, auth)
+	auth, err := NewAuthorizer("", "", false)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	return s.AddAPIRoutesAndHandlers(r, h, auth)
 }
 
 // AddAPIRoutesAndHandlers adds the API routes with provided handlers and authorizers
@@ -37,19 +89,37 @@ func (s *Server) AddAPIRoutesAndHandlers(r *mux.Router, handlers Handlers, auth
 	if r == nil {
 		return fmt.Errorf("Router is nil")
 	}
-	listHandler := http.HandlerFunc(handlers.List)
-	createHandler := http.HandlerFunc(handlers.CreateTinyURL)
-	updateHandler := http.HandlerFunc(handlers.UpdateTinyURL)
-	expandHandler := http.HandlerFunc(handlers.ExpandURL)
-	deleteHandler := http.HandlerFunc(handlers.RemoveTinyURL)
+	// Rate limits are applied after authorizing, so requests with a token are limited per token
+	limits := s.newRateLimiters()
+	listHandler := rateLimit(limits.read, http.HandlerFunc(handlers.List))
+	createHandler := rateLimit(limits.create, http.HandlerFunc(handlers.CreateTinyURL))
+	followHandler := rateLimit(limits.redirect, http.HandlerFunc(handlers.FollowURL))
+	unlockHandler := rateLimit(limits.redirect, http.HandlerFunc(handlers.UnlockURL))
+	updateHandler := rateLimit(limits.write, http.HandlerFunc(handlers.UpdateTinyURL))
+	expandHandler := rateLimit(limits.read, http.HandlerFunc(handlers.ExpandURL))
+	deleteHandler := rateLimit(limits.write, http.HandlerFunc(handlers.RemoveTinyURL))
+	statsHandler := rateLimit(limits.read, http.HandlerFunc(handlers.Stats))
+	listTokensHandler := rateLimit(limits.write, http.HandlerFunc(handlers.ListTokens))
+	createTokenHandler := rateLimit(limits.write, http.HandlerFunc(handlers.CreateToken))
+	revokeTokenHandler := rateLimit(limits.write, http.HandlerFunc(handlers.RevokeToken))
+	// Routes that require a token are also limited per client IP before authorizing,
+	// so requests with invalid tokens are limited as well
+	authLimit := func(next http.Handler) http.Handler {
+		return clientRateLimit(limits.auth, next)
+	}
 
 	r.HandleFunc("/api", handlers.APISpec).Methods("GET")
-	r.Handle("/api/tiny", auth.AuthenticateRead(listHandler)).Methods("GET")
-	r.Handle("/api/tiny", auth.AuthenticateCreate(createHandler)).Methods("POST")
-	r.HandleFunc("/api/tiny/{id}", handlers.FollowURL).Methods("GET")
-	r.Handle("/api/tiny/{id}", auth.AuthenticateWrite(updateHandler)).Methods("POST")
-	r.Handle("/api/tiny/{id}", auth.AuthenticateWrite(deleteHandler)).Methods("DELETE")
-	r.Handle("/api/tiny/{id}/expand", auth.AuthenticateRead(expandHandler)).Methods("GET")
+	r.Handle("/api/tiny", authLimit(auth.AuthenticateRead(listHandler))).Methods("GET")
+	r.Handle("/api/tiny", authLimit(auth.AuthenticateCreate(createHandler))).Methods("POST")
+	r.Handle("/api/tiny/{id}", followHandler).Methods("GET", "HEAD")
+	r.Handle("/api/tiny/{id}/unlock", unlockHandler).Methods("POST")
+	r.Handle("/api/tiny/{id}", authLimit(auth.AuthenticateWrite(updateHandler))).Methods("POST")
+	r.Handle("/api/tiny/{id}", authLimit(auth.AuthenticateDelete(deleteHandler))).Methods("DELETE")
+	r.Handle("/api/tiny/{id}/expand", authLimit(auth.AuthenticateRead(expandHandler))).Methods("GET")
+	r.Handle("/api/tiny/{id}/stats", authLimit(auth.AuthenticateRead(statsHandler))).Methods("GET")
+	r.Handle("/api/admin/tokens", authLimit(auth.AuthenticateAdmin(listTokensHandler))).Methods("GET")
+	r.Handle("/api/admin/tokens", authLimit(auth.AuthenticateAdmin(createTokenHandler))).Methods("POST")
+	r.Handle("/api/admin/tokens/{id}", authLimit(auth.AuthenticateAdmin(revokeTokenHandler))).Methods("DELETE")
 
 	return nil
 }
@@ -57,66 +127,392 @@ func (s *Server) AddAPIRoutesAndHandlers(r *mux.Router, handlers Handlers, auth
 // ListenAndServeFileBackedAPI sets the API routes only with a file backend
 // and listens for requests and serves them
 func (s *Server) ListenAndServeFileBackedAPI() error {
-	file := s.cfg.FileBackendPath
-	if file == "" {
-		file = defaultBackendFile
+	fb, err := s.newFileBackend()
+	if err != nil {
+		return err
 	}
-	b, err := business.NewFileBackedLogic(file, s.cfg.PrettyJSON, 5)
+
+	ctx, stop := signalContext(syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	return s.listenAndServeBackend(ctx, fb)
+}
+
+// ListenAndServeBackendAPI sets the API routes with the backend selected in the config
+// and listens for requests and serves them
+func (s *Server) ListenAndServeBackendAPI() error {
+	b, err := s.newBackend()
 	if err != nil {
 		return err
 	}
-	h, err := NewDefaultHandlers(b)
+
+	ctx, stop := signalContext(syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	return s.listenAndServeBackend(ctx, b)
+}
+
+// listenAndServeBackend sets the API routes with default handlers on top of the provided backend
+// and listens for requests and serves them until ctx is done
+// The backend is closed when the server stops
+func (s *Server) listenAndServeBackend(ctx context.Context, b backend.Backend) error {
+	cfg := s.config()
+	l := business.NewLogic(b, cfg.PrettyJSON, cfg.GeneratedIDLen)
+	defer func() {
+		err := l.Close()
+		if err != nil {
+			log.Errorf("Failed to close backend: %s", err)
+		}
+	}()
+	if cfg.DefaultRedirect != 0 {
+		err := l.SetDefaultRedirect(cfg.DefaultRedirect)
+		if err != nil {
+			return fmt.Errorf("invalid default redirect %d: %s", cfg.DefaultRedirect, err)
+		}
+	}
+	if cfg.PolicyFile != "" {
+		p, err := policy.NewFile(cfg.PolicyFile)
+		if err != nil {
+			return err
+		}
+		defer p.Close()
+		if cfg.PolicyReloadInterval > 0 {
+			p.Watch(cfg.PolicyReloadInterval)
+		}
+		l.SetPolicy(p)
+	}
+	if cfg.ExpiryReapInterval > 0 {
+		l.StartReaper(cfg.ExpiryReapInterval, cfg.ExpiryGracePeriod)
+	}
+	if !cfg.DisableStats {
+		store, err := s.newStatsStore()
+		if err != nil {
+			return err
+		}
+		l.EnableAnalytics(store, analytics.RecorderOptions{
+			Salt:       []byte(cfg.StatsSalt),
+			Classifier: analytics.NewClassifier(cfg.StatsBotUserAgents),
+			SkipBots:   cfg.StatsSkipBots,
+		})
+	}
+	if cfg.AdminAuthToken != "" && !l.SupportsTokens() {
+		return fmt.Errorf("the %s backend can not store tokens", cfg.Backend)
+	}
+	if cfg.AdminAuthToken != "" && !l.SupportsEntryOptions() {
+		return fmt.Errorf("the %s backend can not store entry owners", cfg.Backend)
+	}
+	// Without an admin token nobody could manage entries of other owners
+	l.SetEnforceOwnership(cfg.AdminAuthToken != "")
+	h, err := NewDefaultHandlers(l)
 	if err != nil {
 		return err
 	}
 
-	s.ListenAndServeAPI(h)
+	auth, err := s.newAuthorizer(l)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	return s.listenAndServeAPI(ctx, h, auth)
+}
+
+// newBackend creates the backend selected in the config
+func (s *Server) newBackend() (backend.Backend, error) {
+	cfg := s.config()
+	switch cfg.Backend {
+	case "", FileBackend:
+		return s.newFileBackend()
+	case JournalBackend:
+		file := cfg.JournalBackendPath
+		if file == "" {
+			file = defaultJournalFile
+		}
+		return backend.NewJournal(file, cfg.JournalCompactSize)
+	case BoltBackend:
+		file := cfg.BoltBackendPath
+		if file == "" {
+			file = defaultBoltFile
+		}
+		return backend.NewBolt(file)
+	case SQLBackend:
+		return backend.NewSQL(cfg.SQLDriver, cfg.SQLDSN)
+	case RedisBackend:
+		return backend.NewRedis(cfg.RedisURL, cfg.RedisKeyPrefix)
+	case MemoryBackend:
+		log.Warn("Using the memory backend, tiny URL entries are lost when the server stops")
+		return backend.NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", cfg.Backend)
+	}
+}
+
+// newFileBackend creates a file backend from the config
+func (s *Server) newFileBackend() (*backend.File, error) {
+	cfg := s.config()
+	file := cfg.FileBackendPath
+	if file == "" {
+		file = defaultBackendFile
+	}
+
+	return backend.NewFileWithOptions(file, backend.FileOptions{
+		Backup: cfg.FileBackendBackup,
+	})
+}
+
+// newStatsStore creates the analytics store from the config
+// Statistics are kept in memory only unless a statistics file is set
+func (s *Server) newStatsStore() (analytics.Store, error) {
+	cfg := s.config()
+	if cfg.StatsFile == "" {
+		return analytics.NewMemoryStore(), nil
+	}
+
+	return analytics.NewFileStore(cfg.StatsFile)
 }
 
 // ListenAndServeAPI sets the API routes only with provided backend
 // and listens for requests and serves them
 func (s *Server) ListenAndServeAPI(handlers Handlers) error {
+	auth, err := s.newAuthorizer(nil)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signalContext(syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	return s.listenAndServeAPI(ctx, handlers, auth)
+}
+
+// newAuthorizer creates the default authorizer from the config
+// Stored tokens are accepted when tokens is not nil
+// Its tokens are replaced by Reload
+func (s *Server) newAuthorizer(tokens TokenAuthenticator) (*DefaultAuthorizer, error) {
+	cfg := s.config()
+	auth, err := NewAuthorizerWithOptions(cfg.ReadAuthToken, cfg.WriteAuthToken, cfg.AllowPublicCreateGenerated, AuthorizerOptions{
+		AdminToken: cfg.AdminAuthToken,
+		Tokens:     tokens,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.auth = auth
+	s.mu.Unlock()
+
+	return auth, nil
+}
+
+// listenAndServeAPI sets the API routes with provided handlers and authorizer
+// and listens for requests and serves them until ctx is done
+func (s *Server) listenAndServeAPI(ctx context.Context, handlers Handlers, auth Authorizer) error {
+	cfg := s.config()
+	trusted, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return err
+	}
 	r := mux.NewRouter()
-	auth := NewAuthorizer(s.cfg.ReadAuthToken, s.cfg.WriteAuthToken, s.cfg.AllowPublicCreateGenerated)
-	err := s.AddAPIRoutesAndHandlers(r, handlers, auth)
+	err = s.AddAPIRoutesAndHandlers(r, handlers, auth)
 	if err != nil {
 		return err
 	}
-	s.ListenAndServe(r)
 
-	return nil
+	return s.ListenAndServeContext(ctx, realIP(trusted, r))
 }
 
-// ListenAndServe listens for requests and serves them
-func (s *Server) ListenAndServe(handler http.Handler) {
-	ctx, cancel := context.WithCancel(context.Background())
+// newACMEManager creates the ACME certificate manager from the config
+func (s *Server) newACMEManager() (*acmecert.Manager, error) {
+	cfg := s.config()
+	cacheDir := cfg.ACME.CacheDir
+	if cacheDir == "" {
+		cacheDir = defaultACMECacheDir
+	}
+
+	return acmecert.New(acmecert.Options{
+		Domains:      cfg.ACME.Domains,
+		CacheDir:     cacheDir,
+		DirectoryURL: cfg.ACME.DirectoryURL,
+		Email:        cfg.ACME.Email,
+	})
+}
+
+// ListenAndServe listens for requests and serves them until SIGINT or SIGTERM is received
+// see ListenAndServeContext
+func (s *Server) ListenAndServe(handler http.Handler) error {
+	ctx, stop := signalContext(syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	return s.ListenAndServeContext(ctx, handler)
+}
+
+// ListenAndServeContext listens for requests and serves them until ctx is done or a listener fails
+// Requests in progress are then drained for at most the shutdown timeout before the listeners are closed
+func (s *Server) ListenAndServeContext(ctx context.Context, handler http.Handler) error {
+	cfg := s.config()
+	var tlsConfig *tls.Config
+	var acmeHTTP func(http.Handler) http.Handler
+	switch {
+	case cfg.acme():
+		m, err := s.newACMEManager()
+		if err != nil {
+			return err
+		}
+		acmeHTTP = m.HTTPHandler
+		tlsConfig = m.TLSConfig()
+	case cfg.tlsFiles():
+		// The key pair is served through GetCertificate, so Reload can replace it
+		cert, err := newCertificate(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.cert = cert
+		s.mu.Unlock()
+		tlsConfig = &tls.Config{GetCertificate: cert.GetCertificate}
+	}
+
+	httpHandler, tlsHandler := listenerHandlers(cfg, handler, acmeHTTP)
+
+	errs := make(chan error, 2)
+	var servers []*http.Server
+	if !cfg.TLSOnly {
+		srv := &http.Server{Addr: cfg.ListenAddr, Handler: httpHandler}
+		servers = append(servers, srv)
+		go func() {
+			log.Infof("http server listening on: localhost%s\n", srv.Addr)
+			errs <- srv.ListenAndServe()
+		}()
+	}
+	if tlsConfig != nil {
+		srv := &http.Server{
+			Addr:      cfg.TLSListenAddr,
+			Handler:   tlsHandler,
+			TLSConfig: tlsConfig,
+		}
+		servers = append(servers, srv)
+		go func() {
+			log.Infof("https server listening on: localhost%s\n", srv.Addr)
+			errs <- srv.ListenAndServeTLS("", "")
+		}()
+	}
+	if len(servers) == 0 {
+		return fmt.Errorf("no listener enabled, TLS only requires a TLS certificate and key or ACME")
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+		err = fmt.Errorf("listener failed: %s", err)
+	}
+
+	timeout := cfg.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	log.Infof("Shutting down, draining requests for at most %s", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
+	for _, srv := range servers {
+		shutdownErr := srv.Shutdown(shutdownCtx)
+		if shutdownErr != nil {
+			log.Errorf("Failed to drain requests of %s: %s", srv.Addr, shutdownErr)
+			srv.Close()
+		}
+	}
 
-	tlsEnabled := s.cfg.TLS.CertFile != "" && s.cfg.TLS.KeyFile != ""
+	return err
+}
 
-	if !s.cfg.TLSOnly {
-		go listenAndServe(ctx, cancel, s.cfg.ListenAddr, handler)
+// Reload applies the tokens, AllowPublicCreateGenerated and the TLS key pair of the config while serving
+// The TLS key pair is reloaded from its files, also when their paths did not change
+// Nothing is applied when the config is invalid or a token or the key pair can not be loaded
+// Other settings require a restart, changes to them are logged and ignored
+func (s *Server) Reload(c *Config) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.auth == nil && s.cert == nil {
+		return fmt.Errorf("the server is not serving yet")
+	}
+	if (c.AdminAuthToken == "") != (s.cfg.AdminAuthToken == "") {
+		return fmt.Errorf("setting or removing the admin token requires a restart")
+	}
+	tlsEnabled := c.TLS != nil && c.TLS.CertFile != ""
+	if tlsEnabled != (s.cert != nil) {
+		return fmt.Errorf("enabling or disabling TLS requires a restart")
 	}
 
-	if tlsEnabled {
-		go listenAndServeTLS(ctx, cancel, s.cfg.TLSListenAddr, s.cfg.TLS, handler)
+	// The tokens are only replaced once the key pair is loaded
+	auth, err := newAuthConfig(c.ReadAuthToken, c.WriteAuthToken, c.AdminAuthToken, c.AllowPublicCreateGenerated)
+	if err != nil {
+		return err
+	}
+	if s.cert != nil {
+		err = s.cert.load(c.TLS.CertFile, c.TLS.KeyFile)
+		if err != nil {
+			return err
+		}
+	}
+	if s.auth != nil {
+		s.auth.config.Store(auth)
+	}
+	for _, name := range restartSettings(s.cfg, c) {
+		log.Warnf("Changing %s requires a restart, the change is ignored", name)
 	}
+	// Only the applied settings are replaced, so the ignored changes are reported again by later reloads
+	cfg := *s.cfg
+	cfg.ReadAuthToken, cfg.WriteAuthToken, cfg.AdminAuthToken = c.ReadAuthToken, c.WriteAuthToken, c.AdminAuthToken
+	cfg.AllowPublicCreateGenerated = c.AllowPublicCreateGenerated
+	cfg.TLS = c.TLS
+	cfg.Verbose = c.Verbose
+	s.cfg = &cfg
+	log.Info("Reloaded config")
 
-	<-ctx.Done()
+	return nil
 }
 
-// listenAndServe serves a plain http webserver
-func listenAndServe(ctx context.Context, cancel func(), addr string, handler http.Handler) {
-	defer cancel()
-	log.Infof("http server listening on: localhost%s\n", addr)
-	log.Print(http.ListenAndServe(addr, handler))
+// restartSettings returns the names of the changed settings that Reload does not apply
+func restartSettings(current *Config, next *Config) []string {
+	a, b := *current, *next
+	// Clear the settings Reload applies and the log level, which is up to the caller
+	for _, c := range []*Config{&a, &b} {
+		c.ReadAuthToken, c.WriteAuthToken, c.AdminAuthToken = "", "", ""
+		c.AllowPublicCreateGenerated = false
+		c.TLS = nil
+		c.Verbose = false
+	}
+
+	var changed []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, va.Type().Field(i).Name)
+		}
+	}
+
+	return changed
 }
 
-// listenAndServeTLS serves a tls webserver
-func listenAndServeTLS(ctx context.Context, cancel func(), addr string, tls *TLSConfig, handler http.Handler) {
-	defer cancel()
-	log.Infof("https server listening on: localhost%s\n", addr)
-	log.Print(http.ListenAndServeTLS(addr, tls.CertFile, tls.KeyFile, handler))
+// signalContext returns a context that is cancelled when one of the signals is received
+// Signals received after that are no longer caught, so a second signal stops the process immediately
+func signalContext(signals ...os.Signal) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	go func() {
+		select {
+		case sig := <-ch:
+			log.Infof("Received %s", sig)
+		case <-ctx.Done():
+		}
+		signal.Stop(ch)
+		cancel()
+	}()
+
+	return ctx, cancel
 }
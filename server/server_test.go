@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chrisvdg/gotiny/backend"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ListenAndServeShutdown(t *testing.T) {
+	assert := assert.New(t)
+	b := &slowBackend{
+		Memory:  backend.NewMemory(),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	_, err := b.Create("slow", "http://foo.bar")
+	assert.NoError(err)
+	addr := freeAddr(t)
+	s, err := New(&Config{ListenAddr: addr, GeneratedIDLen: 5, DisableStats: true, ShutdownTimeout: 5 * time.Second})
+	assert.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- s.listenAndServeBackend(ctx, b)
+	}()
+	assert.Eventually(func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "The server should listen")
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	codes := make(chan int, 1)
+	go func() {
+		res, err := client.Get("http://" + addr + "/api/tiny/slow")
+		if err != nil {
+			codes <- 0
+			return
+		}
+		res.Body.Close()
+		codes <- res.StatusCode
+	}()
+	<-b.started
+	cancel()
+
+	// The server keeps the backend open until the request in flight completes
+	select {
+	case err := <-served:
+		t.Fatalf("The server stopped before the request in flight completed: %v", err)
+	case <-b.closed:
+		t.Fatal("The backend was closed before the request in flight completed")
+	case <-time.After(100 * time.Millisecond):
+	}
+	_, err = net.Dial("tcp", addr)
+	assert.Error(err, "New connections should be refused while draining")
+
+	close(b.release)
+	select {
+	case code := <-codes:
+		assert.Equal(http.StatusFound, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("The request in flight should complete")
+	}
+	select {
+	case err := <-served:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("The server should stop once the request in flight completed")
+	}
+	select {
+	case <-b.closed:
+	default:
+		t.Fatal("The backend should be closed when the server stops")
+	}
+}
+
+// slowBackend is a memory backend that blocks getting the entry with ID slow until it is released
+type slowBackend struct {
+	*backend.Memory
+	started chan struct{}
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func (b *slowBackend) Get(id string) (backend.TinyURL, error) {
+	if id == "slow" {
+		close(b.started)
+		<-b.release
+	}
+
+	return b.Memory.Get(id)
+}
+
+func (b *slowBackend) Close() error {
+	close(b.closed)
+	return b.Memory.Close()
+}
+
+// freeAddr returns a local address with a port that is not in use
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %s", err)
+	}
+	defer l.Close()
+
+	return l.Addr().String()
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// certificate serves a TLS key pair that can be replaced while the server is running
+// It is safe for concurrent use
+type certificate struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// newCertificate loads the key pair of the certificate and key files
+func newCertificate(certFile string, keyFile string) (*certificate, error) {
+	c := &certificate{}
+	err := c.load(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// load replaces the key pair with the one of the certificate and key files
+// The current key pair is kept when the files can not be loaded
+func (c *certificate) load(certFile string, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS key pair: %s", err)
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+
+	return nil
+}
+
+// GetCertificate returns the current key pair, see tls.Config.GetCertificate
+func (c *certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+// listenerHandlers returns the handlers of the http and the https listener for the config
+// acmeHTTP answers HTTP-01 challenges on the http listener, also when it redirects, it is nil without ACME
+func listenerHandlers(cfg *Config, handler http.Handler, acmeHTTP func(http.Handler) http.Handler) (http.Handler, http.Handler) {
+	httpHandler := handler
+	if cfg.HTTPSRedirect {
+		httpHandler = httpsRedirect(cfg.TLSListenAddr)
+	}
+	if acmeHTTP != nil {
+		httpHandler = acmeHTTP(httpHandler)
+	}
+	tlsHandler := handler
+	if cfg.HSTS != "" {
+		tlsHandler = hsts(cfg.HSTS, handler)
+	}
+
+	return httpHandler, tlsHandler
+}
+
+// httpsRedirect redirects every request permanently to the same URL on the https listener
+// The redirect keeps the method and body of the request
+func httpsRedirect(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			// IPv6 addresses without port keep their brackets in the Host header
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		switch {
+		case port != "" && port != "443" && port != "https":
+			host = net.JoinHostPort(host, port)
+		case strings.Contains(host, ":"):
+			// IPv6 addresses keep their brackets without port
+			host = "[" + host + "]"
+		}
+		http.Redirect(res, req, "https://"+host+req.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// hsts adds the Strict-Transport-Security header to the responses of next
+func hsts(value string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(res, req)
+	})
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/chrisvdg/gotiny/acmecert"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_HTTPSRedirect(t *testing.T) {
+	for _, c := range []struct {
+		host, tlsAddr, location string
+	}{
+		{"example.com", ":443", "https://example.com/api/tiny/foo?q=1"},
+		{"example.com:8080", ":443", "https://example.com/api/tiny/foo?q=1"},
+		{"example.com:8080", ":https", "https://example.com/api/tiny/foo?q=1"},
+		{"example.com:8080", "", "https://example.com/api/tiny/foo?q=1"},
+		{"example.com", ":8443", "https://example.com:8443/api/tiny/foo?q=1"},
+		{"example.com:8080", "0.0.0.0:8443", "https://example.com:8443/api/tiny/foo?q=1"},
+		{"192.0.2.1:8080", ":8443", "https://192.0.2.1:8443/api/tiny/foo?q=1"},
+		{"[::1]:8080", ":443", "https://[::1]/api/tiny/foo?q=1"},
+		{"[::1]", ":443", "https://[::1]/api/tiny/foo?q=1"},
+		{"[::1]:8080", ":8443", "https://[::1]:8443/api/tiny/foo?q=1"},
+		{"[2001:db8::1]", "[::]:8443", "https://[2001:db8::1]:8443/api/tiny/foo?q=1"},
+	} {
+		for _, method := range []string{"GET", "POST"} {
+			req := testRequest(method, "/api/tiny/foo?q=1", "", nil)
+			req.Host = c.host
+			res := serve(httpsRedirect(c.tlsAddr), req)
+			assert.Equal(t, http.StatusPermanentRedirect, res.Code, "%s %s", method, c.host)
+			assert.Equal(t, c.location, res.Header().Get("Location"), "%s with TLS address %q", c.host, c.tlsAddr)
+		}
+	}
+}
+
+func Test_ListenerHandlers(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "gotiny-acme")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	m, err := acmecert.New(acmecert.Options{Domains: []string{"example.com"}, CacheDir: dir})
+	assert.NoError(err)
+	handler := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {})
+	cfg := &Config{TLSListenAddr: ":8443", HTTPSRedirect: true, HSTS: "max-age=31536000"}
+
+	httpHandler, tlsHandler := listenerHandlers(cfg, handler, m.HTTPHandler)
+	res := serve(httpHandler, testRequest("GET", "/api/tiny/foo", "", nil))
+	assert.Equal(http.StatusPermanentRedirect, res.Code)
+	assert.Equal("https://example.com:8443/api/tiny/foo", res.Header().Get("Location"))
+	assert.Empty(res.Header().Get("Strict-Transport-Security"), "HSTS should only be sent by the https listener")
+	res = serve(httpHandler, testRequest("GET", acmecert.ChallengePath+"token", "", nil))
+	assert.Equal(http.StatusNotFound, res.Code, "ACME challenges should be answered instead of redirected")
+	assert.Empty(res.Header().Get("Location"))
+	assert.Empty(res.Header().Get("Strict-Transport-Security"))
+	res = serve(tlsHandler, testRequest("GET", "/api/tiny/foo", "", nil))
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Equal("max-age=31536000", res.Header().Get("Strict-Transport-Security"))
+	res = serve(tlsHandler, testRequest("GET", acmecert.ChallengePath+"token", "", nil))
+	assert.Equal(http.StatusOK, res.Code, "The https listener should not answer HTTP-01 challenges")
+
+	// Without redirect the http listener serves the handler, still without HSTS
+	cfg.HTTPSRedirect = false
+	httpHandler, _ = listenerHandlers(cfg, handler, nil)
+	res = serve(httpHandler, testRequest("GET", "/api/tiny/foo", "", nil))
+	assert.Equal(http.StatusOK, res.Code)
+	assert.Empty(res.Header().Get("Strict-Transport-Security"))
+
+	cfg.HSTS = ""
+	_, tlsHandler = listenerHandlers(cfg, handler, nil)
+	res = serve(tlsHandler, testRequest("GET", "/api/tiny/foo", "", nil))
+	assert.Empty(res.Header().Get("Strict-Transport-Security"))
+}
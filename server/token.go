@@ -0,0 +1,237 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/chrisvdg/gotiny/business"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Token hash algorithms
+const (
+	HashBcrypt = "bcrypt"
+	HashArgon2 = "argon2"
+)
+
+// Argon2id parameters of hashes created by HashToken
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// maxSlowHashes is the amount of bcrypt or argon2 checks that run at once,
+// an argon2 check of a hash created by HashToken uses 64 MiB of memory
+const maxSlowHashes = 2
+
+// slowHashes limits the concurrent bcrypt and argon2 checks, further checks wait for a slot
+var slowHashes = make(chan struct{}, maxSlowHashes)
+
+// HashToken returns the hash of a token with the provided algorithm (bcrypt or argon2),
+// which can be configured instead of the token itself
+func HashToken(token string, algorithm string) (string, error) {
+	if token == "" {
+		return "", fmt.Errorf("empty token")
+	}
+
+	switch algorithm {
+	case HashBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(token), bcrypt.DefaultCost)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash token: %s", err)
+		}
+		return string(hash), nil
+	case HashArgon2:
+		salt := make([]byte, argon2SaltLen)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate salt: %s", err)
+		}
+		key := argon2.IDKey([]byte(token), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	default:
+		return "", fmt.Errorf("unknown hash algorithm %q, supported are %s and %s", algorithm, HashBcrypt, HashArgon2)
+	}
+}
+
+// IsTokenHash returns true if a configured token value is a bcrypt or argon2 hash
+func IsTokenHash(value string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$", "$argon2id$", "$argon2i$"} {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newConfigToken returns a token configured at startup
+// value is either the token itself or a bcrypt or argon2 hash of the token, an empty value is not set
+func newConfigToken(value string) (*configToken, error) {
+	t := &configToken{}
+	if value == "" {
+		return t, nil
+	}
+	t.set = true
+	if !IsTokenHash(value) {
+		if business.IsStoredToken(value) {
+			return nil, fmt.Errorf("the token has the format of tokens stored in the backend, which are only looked up there")
+		}
+		t.sum = sha256.Sum256([]byte(value))
+		return t, nil
+	}
+
+	t.hash = value
+	if strings.HasPrefix(value, "$argon2") {
+		_, err := parseArgon2Hash(value)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		_, err := bcrypt.Cost([]byte(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bcrypt token hash: %s", err)
+		}
+	}
+	t.macKey = make([]byte, sha256.Size)
+	_, err := rand.Read(t.macKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token MAC key: %s", err)
+	}
+
+	return t, nil
+}
+
+// configToken represents a token set in the config
+// Tokens are compared in constant time, only a SHA-256 sum of plain tokens is kept in memory
+// Once a token matched a hash, its HMAC-SHA-256 under a random key is kept so later requests skip the slow hash
+// Whoever can read the memory of the process can still check guesses against it as fast as SHA-256,
+// the slow hash protects the configured value at rest
+// It is safe for concurrent use
+type configToken struct {
+	set  bool
+	hash string
+	// sum is the SHA-256 sum of a plain token
+	sum [sha256.Size]byte
+	// macKey is the random key of the MAC of a hashed token, it is generated for every configured token
+	macKey []byte
+
+	mu       sync.RWMutex
+	verified bool
+	// mac is the HMAC of the token that matched the hash
+	mac []byte
+}
+
+// isSet returns true if a token was configured
+func (t *configToken) isSet() bool {
+	return t != nil && t.set
+}
+
+// matches returns true if token is the configured token
+func (t *configToken) matches(token string) bool {
+	if !t.isSet() || token == "" {
+		return false
+	}
+	if t.hash == "" {
+		sum := sha256.Sum256([]byte(token))
+		return subtle.ConstantTimeCompare(sum[:], t.sum[:]) == 1
+	}
+
+	m := hmac.New(sha256.New, t.macKey)
+	m.Write([]byte(token))
+	mac := m.Sum(nil)
+	t.mu.RLock()
+	verified, known := t.verified, t.mac
+	t.mu.RUnlock()
+	if verified {
+		return hmac.Equal(mac, known)
+	}
+
+	if !verifyTokenHash(t.hash, token) {
+		return false
+	}
+	t.mu.Lock()
+	t.mac = mac
+	t.verified = true
+	t.mu.Unlock()
+
+	return true
+}
+
+// verifyTokenHash returns true if the token matches the bcrypt or argon2 hash
+// It waits until one of the slow hash slots is free
+func verifyTokenHash(hash string, token string) bool {
+	slowHashes <- struct{}{}
+	defer func() {
+		<-slowHashes
+	}()
+
+	if !strings.HasPrefix(hash, "$argon2") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(token)) == nil
+	}
+
+	h, err := parseArgon2Hash(hash)
+	if err != nil {
+		return false
+	}
+	var key []byte
+	if h.variant == "argon2id" {
+		key = argon2.IDKey([]byte(token), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	} else {
+		key = argon2.Key([]byte(token), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	}
+
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// argon2Hash represents a parsed argon2 hash
+type argon2Hash struct {
+	variant string
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2Hash parses an argon2 hash in the PHC string format
+// e.g. $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key> with unpadded base64 salt and key
+func parseArgon2Hash(hash string) (argon2Hash, error) {
+	var h argon2Hash
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || (parts[1] != "argon2id" && parts[1] != "argon2i") {
+		return h, fmt.Errorf("invalid argon2 token hash")
+	}
+	h.variant = parts[1]
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return h, fmt.Errorf("unsupported argon2 token hash version")
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads)
+	if err != nil || h.time == 0 || h.threads == 0 {
+		return h, fmt.Errorf("invalid argon2 token hash parameters")
+	}
+	h.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return h, fmt.Errorf("invalid argon2 token hash salt: %s", err)
+	}
+	h.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(h.key) == 0 {
+		return h, fmt.Errorf("invalid argon2 token hash key")
+	}
+
+	return h, nil
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chrisvdg/gotiny/business"
+	"github.com/stretchr/testify/assert"
+)
+
+// storedToken has the format of tokens created by business.Logic.CreateToken
+var storedToken = "AbCd-_12." + strings.Repeat("a", 43)
+
+func Test_HashedConfigToken(t *testing.T) {
+	for _, algorithm := range []string{HashBcrypt, HashArgon2} {
+		t.Run(algorithm, func(t *testing.T) {
+			assert := assert.New(t)
+			hash, err := HashToken("secret", algorithm)
+			assert.NoError(err)
+			assert.True(IsTokenHash(hash))
+
+			token, err := newConfigToken(hash)
+			assert.NoError(err)
+			assert.False(token.matches("wrong"))
+			assert.False(token.verified, "Only a matching token should be remembered")
+			assert.True(token.matches("secret"))
+			assert.True(token.verified)
+			sum := sha256.Sum256([]byte("secret"))
+			assert.Equal([sha256.Size]byte{}, token.sum, "No unkeyed sum of a hashed token should be kept")
+			assert.NotEqual(sum[:], token.mac)
+			other, err := newConfigToken(hash)
+			assert.NoError(err)
+			assert.NotEqual(token.macKey, other.macKey, "Every token should have its own MAC key")
+
+			// Once verified, tokens are compared with the remembered sum while every slow hash slot is taken
+			fillSlowHashes()
+			defer releaseSlowHashes()
+			assert.True(token.matches("secret"))
+			assert.False(token.matches("wrong"))
+		})
+	}
+}
+
+func Test_InvalidConfigToken(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, value := range []string{"$2a$10$invalid", "$argon2id$v=19$m=65536,t=3,p=4$invalid", storedToken} {
+		_, err := newConfigToken(value)
+		assert.Error(err, value)
+	}
+}
+
+func Test_SlowHashesLimited(t *testing.T) {
+	assert := assert.New(t)
+	hash, err := HashToken("secret", HashBcrypt)
+	assert.NoError(err)
+	token, err := newConfigToken(hash)
+	assert.NoError(err)
+
+	fillSlowHashes()
+	matched := make(chan bool, 1)
+	go func() {
+		matched <- token.matches("wrong")
+	}()
+	select {
+	case <-matched:
+		t.Fatal("The hash should not be checked while every slow hash slot is taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	releaseSlowHashes()
+	select {
+	case ok := <-matched:
+		assert.False(ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("The hash should be checked once a slow hash slot is free")
+	}
+}
+
+func Test_StoredTokenSkipsSlowHashes(t *testing.T) {
+	assert := assert.New(t)
+	hash, err := HashToken("secret", HashBcrypt)
+	assert.NoError(err)
+	tokens := &fakeTokens{identity: business.Identity{TokenID: "AbCd-_12", Scopes: []string{business.ScopeRead}}}
+	auth, err := NewAuthorizerWithOptions(hash, hash, false, AuthorizerOptions{AdminToken: hash, Tokens: tokens})
+	assert.NoError(err)
+	handler := auth.AuthenticateRead(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {}))
+
+	fillSlowHashes()
+	defer releaseSlowHashes()
+	done := make(chan int, 1)
+	go func() {
+		req := httptest.NewRequest("GET", "/api/tiny", nil)
+		req.Header.Set("Authorization", "Bearer "+storedToken)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		done <- res.Code
+	}()
+	select {
+	case code := <-done:
+		assert.Equal(http.StatusOK, code)
+		assert.Equal([]string{storedToken}, tokens.tokens)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stored tokens should not wait for slow hash checks of the configured tokens")
+	}
+}
+
+// fakeTokens authenticates every token as its identity and records the tokens
+type fakeTokens struct {
+	identity business.Identity
+	tokens   []string
+}
+
+func (f *fakeTokens) AuthenticateToken(token string) (business.Identity, error) {
+	f.tokens = append(f.tokens, token)
+	return f.identity, nil
+}
+
+// fillSlowHashes takes every slow hash slot
+func fillSlowHashes() {
+	for i := 0; i < maxSlowHashes; i++ {
+		slowHashes <- struct{}{}
+	}
+}
+
+// releaseSlowHashes frees every slow hash slot
+func releaseSlowHashes() {
+	for i := 0; i < maxSlowHashes; i++ {
+		<-slowHashes
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// unlockTemplate is the page that prompts for the password of a protected tiny URL
+var unlockTemplate = template.Must(template.New("unlock").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+<style>
+body { font-family: sans-serif; max-width: 24em; margin: 4em auto; padding: 0 1em; }
+input { font-size: 1em; padding: 0.4em; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>Password required</h1>
+<p>The tiny URL <strong>{{.ID}}</strong> is password protected.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="/api/tiny/{{.ID}}/unlock">
+<input type="password" name="password" autocomplete="current-password" autofocus required>
+<input type="submit" value="Open">
+</form>
+</body>
+</html>
+`))
+
+// unlockPage represents the data of the unlock page
+type unlockPage struct {
+	ID    string
+	Error string
+}
+
+// writeUnlockPage writes the password prompt of a protected tiny URL
+func writeUnlockPage(res http.ResponseWriter, status int, page unlockPage) {
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.Header().Set("Cache-Control", "no-store")
+	res.WriteHeader(status)
+	err := unlockTemplate.Execute(res, page)
+	if err != nil {
+		log.Errorf("Failed to write unlock page: %s", err)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+// The SQLite driver requires cgo, binaries built with CGO_ENABLED=0 only include the postgres driver
+import _ "github.com/mattn/go-sqlite3" // Registers the sqlite3 driver for the sql backend
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic writes data to a temporary file next to filePath,
+// flushes it to disk and renames it over filePath
+// Readers either see the old or the new content, never a partially written file
+func WriteFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	tmpPath := filePath + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("failed to open temporary file: %s", err)
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write temporary file: %s", err)
+	}
+
+	err = os.Rename(tmpPath, filePath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace file: %s", err)
+	}
+
+	return syncDir(filepath.Dir(filePath))
+}
+
+// syncDir flushes the directory entry so a rename survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %s", err)
+	}
+	defer d.Close()
+
+	// Not every platform supports syncing directories, a failure here does not undo the rename
+	d.Sync()
+
+	return nil
+}
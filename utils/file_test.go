@@ -0,0 +1,28 @@
+package utils_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/chrisvdg/gotiny/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WriteFileAtomic(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "utils_test")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	filePath := path.Join(dir, "data.json")
+
+	assert.NoError(utils.WriteFileAtomic(filePath, []byte("first"), 0666))
+	assert.NoError(utils.WriteFileAtomic(filePath, []byte("second"), 0666))
+
+	data, err := ioutil.ReadFile(filePath)
+	assert.NoError(err)
+	assert.Equal("second", string(data))
+	_, err = os.Stat(filePath + ".tmp")
+	assert.True(os.IsNotExist(err), "The temporary file should not be left behind")
+}